@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// An agenda file is a text file with message markup (see markup.go) that replaces a
+// notification's message and is re-read every time its media is generated, so editing
+// the file updates the screen without editing the notification. It is either a file
+// name in AGENDA_DIR or an http(s) URL. Unlike a message_url, the line breaks and
+// markup are kept.
+var (
+	// agendaDir holds the local agenda files notifications can reference by name
+	agendaDir = envString("AGENDA_DIR", "/data/agendas")
+	// agendaTimeout bounds fetching a remote agenda
+	agendaTimeout = envDuration("AGENDA_TIMEOUT", 5*time.Second)
+)
+
+const (
+	// maxAgendaBytes is how much of an agenda file is read
+	maxAgendaBytes = 8192
+	// maxAgendaLength caps the agenda text, in characters
+	maxAgendaLength = 2000
+)
+
+// agendaClient fetches remote agendas with the address restrictions of message_url
+// (see newFetchClient and MESSAGE_URL_ALLOWED_NETWORKS)
+var agendaClient = newFetchClient(agendaTimeout)
+
+// lastGoodAgendas keeps the last agenda read successfully from each source, shown
+// while the source can't be read
+var (
+	lastGoodAgendas     = make(map[string]string)
+	lastGoodAgendaMutex sync.Mutex
+)
+
+// isRemoteAgenda reports whether an agenda reference is a URL rather than a file name
+func isRemoteAgenda(ref string) bool {
+	return strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://")
+}
+
+// validateAgendaFile checks an agenda reference: an http(s) URL, or the name of a file
+// in AGENDA_DIR (which only has to exist once media is generated)
+func validateAgendaFile(ref string) error {
+	if isRemoteAgenda(ref) {
+		if err := validateMessageURL(ref); err != nil {
+			return fmt.Errorf("invalid agenda_file '%s' (expected an http or https URL or a file name in AGENDA_DIR)", ref)
+		}
+		return nil
+	}
+	if filepath.Base(ref) != ref || strings.HasPrefix(ref, ".") {
+		return fmt.Errorf("invalid agenda_file '%s' (expected an http or https URL or a file name in AGENDA_DIR)", ref)
+	}
+	return nil
+}
+
+// readAgenda reads an agenda from its source
+func readAgenda(ref string) (string, error) {
+	var body []byte
+	if isRemoteAgenda(ref) {
+		resp, err := agendaClient.Get(ref)
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch agenda_file: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("agenda_file returned %s", resp.Status)
+		}
+		if body, err = io.ReadAll(io.LimitReader(resp.Body, maxAgendaBytes)); err != nil {
+			return "", fmt.Errorf("failed to read agenda_file: %w", err)
+		}
+	} else {
+		file, err := os.Open(filepath.Join(agendaDir, ref))
+		if err != nil {
+			return "", fmt.Errorf("failed to open agenda_file: %w", err)
+		}
+		defer file.Close()
+		if body, err = io.ReadAll(io.LimitReader(file, maxAgendaBytes)); err != nil {
+			return "", fmt.Errorf("failed to read agenda_file: %w", err)
+		}
+	}
+
+	text := strings.TrimSpace(strings.ToValidUTF8(string(body), ""))
+	if text == "" {
+		return "", errors.New("agenda_file is empty")
+	}
+	if utf8.RuneCountInString(text) > maxAgendaLength {
+		text = string([]rune(text)[:maxAgendaLength-1]) + "…"
+	}
+	return text, nil
+}
+
+// agendaSignature identifies the current version of a local agenda file for the media
+// hash (its size and modification time), so editing the file regenerates the media
+// before the next cast. Remote agendas are only re-read when the media is regenerated.
+func agendaSignature(ref string) string {
+	if ref == "" || isRemoteAgenda(ref) {
+		return ""
+	}
+	info, err := os.Stat(filepath.Join(agendaDir, ref))
+	if err != nil {
+		return "missing"
+	}
+	return fmt.Sprintf("%d/%d", info.Size(), info.ModTime().UnixNano())
+}
+
+// withAgenda replaces an agenda notification's message with the agenda for rendering.
+// When the agenda can't be read, the last version read successfully is used, or else
+// the notification's own message; agenda_error records the outcome.
+func withAgenda(notif Notification) Notification {
+	if notif.AgendaFile == "" {
+		return notif
+	}
+
+	text, err := readAgenda(notif.AgendaFile)
+	readError := ""
+	lastGoodAgendaMutex.Lock()
+	lastGood, haveLastGood := lastGoodAgendas[notif.AgendaFile]
+	if err == nil {
+		lastGoodAgendas[notif.AgendaFile] = text
+	}
+	lastGoodAgendaMutex.Unlock()
+
+	if err != nil {
+		readError = err.Error()
+		appInstance.recordFailure(notif.ID, "agenda_file", err)
+		if haveLastGood {
+			log.Printf("Using the last good agenda for notification %s: %v", notif.ID, err)
+			text = lastGood
+		} else {
+			log.Printf("Using the message for notification %s, its agenda was never read: %v", notif.ID, err)
+			text = notif.Message
+			if text == "" {
+				text = messageURLFallback
+			}
+		}
+	}
+
+	if readError != notif.AgendaError {
+		if _, dbErr := appInstance.DB.Exec("UPDATE notifications SET agenda_error = ? WHERE id = ?", readError, notif.ID); dbErr != nil {
+			log.Printf("Failed to record agenda_file outcome for notification %s: %v", notif.ID, dbErr)
+		}
+	}
+
+	notif.Message = text
+	notif.AgendaError = readError
+	return notif
+}
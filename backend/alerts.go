@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failure alerts tell the operator when a notification's cast or generation fails, so a
+// meeting screen that never appeared doesn't go unnoticed. ALERT_CHANNEL picks where
+// they go: "email" (through SMTP_HOST) or "webhook" (a JSON POST to ALERT_WEBHOOK_URL);
+// empty turns alerts off.
+var (
+	alertChannel    = strings.ToLower(envString("ALERT_CHANNEL", ""))
+	alertWebhookURL = envString("ALERT_WEBHOOK_URL", "")
+	// alertDebounce is the minimum time between two alerts for the same notification and
+	// stage; the failures in between (e.g. the scheduler retrying every tick) are counted
+	// and reported with the next alert
+	alertDebounce = envDuration("ALERT_DEBOUNCE", 15*time.Minute)
+
+	smtpHost       = envString("SMTP_HOST", "")
+	smtpPort       = envInt("SMTP_PORT", 587)
+	smtpUsername   = envString("SMTP_USERNAME", "")
+	smtpPassword   = envString("SMTP_PASSWORD", "")
+	alertEmailFrom = envString("ALERT_EMAIL_FROM", "")
+	alertEmailTo   = envString("ALERT_EMAIL_TO", "") // comma-separated
+)
+
+const (
+	alertChannelEmail   = "email"
+	alertChannelWebhook = "webhook"
+)
+
+// alertStages are the failure stages (see recordFailure) that send an alert
+var alertStages = map[string]bool{"cast": true, "generation": true}
+
+// alertTimeout bounds sending one alert
+const alertTimeout = 10 * time.Second
+
+var alertClient = &http.Client{Timeout: alertTimeout}
+
+// alertState tracks the debounce of one notification and stage
+type alertState struct {
+	LastSent   time.Time
+	Suppressed int // failures since LastSent that weren't alerted
+}
+
+var (
+	alertStates = make(map[string]*alertState)
+	alertMutex  sync.Mutex
+)
+
+// failureAlert is the JSON body posted to ALERT_WEBHOOK_URL
+type failureAlert struct {
+	Event        string             `json:"event"` // always "notification_failed"
+	Stage        string             `json:"stage"` // "cast" or "generation"
+	Error        string             `json:"error"`
+	Time         time.Time          `json:"time"`
+	Suppressed   int                `json:"suppressed"` // earlier failures not alerted because of ALERT_DEBOUNCE
+	Notification *alertNotification `json:"notification,omitempty"`
+}
+
+// alertNotification is the part of the failed notification included in an alert
+type alertNotification struct {
+	ID        string    `json:"id"`
+	Message   string    `json:"message"`
+	Device    string    `json:"device"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Status    string    `json:"status"`
+}
+
+// initAlerts checks the alert configuration, turning alerts off when the selected
+// channel can't be used
+func initAlerts() {
+	switch alertChannel {
+	case "":
+		return
+	case alertChannelEmail:
+		if smtpHost == "" || alertEmailFrom == "" || alertEmailTo == "" {
+			log.Printf("Warning: ALERT_CHANNEL is email but SMTP_HOST, ALERT_EMAIL_FROM and ALERT_EMAIL_TO are not all set; failure alerts are off")
+			alertChannel = ""
+			return
+		}
+		log.Printf("Failure alerts are emailed to %s through %s", alertEmailTo, smtpHost)
+	case alertChannelWebhook:
+		if err := validateMessageURL(alertWebhookURL); err != nil {
+			log.Printf("Warning: ALERT_CHANNEL is webhook but ALERT_WEBHOOK_URL is not an http or https URL; failure alerts are off")
+			alertChannel = ""
+			return
+		}
+		log.Printf("Failure alerts are posted to the ALERT_WEBHOOK_URL")
+	default:
+		log.Printf("Warning: Unknown ALERT_CHANNEL '%s' (expected email or webhook); failure alerts are off", alertChannel)
+		alertChannel = ""
+	}
+}
+
+// alertFailure sends an alert for a cast or generation failure, unless one was sent
+// for the same notification and stage within ALERT_DEBOUNCE. It sends in the
+// background, so callers aren't held up by the channel.
+func (a *App) alertFailure(notifID, stage string, failure error, at time.Time) {
+	if alertChannel == "" || !alertStages[stage] {
+		return
+	}
+
+	alertMutex.Lock()
+	key := notifID + "/" + stage
+	state, exists := alertStates[key]
+	if exists && at.Sub(state.LastSent) < alertDebounce {
+		state.Suppressed++
+		alertMutex.Unlock()
+		return
+	}
+	suppressed := 0
+	if exists {
+		suppressed = state.Suppressed
+	}
+	alertStates[key] = &alertState{LastSent: at}
+	// Forget notifications that haven't failed for a while
+	for k, s := range alertStates {
+		if at.Sub(s.LastSent) >= alertDebounce && s.Suppressed == 0 {
+			delete(alertStates, k)
+		}
+	}
+	alertMutex.Unlock()
+
+	alert := failureAlert{
+		Event:      "notification_failed",
+		Stage:      stage,
+		Error:      failure.Error(),
+		Time:       at,
+		Suppressed: suppressed,
+	}
+	go func() {
+		if notifID != "" {
+			if notif, err := scanNotification(a.Stmts.GetNotification.QueryRow(notifID)); err == nil {
+				alert.Notification = &alertNotification{
+					ID:        notif.ID,
+					Message:   notif.Message,
+					Device:    notif.Device,
+					StartTime: notif.StartTime,
+					EndTime:   notif.EndTime,
+					Status:    notif.Status,
+				}
+			}
+		}
+		if err := sendAlert(alert); err != nil {
+			log.Printf("Failed to send %s failure alert for notification %s: %v", alertChannel, notifID, err)
+		}
+	}()
+}
+
+// sendAlert delivers an alert through ALERT_CHANNEL
+func sendAlert(alert failureAlert) error {
+	if alertChannel == alertChannelEmail {
+		return sendAlertEmail(alert)
+	}
+	return postAlertWebhook(alert)
+}
+
+// postAlertWebhook posts the alert as JSON to ALERT_WEBHOOK_URL
+func postAlertWebhook(alert failureAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	resp, err := alertClient.Post(alertWebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("ALERT_WEBHOOK_URL returned %s", resp.Status)
+	}
+	return nil
+}
+
+// sendAlertEmail emails the alert to ALERT_EMAIL_TO. The SMTP connection is upgraded
+// with STARTTLS when the server offers it; SMTP_USERNAME enables PLAIN authentication.
+func sendAlertEmail(alert failureAlert) error {
+	var recipients []string
+	for _, to := range strings.Split(alertEmailTo, ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			recipients = append(recipients, to)
+		}
+	}
+
+	subject := fmt.Sprintf("Notification %s failed", alert.Stage)
+	var body strings.Builder
+	fmt.Fprintf(&body, "A notification's %s failed at %s.\r\n\r\n", alert.Stage, alert.Time.Format(time.RFC3339))
+	if n := alert.Notification; n != nil {
+		subject = fmt.Sprintf("Notification %s failed on %s", alert.Stage, n.Device)
+		fmt.Fprintf(&body, "Notification: %s\r\nMessage: %s\r\nDevice: %s\r\nStart: %s\r\nEnd: %s\r\nStatus: %s\r\n\r\n",
+			n.ID, n.Message, n.Device, n.StartTime.Format(time.RFC3339), n.EndTime.Format(time.RFC3339), n.Status)
+	}
+	fmt.Fprintf(&body, "Error: %s\r\n", alert.Error)
+	if alert.Suppressed > 0 {
+		fmt.Fprintf(&body, "\r\n%d earlier failures were not alerted (ALERT_DEBOUNCE %s).\r\n", alert.Suppressed, alertDebounce)
+	}
+
+	// Header values can't contain line breaks (the device name comes from the request)
+	header := strings.NewReplacer("\r", " ", "\n", " ")
+	msg := "From: " + header.Replace(alertEmailFrom) + "\r\n" +
+		"To: " + header.Replace(strings.Join(recipients, ", ")) + "\r\n" +
+		"Subject: " + header.Replace(subject) + "\r\n" +
+		"Date: " + alert.Time.Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n" +
+		strings.ReplaceAll(strings.ReplaceAll(body.String(), "\r\n", "\n"), "\n", "\r\n")
+
+	var auth smtp.Auth
+	if smtpUsername != "" {
+		auth = smtp.PlainAuth("", smtpUsername, smtpPassword, smtpHost)
+	}
+	addr := net.JoinHostPort(smtpHost, fmt.Sprint(smtpPort))
+	return smtp.SendMail(addr, auth, alertEmailFrom, recipients, []byte(msg))
+}
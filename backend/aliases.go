@@ -0,0 +1,173 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DeviceAlias maps a discovered device (by its URL/UUID) to a user-chosen display name,
+// an optional accent color for the notifications cast to it and an optional volume
+type DeviceAlias struct {
+	DeviceID string   `json:"device_id"`
+	Alias    string   `json:"alias"`
+	Color    string   `json:"color,omitempty"`  // hex accent color, e.g. "#e53e3e" (empty = none)
+	Volume   *float64 `json:"volume,omitempty"` // cast volume, 0.0-1.0 (nil = leave the device's volume)
+}
+
+const deviceAliasColumns = "device_id, alias, color, volume"
+
+func scanDeviceAlias(row rowScanner) (DeviceAlias, error) {
+	var alias DeviceAlias
+	var volume sql.NullFloat64
+	if err := row.Scan(&alias.DeviceID, &alias.Alias, &alias.Color, &volume); err != nil {
+		return alias, err
+	}
+	if volume.Valid {
+		alias.Volume = &volume.Float64
+	}
+	return alias, nil
+}
+
+// loadDeviceAliases returns device ID -> alias
+func loadDeviceAliases(db *sql.DB) map[string]DeviceAlias {
+	aliases := make(map[string]DeviceAlias)
+
+	rows, err := db.Query("SELECT " + deviceAliasColumns + " FROM device_aliases")
+	if err != nil {
+		log.Printf("Error loading device aliases: %v", err)
+		return aliases
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		alias, err := scanDeviceAlias(rows)
+		if err != nil {
+			continue
+		}
+		aliases[alias.DeviceID] = alias
+	}
+	return aliases
+}
+
+// applyDeviceAliases replaces device names with their aliases, keeping the real name
+func applyDeviceAliases(devices []ChromecastDevice, aliases map[string]DeviceAlias) []ChromecastDevice {
+	for i := range devices {
+		if alias, ok := aliases[devices[i].UUID]; ok {
+			devices[i].RealName = devices[i].Name
+			devices[i].Name = alias.Alias
+			devices[i].Color = alias.Color
+			devices[i].Volume = alias.Volume
+		}
+	}
+	return devices
+}
+
+// deviceSettings returns the alias row of a device, which notifications may name by
+// alias, device ID or discovered name (false when it has none)
+func (a *App) deviceSettings(device string) (DeviceAlias, bool) {
+	deviceID := a.resolveDeviceAlias(device)
+	for _, known := range getCachedDevices() {
+		if known.Name == device {
+			deviceID = known.UUID
+			break
+		}
+	}
+
+	alias, err := scanDeviceAlias(a.DB.QueryRow("SELECT "+deviceAliasColumns+" FROM device_aliases WHERE device_id = ?", deviceID))
+	return alias, err == nil
+}
+
+// deviceColor returns the accent color stored for a device ("" when it has none)
+func (a *App) deviceColor(device string) string {
+	alias, _ := a.deviceSettings(device)
+	return alias.Color
+}
+
+// deviceVolume returns the cast volume stored for a device (nil when it has none)
+func (a *App) deviceVolume(device string) *float64 {
+	alias, _ := a.deviceSettings(device)
+	return alias.Volume
+}
+
+// accentColor is the accent drawn on a notification's image: its device's color,
+// unless the notification brings its own background
+func (a *App) accentColor(notif Notification) string {
+	if notif.Background != nil {
+		return ""
+	}
+	return a.deviceColor(notif.Device)
+}
+
+// resolveDeviceAlias maps an alias back to the device ID used for casting;
+// names without an alias are returned unchanged
+func (a *App) resolveDeviceAlias(name string) string {
+	var deviceID string
+	err := a.DB.QueryRow("SELECT device_id FROM device_aliases WHERE alias = ?", name).Scan(&deviceID)
+	if err != nil {
+		return name
+	}
+	return deviceID
+}
+
+func getDeviceAliases(c *fiber.Ctx) error {
+	aliases := []DeviceAlias{}
+	for _, alias := range loadDeviceAliases(appInstance.DB) {
+		aliases = append(aliases, alias)
+	}
+	return c.JSON(aliases)
+}
+
+// setDeviceAlias creates or replaces the alias (and accent color and volume) of a device
+func setDeviceAlias(c *fiber.Ctx) error {
+	var alias DeviceAlias
+	if err := c.BodyParser(&alias); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	alias.DeviceID = strings.TrimSpace(alias.DeviceID)
+	alias.Alias = strings.TrimSpace(alias.Alias)
+	if alias.DeviceID == "" || alias.Alias == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "device_id and alias are required"})
+	}
+	alias.Color = strings.TrimSpace(alias.Color)
+	if alias.Color != "" {
+		if _, err := parseHexColor(alias.Color); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if alias.Volume != nil && (*alias.Volume < 0 || *alias.Volume > 1) {
+		return c.Status(400).JSON(fiber.Map{"error": "volume must be between 0.0 and 1.0"})
+	}
+
+	_, err := appInstance.DB.Exec(`
+		INSERT INTO device_aliases (device_id, alias, color, volume) VALUES (?, ?, ?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET alias = excluded.alias, color = excluded.color, volume = excluded.volume
+	`, alias.DeviceID, alias.Alias, alias.Color, alias.Volume)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return c.Status(409).JSON(fiber.Map{"error": "Alias is already used by another device"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save alias"})
+	}
+	recordAudit(auditActor(c), auditUpdate, "device_alias", alias.DeviceID, alias)
+
+	return c.JSON(alias)
+}
+
+func deleteDeviceAlias(c *fiber.Ctx) error {
+	deviceID := c.Query("device_id")
+	if deviceID == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "device_id is required"})
+	}
+
+	result, err := appInstance.DB.Exec("DELETE FROM device_aliases WHERE device_id = ?", deviceID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete alias"})
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		recordAudit(auditActor(c), auditDelete, "device_alias", deviceID, nil)
+	}
+	return c.JSON(fiber.Map{"message": "Alias deleted"})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Audit actions recorded in audit_log
+const (
+	auditCreate    = "create"
+	auditUpdate    = "update"
+	auditDelete    = "delete"
+	auditRestore   = "restore"
+	auditCastStart = "cast_start"
+	auditCastStop  = "cast_stop"
+	auditCancel    = "cancel"
+)
+
+// Actors of changes that don't come from an API client: the service itself (casts
+// started and stopped by the scheduler) and the inbound webhook
+const (
+	auditActorSystem  = "system"
+	auditActorWebhook = "webhook"
+)
+
+// AuditEntry is one recorded mutation
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	Time       time.Time       `json:"time"`
+	Action     string          `json:"action"`      // create, update, delete, restore, cast_start, cast_stop or cancel
+	Actor      string          `json:"actor"`       // client address, "webhook" or "system"
+	TargetType string          `json:"target_type"` // notification, template, device_alias, image, clip, status, casts or generations
+	TargetID   string          `json:"target_id"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+// auditRetention is how long audit entries are kept before the janitor purges them
+// (AUDIT_RETENTION, 0 = kept forever)
+var auditRetention = envDuration("AUDIT_RETENTION", 0)
+
+// auditQueueSize bounds the entries waiting to be written. Recording never blocks the
+// request: when the writer falls this far behind, entries are dropped with a warning.
+const auditQueueSize = 256
+
+var auditQueue = make(chan AuditEntry, auditQueueSize)
+
+// recordAudit queues an entry for the audit log. details is stored as JSON (nil = none).
+func recordAudit(actor, action, targetType, targetID string, details any) {
+	entry := AuditEntry{
+		Time:       time.Now().UTC(),
+		Action:     action,
+		Actor:      actor,
+		TargetType: targetType,
+		TargetID:   targetID,
+	}
+	if details != nil {
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			log.Printf("Warning: Could not encode audit details for %s %s: %v", action, targetID, err)
+		}
+		entry.Details = encoded
+	}
+
+	select {
+	case auditQueue <- entry:
+	default:
+		log.Printf("Warning: Audit log queue is full, dropping %s %s %s", action, targetType, targetID)
+	}
+}
+
+// auditActor identifies who made an API request. There are no user accounts, so this
+// is the client address (X-Forwarded-For aware when behind the frontend proxy).
+func auditActor(c *fiber.Ctx) string {
+	if forwarded := c.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	return c.IP()
+}
+
+// notificationAuditDetails summarizes a created notification for its audit entry
+func notificationAuditDetails(notif Notification) fiber.Map {
+	return fiber.Map{
+		"device":     notif.Device,
+		"start_time": notif.StartTime,
+		"end_time":   notif.EndTime,
+		"message":    notif.Message,
+	}
+}
+
+// startAuditWriter writes queued audit entries to the database
+func (a *App) startAuditWriter() {
+	for entry := range auditQueue {
+		details := ""
+		if entry.Details != nil {
+			details = string(entry.Details)
+		}
+		_, err := a.DB.Exec(`
+			INSERT INTO audit_log (created_at, action, actor, target_type, target_id, details)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, entry.Time.Format("2006-01-02 15:04:05"), entry.Action, entry.Actor, entry.TargetType, entry.TargetID, details)
+		if err != nil {
+			log.Printf("Failed to write audit entry %s %s %s: %v", entry.Action, entry.TargetType, entry.TargetID, err)
+		}
+	}
+}
+
+// purgeAuditLog removes audit entries older than AUDIT_RETENTION
+func (a *App) purgeAuditLog() {
+	if auditRetention <= 0 {
+		return
+	}
+	cutoff := time.Now().UTC().Add(-auditRetention)
+	result, err := a.DB.Exec("DELETE FROM audit_log WHERE created_at <= ?", cutoff.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Printf("[JANITOR] Error purging the audit log: %v", err)
+		return
+	}
+	if purged, _ := result.RowsAffected(); purged > 0 {
+		log.Printf("[JANITOR] Purged %d audit entries older than %s", purged, auditRetention)
+	}
+}
+
+// maxAuditPageSize caps how many entries one GET /api/audit returns
+const maxAuditPageSize = 500
+
+// getAuditLog lists audit entries, newest first. Optional filters: action, actor,
+// target_type, target_id and since (a time); limit defaults to 100 and before (an
+// entry ID) pages further back.
+func getAuditLog(c *fiber.Ctx) error {
+	var conditions []string
+	var args []interface{}
+
+	for _, filter := range []string{"action", "actor", "target_type", "target_id"} {
+		if value := c.Query(filter); value != "" {
+			conditions = append(conditions, filter+" = ?")
+			args = append(args, value)
+		}
+	}
+	if value := c.Query("since"); value != "" {
+		since, err := parseTimeInUTC(value)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Invalid since format: %v", err)})
+		}
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, since.Format("2006-01-02 15:04:05"))
+	}
+	if before := c.QueryInt("before"); before > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, before)
+	}
+
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > maxAuditPageSize {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("limit must be between 1 and %d", maxAuditPageSize)})
+	}
+
+	query := "SELECT id, created_at, action, actor, target_type, target_id, details FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := appInstance.ReadDB.Query(query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Database error: %v", err)})
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var createdAt, details string
+		if err := rows.Scan(&entry.ID, &createdAt, &entry.Action, &entry.Actor, &entry.TargetType, &entry.TargetID, &details); err != nil {
+			log.Printf("Error scanning audit entry: %v", err)
+			continue
+		}
+		if entry.Time, err = parseTimeInUTC(createdAt); err != nil {
+			log.Printf("Error parsing audit entry time '%s': %v", createdAt, err)
+		}
+		if details != "" {
+			entry.Details = json.RawMessage(details)
+		}
+		entries = append(entries, entry)
+	}
+	return c.JSON(entries)
+}
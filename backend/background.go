@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+// GradientStop is one color of a background gradient
+type GradientStop struct {
+	Offset float64 `json:"offset" xml:"offset"` // position along the gradient, 0-1
+	Color  string  `json:"color" xml:"color"`   // hex color, e.g. "#667eea"
+}
+
+// Background describes the gradient drawn behind the notification text.
+// A nil Background keeps the default diagonal purple gradient.
+type Background struct {
+	Type  string         `json:"type" xml:"type"`   // "linear" (default) or "radial"
+	Angle float64        `json:"angle" xml:"angle"` // linear direction in degrees: 0 = left to right, 90 = top to bottom
+	Stops []GradientStop `json:"stops" xml:"stops>stop"`
+}
+
+// validate checks the gradient type, stop positions and colors
+func (b *Background) validate() error {
+	switch b.Type {
+	case "", "linear", "radial":
+	default:
+		return fmt.Errorf("invalid background type '%s' (expected linear or radial)", b.Type)
+	}
+	if len(b.Stops) < 2 {
+		return fmt.Errorf("background needs at least two color stops")
+	}
+	for _, stop := range b.Stops {
+		if stop.Offset < 0 || stop.Offset > 1 {
+			return fmt.Errorf("color stop offset %g must be between 0 and 1", stop.Offset)
+		}
+		if _, err := parseHexColor(stop.Color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drawBackground fills the whole context with the background gradient
+func drawBackground(dc *gg.Context, bg *Background, width, height int) {
+	w, h := float64(width), float64(height)
+
+	var gradient gg.Gradient
+	switch {
+	case bg == nil:
+		gradient = gg.NewLinearGradient(0, 0, w, h)
+		gradient.AddColorStop(0, color.RGBA{102, 126, 234, 255}) // #667eea
+		gradient.AddColorStop(1, color.RGBA{118, 75, 162, 255})  // #764ba2
+	case bg.Type == "radial":
+		// From the center out to the corners
+		cx, cy := w/2, h/2
+		gradient = gg.NewRadialGradient(cx, cy, 0, cx, cy, math.Hypot(w, h)/2)
+	default:
+		// Line through the center at the given angle, long enough to span the image
+		rad := bg.Angle * math.Pi / 180
+		dx, dy := math.Cos(rad), math.Sin(rad)
+		half := (math.Abs(w*dx) + math.Abs(h*dy)) / 2
+		cx, cy := w/2, h/2
+		gradient = gg.NewLinearGradient(cx-dx*half, cy-dy*half, cx+dx*half, cy+dy*half)
+	}
+
+	if bg != nil {
+		for _, stop := range bg.Stops {
+			c, _ := parseHexColor(stop.Color) // validated on create
+			gradient.AddColorStop(stop.Offset, c)
+		}
+	}
+
+	dc.SetFillStyle(gradient)
+	dc.DrawRectangle(0, 0, w, h)
+	dc.Fill()
+}
+
+// parseHexColor parses "#rgb" or "#rrggbb"
+func parseHexColor(value string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color '%s' (expected #rrggbb)", value)
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color '%s' (expected #rrggbb)", value)
+	}
+	return color.RGBA{uint8(n >> 16), uint8(n >> 8), uint8(n), 255}, nil
+}
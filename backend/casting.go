@@ -2,10 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/milkam/gochromecast/pkg/chromecast"
 	"github.com/milkam/gochromecast/pkg/mdns"
@@ -15,47 +23,117 @@ import (
 
 // CastSession represents an active casting session
 type CastSession struct {
-	NotificationID string
-	Device         string
-	CastClient     *chromecast.Client
-	Context        context.Context
-	Cancel         context.CancelFunc
-	Active         bool
-	Mutex          sync.RWMutex
+	NotificationID  string
+	Device          string
+	DeviceURI       string      // Chromecast URI used for follow-up media (e.g. the "meeting ended" clip)
+	Target          mdns.Device // the receiver, reconnected to after the stop (see releaseReceiver)
+	MediaURL        string      // media currently playing, re-sent by the keep-alive
+	AudioOnly       bool        // cast to a speaker: only the TTS audio plays
+	ImageOnly       bool        // the static PNG is cast instead of the HLS video (see castsImage)
+	CastClient      *chromecast.Client
+	Context         context.Context
+	Cancel          context.CancelFunc
+	Active          bool
+	StartedAt       time.Time       // when the current media started playing (pinned statuses replay it)
+	SequenceIndex   int             // position of Device in the notification's device_sequence
+	DeviceStartedAt time.Time       // when the cast moved to Device (the sequence dwell counts from here)
+	RestoreVolume   *float64        // the receiver's volume before the device's preferred volume was applied
+	Resolution      *CastResolution // set when the video is larger than the device plays (see resolution.go)
+	Ending          bool            // showing the "meeting ended" screen, torn down once it is over
+	Mutex           sync.RWMutex
 }
 
+// castKeepAliveInterval re-sends the playing media this often so the receiver doesn't
+// idle out during long meetings (0 = off). The media restarts from the beginning.
+var castKeepAliveInterval = envDuration("CAST_KEEPALIVE_INTERVAL", 0)
+
+const (
+	// maxCastPlayRetries caps CAST_PLAY_RETRIES
+	maxCastPlayRetries = 5
+	// castPlayRetryBudget caps the total wait between the retries of one cast attempt
+	castPlayRetryBudget = 30 * time.Second
+)
+
+var (
+	// castPlayRetries is how many more times startCast sends the media when the device
+	// rejects it (e.g. momentarily busy launching the receiver), before the cast attempt
+	// fails and is left to the scheduler's next tick
+	castPlayRetries = min(max(envInt("CAST_PLAY_RETRIES", 2), 0), maxCastPlayRetries)
+	// castPlayRetryDelay is the wait before the first retry, doubled for each further one
+	castPlayRetryDelay = min(envDuration("CAST_PLAY_RETRY_DELAY", 1*time.Second), castPlayRetryBudget)
+)
+
+// castServerPort is where the gochromecast HLS server listens for the Chromecast
+const castServerPort = ":8889"
+
 var (
-	discoveredDevices []ChromecastDevice
+	discoveredDevices []ChromecastDevice // every device seen so far; Online reflects the latest discovery
 	deviceMutex       sync.RWMutex
 )
 
+// discoveryOptions controls a single mDNS discovery run
+type discoveryOptions struct {
+	Wait time.Duration // how long to listen for announcements
+	IPv6 bool
+}
+
+// defaultDiscovery is used by the background discovery loop
+var defaultDiscovery = discoveryOptions{Wait: 5 * time.Second}
+
+// maxDiscoveryWait bounds caller-supplied discovery timeouts
+const maxDiscoveryWait = 30 * time.Second
+
+// defaultDevice is cast to when a notification is created without a device
+// (DEFAULT_DEVICE: a device name, alias, ID or IP; empty = device required)
+var defaultDevice = strings.TrimSpace(envString("DEFAULT_DEVICE", ""))
+
+// maxDeviceNameLength bounds a notification's device (name, alias, ID or IP), in characters
+const maxDeviceNameLength = 128
+
+// validateDeviceName rejects device values that could never be cast to: empty,
+// overly long, or containing control characters
+func validateDeviceName(device string) error {
+	if strings.TrimSpace(device) == "" {
+		return errors.New("device is required")
+	}
+	if utf8.RuneCountInString(device) > maxDeviceNameLength {
+		return fmt.Errorf("device cannot exceed %d characters", maxDeviceNameLength)
+	}
+	for _, r := range device {
+		if unicode.IsControl(r) {
+			return errors.New("device cannot contain control characters")
+		}
+	}
+	return nil
+}
+
 func (a *App) startDeviceDiscovery() {
 	ticker := time.NewTicker(2 * time.Minute)
 	defer ticker.Stop()
 
 	// Initial discovery
-	a.discoverDevices()
+	a.checkDefaultDevice(a.discoverDevices(defaultDiscovery))
 
 	for range ticker.C {
-		a.discoverDevices()
+		a.discoverDevices(defaultDiscovery)
 	}
 }
 
-func (a *App) discoverDevices() []ChromecastDevice {
+func (a *App) discoverDevices(opts discoveryOptions) []ChromecastDevice {
 	//log.Println("Discovering Chromecast devices...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Wait+5*time.Second)
 	defer cancel()
 
 	// Use gochromecast mDNS library for discovery
 	mdnsClient := mdns.New(ctx, &mdns.Config{
-		IPv6: false,
+		IPv6: opts.IPv6,
 	})
 	
 	mdnsClient.Start()
 
 	// Wait for devices to be discovered
-	time.Sleep(5 * time.Second)
+	time.Sleep(opts.Wait)
 
 	devicesChan := mdnsClient.GetDevices()
 	devices := <-devicesChan
@@ -64,6 +142,7 @@ func (a *App) discoverDevices() []ChromecastDevice {
 
 	var foundDevices []ChromecastDevice
 	seen := make(map[string]bool)
+	now := time.Now().UTC()
 
 	for _, device := range devices {
 		// Extract device name (use first name from Names array)
@@ -83,23 +162,84 @@ func (a *App) discoverDevices() []ChromecastDevice {
 		}
 		seen[device.Url] = true
 
+		deviceType := classifyDevice(device)
 		foundDevices = append(foundDevices, ChromecastDevice{
-			Name:    deviceName,
-			UUID:    device.Url,  // Store URL as UUID so we can find device later
-			Address: device.Url,
+			Name:         deviceName,
+			UUID:         device.Url, // Store URL as UUID so we can find device later
+			Address:      device.Url,
+			LastSeen:     now,
+			Online:       true,
+			Type:         deviceType,
+			Capabilities: deviceCapabilities(deviceType),
 		})
 		//log.Printf("Found device: %s (%s) - Names: %v", deviceName, device.Url, device.Names)
 	}
 
 	deviceMutex.Lock()
+	defer deviceMutex.Unlock()
+
+	// Keep previously seen devices, marked offline, so the UI can explain failed casts
+	for _, known := range discoveredDevices {
+		if seen[known.Address] {
+			continue
+		}
+		known.Online = false
+		foundDevices = append(foundDevices, known)
+	}
 	discoveredDevices = foundDevices
-	deviceMutex.Unlock()
 
-	if len(foundDevices) == 0 {
-		return getCachedDevices()
+	return append([]ChromecastDevice(nil), foundDevices...)
+}
+
+// checkDefaultDevice warns at startup if DEFAULT_DEVICE doesn't match any discovered
+// device (it may just be off; notifications still fall back to it)
+func (a *App) checkDefaultDevice(devices []ChromecastDevice) {
+	if defaultDevice == "" {
+		return
+	}
+
+	if address, ok, err := parseDeviceAddress(defaultDevice); ok {
+		if err == nil {
+			err = checkDeviceReachable(address)
+		}
+		if err != nil {
+			log.Printf("Warning: DEFAULT_DEVICE %s: %v", defaultDevice, err)
+		}
+		return
+	}
+
+	if device, ok := a.findDevice(devices, defaultDevice); ok {
+		log.Printf("Default device: %s (%s)", device.Name, device.Address)
+		return
 	}
+	log.Printf("Warning: DEFAULT_DEVICE '%s' was not found among %d discovered devices", defaultDevice, len(devices))
+}
 
-	return foundDevices
+// findDevice looks a device (name, alias or IP) up among discovered devices
+func (a *App) findDevice(devices []ChromecastDevice, name string) (ChromecastDevice, bool) {
+	target := a.resolveDeviceAlias(name)
+	for _, device := range devices {
+		if device.Address == target || device.Name == target {
+			return device, true
+		}
+	}
+	return ChromecastDevice{}, false
+}
+
+// checkDeviceExists verifies a device can be cast to right now: a direct IP must be
+// reachable, anything else must be among the discovered devices
+func (a *App) checkDeviceExists(name string) error {
+	if address, ok, err := parseDeviceAddress(name); ok {
+		if err != nil {
+			return err
+		}
+		return checkDeviceReachable(address)
+	}
+	devices := getCachedDevices()
+	if _, ok := a.findDevice(devices, name); !ok {
+		return fmt.Errorf("device '%s' was not found among the %d discovered devices", name, len(devices))
+	}
+	return nil
 }
 
 func getCachedDevices() []ChromecastDevice {
@@ -108,29 +248,60 @@ func getCachedDevices() []ChromecastDevice {
 	return discoveredDevices
 }
 
-func (a *App) startCast(notifID, deviceName, message string) error {
-	a.CastMutex.Lock()
-	defer a.CastMutex.Unlock()
+// errCastLimitReached means MAX_ACTIVE_CASTS casts are already running
+var errCastLimitReached = errors.New("maximum number of active casts reached")
 
-	// Check if already casting
-	if _, exists := a.ActiveCasts[notifID]; exists {
-		return fmt.Errorf("cast already active for this notification")
-	}
+// wrongMediaTypeError means the device was found but can't play the notification's
+// media. Retrying won't help, so the notification is marked failed with this reason.
+type wrongMediaTypeError struct {
+	Device     string
+	DeviceType string
+	Media      string
+}
 
-	// Use hardcoded values instead of flags (flags can't be redefined)
-	waitTime := 5     // 5 seconds for mDNS search
-	ipv6 := false     // use IPv4
-	targetDeviceName := deviceName
-	
-	deviceToUse, err := getDevice(&ipv6, &waitTime, &targetDeviceName)
-	if err != nil {
-		return fmt.Errorf("failed to find device: %w", err)
+func (e *wrongMediaTypeError) Error() string {
+	return fmt.Sprintf("device %s is a %s and cannot play %s", e.Device, e.DeviceType, e.Media)
+}
+
+// markCastFailed marks a notification failed when its cast can never succeed, keeping the reason
+func (a *App) markCastFailed(notifID string, err error) {
+	var mediaErr *wrongMediaTypeError
+	if !errors.As(err, &mediaErr) {
+		return
+	}
+	if _, dbErr := a.DB.Exec("UPDATE notifications SET status = 'failed', failure_reason = ? WHERE id = ?", err.Error(), notifID); dbErr != nil {
+		log.Printf("Failed to mark notification %s failed: %v", notifID, dbErr)
 	}
+}
+
+// castTarget is what startCast found out before taking CastMutex: the device and the
+// media to cast to it
+type castTarget struct {
+	Device     mdns.Device
+	AudioOnly  bool
+	ImageOnly  bool
+	MediaID    string          // media directory cast (the notification's, a device variant or a downscaled copy)
+	Resolution *CastResolution // set when the video is larger than the device plays
+}
+
+// castConnection is the connection to a receiver that castTo opens before taking CastMutex
+type castConnection struct {
+	LocalIP       string // the address the receiver fetches the media from
+	DeviceURI     string
+	Target        mdns.Device
+	Context       context.Context
+	Cancel        context.CancelFunc
+	Client        *chromecast.Client
+	RestoreVolume *float64 // the receiver's volume before the device's preferred volume was applied
+}
 
+// connectCast connects to the target's receiver, makes sure the cast server is up and
+// sets the device's preferred volume
+func (a *App) connectCast(target castTarget, deviceName string) (*castConnection, error) {
 	// Get local IP address (needed for server.Start URL)
 	localIP, err := ip.GetLANIp()
 	if err != nil {
-		return fmt.Errorf("failed to get local IP: %w", err)
+		return nil, fmt.Errorf("failed to get local IP: %w", err)
 	}
 	log.Printf("Resolved local IP to %s", localIP)
 
@@ -138,68 +309,572 @@ func (a *App) startCast(notifID, deviceName, message string) error {
 
 	// Create Chromecast client using gochromecast library
 	client := chromecast.New(castCtx, &chromecast.Config{
-		Device: deviceToUse,
+		Device: target.Device,
 	})
 
 	// Start the HLS server (from gochromecast/pkg/server)
 	// This serves files from ./data/chunks/ on port 8889
-	const serverPort = ":8889"
-	go server.Start(serverPort)
+	go server.Start(castServerPort)
 
 	// Wait for server to start
 	time.Sleep(1 * time.Second)
 
-	// Create URL using the local IP and server port
-	// This matches the working example: http://IP:PORT/files/notificationID/playlist.m3u8
-	notificationURL := fmt.Sprintf("http://%s%s/files/%s/playlist.m3u8", localIP, serverPort, notifID)
-	log.Printf("Casting URL: %s to device: %s", notificationURL, deviceToUse.Url)
+	return &castConnection{
+		LocalIP:   localIP,
+		DeviceURI: target.Device.Url,
+		Target:    target.Device,
+		Context:   castCtx,
+		Cancel:    castCancel,
+		Client:    client,
+		// The device's preferred volume is set before anything plays
+		RestoreVolume: a.applyDeviceVolume(client, target.Device.Url, deviceName),
+	}, nil
+}
 
-	// Play media using the chromecast library
-	err = client.PlayMedia(castCtx, chromecast.PlayMediaRequest{
-		ChromeCastDeviceURI: deviceToUse.Url,
-		MediaURL:            notificationURL,
-	})
-	if err != nil {
-		castCancel()
-		return fmt.Errorf("failed to cast media: %w", err)
+// close drops a connection no cast was started over, putting the volume back. It waits
+// on the device, so it runs in its own goroutine when CastMutex is held.
+func (c *castConnection) close(notifID string) {
+	if c == nil {
+		return
 	}
+	restoreCastVolume(c.Client, &CastSession{NotificationID: notifID, DeviceURI: c.DeviceURI, RestoreVolume: c.RestoreVolume})
+	c.Cancel()
+}
 
-	log.Printf("Successfully casting notification %s to device %s", notifID, deviceName)
-
+// abandon drops a connection whose media is already playing when the cast can't be
+// registered after all, stopping the receiver the way stopCast does. When another cast
+// now plays on the same device, the receiver and its volume are left to that cast.
+func (c *castConnection) abandon(notifID string, deviceShared bool) {
+	if c == nil {
+		return
+	}
+	c.Cancel()
+	if deviceShared {
+		return
+	}
 	session := &CastSession{
 		NotificationID: notifID,
-		Device:         deviceName,
-		CastClient:     client,
-		Context:        castCtx,
-		Cancel:         castCancel,
-		Active:         true,
+		DeviceURI:      c.DeviceURI,
+		Target:         c.Target,
+		CastClient:     c.Client,
+		RestoreVolume:  c.RestoreVolume,
+	}
+	if err := releaseReceiver(session); err != nil {
+		log.Printf("Warning: Dropped cast of notification %s may still be playing: %v", notifID, err)
+	}
+}
+
+// errStoppedDuringMove means a cast was stopped while it was being moved to another
+// device, so the new device was not cast to
+var errStoppedDuringMove = errors.New("cast was stopped while moving to another device")
+
+func (a *App) startCast(notifID, deviceName, message string) error {
+	return a.castTo(notifID, deviceName, nil)
+}
+
+// castTo casts a notification to a device. With replacing, the notification's running
+// session, the new cast takes that session's place in ActiveCasts (see moveCast); the
+// session stays registered until then, so a stop meanwhile still finds it, and the
+// device isn't cast to if it was stopped.
+func (a *App) castTo(notifID, deviceName string, replacing *CastSession) error {
+	// The device lookup and a downscale (an FFmpeg re-encode) run before CastMutex is
+	// taken, so they don't hold up other casts, stops and listings. The checks are
+	// repeated under the lock in case another cast started in the meantime.
+	var target castTarget
+	if !castTestMode {
+		if replacing == nil {
+			if err := a.checkCastAllowed(notifID); err != nil {
+				return err
+			}
+		}
+		var err error
+		if target, err = a.prepareCast(notifID, deviceName); err != nil {
+			return err
+		}
+	}
+
+	// Connecting to the receiver, setting its volume and sending the media (with its
+	// retries) wait on the device, so they happen before the lock too
+	var conn *castConnection
+	var notificationURL string
+	if !castTestMode {
+		var err error
+		if conn, err = a.connectCast(target, deviceName); err != nil {
+			return err
+		}
+
+		// Speakers get the TTS audio; the cast server's file extension gives it an audio type
+		notificationURL = castMediaURL(conn.LocalIP, target.MediaID, target.AudioOnly, target.ImageOnly)
+		log.Printf("Casting URL: %s to device: %s", notificationURL, conn.DeviceURI)
+
+		// Play media using the chromecast library
+		if err := playMediaWithRetry(conn.Context, conn.Client, conn.DeviceURI, notificationURL, notifID); err != nil {
+			conn.close(notifID)
+			return fmt.Errorf("failed to cast media: %w", err)
+		}
+		log.Printf("Successfully casting notification %s to device %s", notifID, deviceName)
+	}
+
+	a.CastMutex.Lock()
+	defer a.CastMutex.Unlock()
+
+	if replacing != nil {
+		if a.ActiveCasts[notifID] != replacing {
+			go conn.abandon(notifID, a.deviceCastingLocked(conn))
+			return errStoppedDuringMove
+		}
+	} else if err := a.castAllowedLocked(notifID); err != nil {
+		go conn.abandon(notifID, a.deviceCastingLocked(conn))
+		return err
+	}
+	if castTestMode {
+		return a.startTestCast(notifID, deviceName)
+	}
+	deviceToUse, audioOnly, imageOnly := target.Device, target.AudioOnly, target.ImageOnly
+	castCtx, castCancel, client, restoreVolume := conn.Context, conn.Cancel, conn.Client, conn.RestoreVolume
+
+	session := &CastSession{
+		NotificationID:  notifID,
+		Device:          deviceName,
+		DeviceURI:       deviceToUse.Url,
+		Target:          deviceToUse,
+		MediaURL:        notificationURL,
+		AudioOnly:       audioOnly,
+		ImageOnly:       imageOnly,
+		CastClient:      client,
+		Context:         castCtx,
+		Cancel:          castCancel,
+		Active:          true,
+		StartedAt:       time.Now(),
+		DeviceStartedAt: time.Now(),
+		RestoreVolume:   restoreVolume,
+		Resolution:      target.Resolution,
 	}
 
 	a.ActiveCasts[notifID] = session
 
+	// Re-sending audio would repeat the speech, so speakers get no keep-alive;
+	// a static image doesn't run out, so it needs none either
+	if castKeepAliveInterval > 0 && !audioOnly && !imageOnly {
+		go a.keepCastAlive(session)
+	}
+	if castLivenessInterval > 0 {
+		go a.watchCastLiveness(session)
+	}
+
 	// Update database status
-	_, err = a.DB.Exec("UPDATE notifications SET status = 'active' WHERE id = ?", notifID)
+	_, err := a.Stmts.SetStatus.Exec("active", notifID)
 	if err != nil {
 		log.Printf("Failed to update notification status: %v", err)
 	}
 
 	log.Printf("Started casting notification %s to device %s", notifID, deviceName)
+	recordAudit(auditActorSystem, auditCastStart, "notification", notifID, map[string]any{"device": deviceName})
 	return nil
 }
 
-func (a *App) stopCast(notifID string) error {
-	log.Printf("Stopping cast for notification %s", notifID)
+// isCasting reports whether a notification has an active cast
+func (a *App) isCasting(notifID string) bool {
+	a.CastMutex.RLock()
+	defer a.CastMutex.RUnlock()
+	_, exists := a.ActiveCasts[notifID]
+	return exists
+}
+
+// checkCastAllowed reports whether a cast of the notification could start now
+func (a *App) checkCastAllowed(notifID string) error {
+	a.CastMutex.RLock()
+	defer a.CastMutex.RUnlock()
+	return a.castAllowedLocked(notifID)
+}
+
+// deviceCastingLocked reports whether a registered cast plays on the connection's
+// device; the caller holds CastMutex
+func (a *App) deviceCastingLocked(conn *castConnection) bool {
+	if conn == nil {
+		return false
+	}
+	for _, session := range a.ActiveCasts {
+		if session.DeviceURI == conn.DeviceURI {
+			return true
+		}
+	}
+	return false
+}
+
+// castAllowedLocked is checkCastAllowed for callers holding CastMutex
+func (a *App) castAllowedLocked(notifID string) error {
+	if _, exists := a.ActiveCasts[notifID]; exists {
+		return fmt.Errorf("cast already active for this notification")
+	}
+	if maxActiveCasts > 0 && len(a.ActiveCasts) >= maxActiveCasts {
+		return errCastLimitReached
+	}
+	return nil
+}
+
+// prepareCast looks the device up and picks the media to cast to it: the audio for a
+// speaker, the image or the video (a device's variant, or a copy scaled to fit it)
+func (a *App) prepareCast(notifID, deviceName string) (castTarget, error) {
+	// Use hardcoded values instead of flags (flags can't be redefined)
+	waitTime := 5                                        // 5 seconds for mDNS search
+	ipv6 := false                                        // use IPv4
+	targetDeviceName := a.resolveDeviceAlias(deviceName) // aliases cast to the aliased device's ID
+
+	var target castTarget
+	if address, ok, err := parseDeviceAddress(targetDeviceName); ok {
+		// Direct IP: skip mDNS (for networks where multicast doesn't get through)
+		if err != nil {
+			return target, err
+		}
+		if err := checkDeviceReachable(address); err != nil {
+			return target, err
+		}
+		target.Device = mdns.Device{Names: []string{address}, Url: address}
+	} else {
+		target.Device, err = getDevice(&ipv6, &waitTime, &targetDeviceName)
+		if err != nil {
+			return target, fmt.Errorf("failed to find device: %w", err)
+		}
+	}
+
+	// Notifications that aren't stored, like the self-test's, cast their own video
+	notif, loadErr := scanNotification(a.Stmts.GetNotification.QueryRow(notifID))
+	stored := loadErr == nil
+
+	// Speakers can only play the TTS audio generated for them (see generateSpeakerMedia);
+	// the announced names are checked too in case discovery hadn't classified the device
+	target.AudioOnly = a.isSpeakerDevice(deviceName) || classifyDevice(target.Device) == deviceTypeSpeaker
+
+	// The notification's cast mode picks the HLS video or the static image
+	orientation := ""
+	if stored {
+		target.ImageOnly = !target.AudioOnly && castsImage(notif)
+		orientation = notif.Orientation
+	}
+
+	// A device with its own message (device_messages) gets that variant of the media
+	target.MediaID = notifID
+	if stored {
+		target.MediaID = deviceMediaID(notif, deviceName, target.AudioOnly, target.ImageOnly)
+	}
+	if target.AudioOnly {
+		if _, err := os.Stat(castMediaPath(target.MediaID, true, false)); err != nil {
+			return target, &wrongMediaTypeError{Device: deviceName, DeviceType: deviceTypeSpeaker, Media: "video"}
+		}
+	}
+
+	// A video larger than the device plays is cast anyway with a warning, or replaced by
+	// a copy scaled to fit (DEVICE_RESOLUTION_POLICY)
+	if !target.AudioOnly && !target.ImageOnly && ffmpegAvailable {
+		target.Resolution = a.checkCastResolution(context.Background(), target.MediaID, orientation, deviceName, target.Device)
+		if target.Resolution != nil && target.Resolution.castID != "" {
+			target.MediaID = target.Resolution.castID
+		}
+	}
+	return target, nil
+}
+
+// playMediaWithRetry sends the media to the device, retrying up to CAST_PLAY_RETRIES
+// times with a growing delay, so a momentary glitch heals within one cast attempt. The
+// waits between retries add up to at most castPlayRetryBudget.
+func playMediaWithRetry(ctx context.Context, client *chromecast.Client, deviceURI, mediaURL, notifID string) error {
+	delay := castPlayRetryDelay
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		err := client.PlayMedia(ctx, chromecast.PlayMediaRequest{
+			ChromeCastDeviceURI: deviceURI,
+			MediaURL:            mediaURL,
+		})
+		if err == nil || attempt >= castPlayRetries || waited+delay > castPlayRetryBudget {
+			if err != nil && attempt > 0 {
+				return fmt.Errorf("%w (after %d attempts)", err, attempt+1)
+			}
+			return err
+		}
+		log.Printf("PlayMedia failed for notification %s (attempt %d of %d), retrying in %s: %v",
+			notifID, attempt+1, castPlayRetries+1, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		waited += delay
+		delay *= 2
+	}
+}
+
+// keepCastAlive periodically re-sends the session's media until the cast is stopped
+// (stopCast cancels the session context)
+func (a *App) keepCastAlive(session *CastSession) {
+	ticker := time.NewTicker(castKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-session.Context.Done():
+			log.Printf("Keep-alive stopped for notification %s", session.NotificationID)
+			return
+		case <-ticker.C:
+			session.Mutex.Lock()
+			if !session.Active {
+				session.Mutex.Unlock()
+				return
+			}
+			mediaURL := session.MediaURL
+			session.StartedAt = time.Now()
+			session.Mutex.Unlock()
+
+			err := session.CastClient.PlayMedia(session.Context, chromecast.PlayMediaRequest{
+				ChromeCastDeviceURI: session.DeviceURI,
+				MediaURL:            mediaURL,
+			})
+			if err != nil {
+				log.Printf("Keep-alive failed for notification %s: %v", session.NotificationID, err)
+				continue
+			}
+			log.Printf("Keep-alive sent for notification %s", session.NotificationID)
+		}
+	}
+}
+
+var (
+	// castLivenessInterval is how often the device of an active cast is pinged (0 = off)
+	castLivenessInterval = envDuration("CAST_LIVENESS_INTERVAL", 30*time.Second)
+	// castLivenessFailures is how many pings in a row may fail before the cast is dropped
+	castLivenessFailures = max(envInt("CAST_LIVENESS_FAILURES", 3), 1)
+)
+
+// watchCastLiveness pings the session's device until the cast is stopped. A device
+// that stops answering (rebooted, unplugged, off the network) would otherwise leave a
+// ghost cast active until its end time, so after castLivenessFailures failed pings in
+// a row the cast is dropped (see dropUnreachableCast).
+func (a *App) watchCastLiveness(session *CastSession) {
+	ticker := time.NewTicker(castLivenessInterval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-session.Context.Done():
+			return
+		case <-ticker.C:
+			session.Mutex.RLock()
+			active, deviceURI := session.Active, session.DeviceURI
+			session.Mutex.RUnlock()
+			if !active {
+				return
+			}
+
+			err := checkDeviceReachable(deviceURI)
+			if err == nil {
+				if failures > 0 {
+					log.Printf("Device %s is reachable again for notification %s", session.Device, session.NotificationID)
+				}
+				failures = 0
+				continue
+			}
+			failures++
+			log.Printf("Liveness check %d/%d failed for notification %s: %v", failures, castLivenessFailures, session.NotificationID, err)
+			if failures >= castLivenessFailures {
+				a.dropUnreachableCast(session, err)
+				return
+			}
+		}
+	}
+}
+
+// dropUnreachableCast removes the cast of a device that stopped answering and marks its
+// notification failed. Unlike stopCast, nothing is sent to the device: no end action,
+// stop verification or volume restore. A device whose volume was set for the cast keeps
+// it: it can't be restored while the device is unreachable.
+func (a *App) dropUnreachableCast(session *CastSession, err error) {
 	a.CastMutex.Lock()
 	defer a.CastMutex.Unlock()
 
+	// The cast may have been stopped or moved to the next device in the meantime
+	if a.ActiveCasts[session.NotificationID] != session {
+		return
+	}
+	session.Mutex.Lock()
+	session.Active = false
+	session.Mutex.Unlock()
+	if session.Cancel != nil {
+		session.Cancel()
+	}
+	delete(a.ActiveCasts, session.NotificationID)
+
+	reason := fmt.Sprintf("device %s stopped responding during the cast: %v", session.Device, err)
+	if _, dbErr := a.DB.Exec("UPDATE notifications SET status = 'failed', failure_reason = ? WHERE id = ?", reason, session.NotificationID); dbErr != nil {
+		log.Printf("Failed to mark notification %s failed: %v", session.NotificationID, dbErr)
+	}
+	a.recordFailure(session.NotificationID, "cast", errors.New(reason))
+	log.Printf("Dropped cast for notification %s: %s", session.NotificationID, reason)
+	recordAudit(auditActorSystem, auditCastStop, "notification", session.NotificationID, map[string]any{"device": session.Device, "reason": "device unreachable"})
+}
+
+var (
+	// castStopVerifyAttempts is how many times the receiver's state is checked after a stop
+	castStopVerifyAttempts = envInt("CAST_STOP_VERIFY_ATTEMPTS", 3)
+	// castForceStop sends an explicit stop when the receiver is still playing after those checks
+	castForceStop = envBool("CAST_FORCE_STOP", true)
+)
+
+// mediaStatusReporter is the cast client's report of the receiver's player state
+// ("IDLE", "PLAYING", "BUFFERING", ...)
+type mediaStatusReporter interface {
+	PlayerState(ctx context.Context, deviceURI string) (string, error)
+}
+
+// mediaStopper is the cast client's explicit stop of the receiver's media
+type mediaStopper interface {
+	StopMedia(ctx context.Context, deviceURI string) error
+}
+
+// The stop verification relies on the cast client providing both
+var (
+	_ mediaStatusReporter = (*chromecast.Client)(nil)
+	_ mediaStopper        = (*chromecast.Client)(nil)
+)
+
+// releaseReceiver lets the receiver of a cancelled session go: it checks the receiver went
+// idle (see verifyCastStopped) and puts its volume back. The session's client went down
+// with the session's context, so this runs over a connection of its own. It waits on the
+// device, so callers must not hold CastMutex.
+func releaseReceiver(session *CastSession) error {
+	// Give Chromecast a moment to process the disconnection
+	time.Sleep(1500 * time.Millisecond)
+	if session.CastClient == nil {
+		return nil // test mode: nothing was cast
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := chromecast.New(ctx, &chromecast.Config{Device: session.Target})
+	err := verifyCastStopped(client, session)
+	restoreCastVolume(client, session)
+	return err
+}
+
+// verifyCastStopped checks (with a bounded retry) that the receiver went idle after its
+// session was cancelled, force-stopping it if it is still playing. It returns an error
+// when the receiver is still playing or its state couldn't be read.
+func verifyCastStopped(client *chromecast.Client, session *CastSession) error {
+	state := ""
+	var statusErr error
+	for attempt := 1; attempt <= max(castStopVerifyAttempts, 1); attempt++ {
+		if attempt > 1 {
+			time.Sleep(1 * time.Second)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		current, err := client.PlayerState(ctx, session.DeviceURI)
+		cancel()
+		if statusErr = err; err != nil {
+			log.Printf("Media status unavailable for notification %s after stop (attempt %d): %v", session.NotificationID, attempt, err)
+			continue
+		}
+		state = current
+		if state == "" || state == "IDLE" {
+			log.Printf("Verified cast for notification %s is idle", session.NotificationID)
+			return nil
+		}
+	}
+	if statusErr != nil {
+		return fmt.Errorf("could not verify the receiver stopped notification %s: %w", session.NotificationID, statusErr)
+	}
+
+	if castForceStop {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.StopMedia(ctx, session.DeviceURI); err != nil {
+			return fmt.Errorf("receiver still %s after stopping notification %s, force stop failed: %w", state, session.NotificationID, err)
+		}
+		log.Printf("Force-stopped receiver still %s for notification %s", state, session.NotificationID)
+		return nil
+	}
+	return fmt.Errorf("receiver still %s after stopping notification %s", state, session.NotificationID)
+}
+
+// volumeController is the cast client's access to the receiver's volume (0.0-1.0)
+type volumeController interface {
+	Volume(ctx context.Context, deviceURI string) (float64, error)
+	SetVolume(ctx context.Context, deviceURI string, level float64) error
+}
+
+// The device volumes rely on the cast client providing it
+var _ volumeController = (*chromecast.Client)(nil)
+
+// applyDeviceVolume sets the receiver to the volume stored for the device, if any, and
+// returns the volume it had before so it can be restored when the cast stops (nil when
+// nothing needs restoring)
+func (a *App) applyDeviceVolume(client *chromecast.Client, deviceURI, deviceName string) *float64 {
+	volume := a.deviceVolume(deviceName)
+	if volume == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	previous, readErr := client.Volume(ctx, deviceURI)
+	if readErr != nil {
+		log.Printf("Warning: Could not read the volume of %s, it won't be restored: %v", deviceName, readErr)
+	}
+	if err := client.SetVolume(ctx, deviceURI, *volume); err != nil {
+		log.Printf("Warning: Could not set the volume of %s to %.2f: %v", deviceName, *volume, err)
+		return nil
+	}
+	log.Printf("Set the volume of %s to %.2f", deviceName, *volume)
+
+	if readErr != nil || previous == *volume {
+		return nil
+	}
+	return &previous
+}
+
+// restoreCastVolume puts the receiver back to the volume it had before the cast
+func restoreCastVolume(client *chromecast.Client, session *CastSession) {
+	if session.RestoreVolume == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.SetVolume(ctx, session.DeviceURI, *session.RestoreVolume); err != nil {
+		log.Printf("Warning: Could not restore the volume after notification %s: %v", session.NotificationID, err)
+		return
+	}
+	log.Printf("Restored the volume to %.2f after notification %s", *session.RestoreVolume, session.NotificationID)
+}
+
+// stopCast tears down a notification's cast. With runEndAction (a cast reaching its end time)
+// the notification's end action runs first: a "meeting ended" screen, or a follow-up cast.
+// The ended screen is shown from its own goroutine, which tears the cast down afterwards,
+// so the scheduler doesn't wait for it.
+func (a *App) stopCast(notifID string, runEndAction bool) error {
+	log.Printf("Stopping cast for notification %s", notifID)
+
+	var followUpID, deviceName string
+	if runEndAction {
+		if a.showingEndedScreen(notifID) {
+			return nil
+		}
+		var ending bool
+		if followUpID, deviceName, ending = a.runEndAction(notifID); ending {
+			return nil
+		}
+	}
+
+	a.CastMutex.Lock()
 	session, exists := a.ActiveCasts[notifID]
 	if !exists {
+		a.CastMutex.Unlock()
 		return nil // Already stopped or never started
 	}
 
 	session.Mutex.Lock()
 	if !session.Active {
 		session.Mutex.Unlock()
+		a.CastMutex.Unlock()
 		return nil
 	}
 	session.Active = false // Mark as inactive
@@ -211,19 +886,253 @@ func (a *App) stopCast(notifID string) error {
 		session.Cancel()
 		log.Printf("Cast stopped in session.cancel for notification %s", notifID)
 	}
-	
-	// Give Chromecast a moment to process the disconnection
-	time.Sleep(1500 * time.Millisecond)
-
 	delete(a.ActiveCasts, notifID)
+	a.CastMutex.Unlock()
+
+	// Make sure the receiver actually went idle instead of leaving the screen up before
+	// the notification is completed; the checks wait on the device, so they run after
+	// CastMutex is released
+	details := map[string]any{"device": session.Device}
+	verifyErr := releaseReceiver(session)
+	if verifyErr != nil {
+		log.Printf("Warning: %v", verifyErr)
+		a.recordFailure(notifID, "stop", verifyErr)
+		details["stop_verified"] = false
+		details["stop_error"] = verifyErr.Error()
+	}
 
 	// Update database status
-	_, err := a.DB.Exec("UPDATE notifications SET status = 'completed' WHERE id = ?", notifID)
-	if err != nil {
+	if _, err := a.Stmts.SetStatus.Exec("completed", notifID); err != nil {
 		log.Printf("Failed to update notification status: %v", err)
 	}
 
 	log.Printf("Stopped casting notification %s", notifID)
+	recordAudit(auditActorSystem, auditCastStop, "notification", notifID, details)
+
+	// Start the follow-up once this teardown has released CastMutex
+	if followUpID != "" {
+		go a.castFollowUp(followUpID, deviceName)
+	}
+	return nil
+}
+
+// stopAllCasts stops every active cast without running end actions and returns the
+// stopped notification IDs. The IDs are taken under CastMutex and each is then stopped
+// through stopCast, so the scheduler can keep working in between.
+func (a *App) stopAllCasts() []string {
+	a.CastMutex.RLock()
+	ids := make([]string, 0, len(a.ActiveCasts))
+	for id := range a.ActiveCasts {
+		ids = append(ids, id)
+	}
+	a.CastMutex.RUnlock()
+
+	for _, id := range ids {
+		if err := a.stopCast(id, false); err != nil {
+			log.Printf("Failed to stop cast for notification %s: %v", id, err)
+		}
+	}
+	log.Printf("Stopped all casts (%d)", len(ids))
+	return ids
+}
+
+// runEndAction performs a notification's end-of-cast action before teardown. For
+// "follow_up" it returns the notification to cast next and the device to use. For
+// "ended_screen" it starts showing the "meeting ended" clip and reports ending: the
+// cast is then torn down by showEndedScreen, not by the caller.
+func (a *App) runEndAction(notifID string) (followUpID string, deviceName string, ending bool) {
+	notif, err := scanNotification(a.Stmts.GetNotification.QueryRow(notifID))
+	if err != nil {
+		log.Printf("Failed to load end action for notification %s: %v", notifID, err)
+		return "", "", false
+	}
+
+	switch notif.EndAction {
+	case endActionFollowUp:
+		// A sequenced cast hands the follow-up to the device it ended on
+		if device := a.currentCastDevice(notifID); device != "" {
+			return notif.FollowUpID, device, false
+		}
+		return notif.FollowUpID, notif.Device, false
+	case endActionEndedScreen:
+		a.CastMutex.RLock()
+		session, exists := a.ActiveCasts[notifID]
+		a.CastMutex.RUnlock()
+		if !exists || session.AudioOnly {
+			return "", "", false
+		}
+
+		// The clip is generated with the notification's media; generating it here would
+		// hold up the scheduler
+		clipID := notifID + "_ended"
+		if _, err := os.Stat(castMediaPath(clipID, false, false)); err != nil {
+			log.Printf("No ended screen was generated for notification %s, stopping: %v", notifID, err)
+			return "", "", false
+		}
+
+		session.Mutex.Lock()
+		if !session.Active || session.Ending {
+			session.Mutex.Unlock()
+			return "", "", false
+		}
+		session.Ending = true
+		session.Mutex.Unlock()
+
+		go a.showEndedScreen(notif, session, clipID)
+		return "", "", true
+	}
+	return "", "", false
+}
+
+// showingEndedScreen reports whether a notification's cast is showing its ended screen
+func (a *App) showingEndedScreen(notifID string) bool {
+	a.CastMutex.RLock()
+	session, exists := a.ActiveCasts[notifID]
+	a.CastMutex.RUnlock()
+	if !exists {
+		return false
+	}
+	session.Mutex.RLock()
+	defer session.Mutex.RUnlock()
+	return session.Ending
+}
+
+// showEndedScreen casts the "meeting ended" clip in place of the notification, waits
+// end_screen_seconds and then tears the cast down. A stop in the meantime (e.g. a
+// delete) tears it down early, and the final stop then finds nothing left to do.
+func (a *App) showEndedScreen(notif Notification, session *CastSession, clipID string) {
+	defer a.stopCast(notif.ID, false)
+
+	if session.CastClient == nil {
+		if _, err := exportTestCast(clipID, false, false); err != nil {
+			log.Printf("[TEST MODE] Failed to export ended screen for notification %s: %v", notif.ID, err)
+		}
+		return
+	}
+	localIP, err := ip.GetLANIp()
+	if err != nil {
+		log.Printf("Failed to get local IP for ended screen: %v", err)
+		return
+	}
+
+	err = session.CastClient.PlayMedia(session.Context, chromecast.PlayMediaRequest{
+		ChromeCastDeviceURI: session.DeviceURI,
+		MediaURL:            castMediaURL(localIP, clipID, false, false),
+	})
+	if err != nil {
+		log.Printf("Failed to cast ended screen for notification %s: %v", notif.ID, err)
+		return
+	}
+	session.Mutex.Lock()
+	session.MediaURL = castMediaURL(localIP, clipID, false, false)
+	session.Mutex.Unlock()
+	log.Printf("Showing ended screen for notification %s for %d seconds", notif.ID, notif.EndScreenSeconds)
+
+	select {
+	case <-time.After(time.Duration(notif.EndScreenSeconds) * time.Second):
+	case <-session.Context.Done():
+	}
+}
+
+// castFollowUp casts a follow-up notification right away, generating its video if needed
+func (a *App) castFollowUp(followUpID, fallbackDevice string) {
+	notif, err := scanNotification(a.Stmts.GetLiveNotification.QueryRow(followUpID))
+	if err != nil {
+		log.Printf("Failed to load follow-up notification %s: %v", followUpID, err)
+		return
+	}
+
+	device := notif.Device
+	if device == "" {
+		device = fallbackDevice
+	}
+
+	a.generateVideoIfNeeded(notif)
+	log.Printf("Casting follow-up notification %s to device %s", notif.ID, device)
+	if err := a.startCast(notif.ID, device, notif.Message); err != nil {
+		log.Printf("Failed to cast follow-up notification %s: %v", notif.ID, err)
+		a.recordFailure(notif.ID, "cast", err)
+		a.markCastFailed(notif.ID, err)
+	}
+}
+
+// castMediaURL is the LAN URL the Chromecast plays for a notification.
+// It must be reachable from the device, so it never uses the public base URL.
+// This matches the working example: http://IP:PORT/files/notificationID/playlist.m3u8
+func castMediaURL(localIP, notifID string, audioOnly, imageOnly bool) string {
+	return fmt.Sprintf("http://%s%s/files/%s/%s", localIP, castServerPort, notifID, castMediaName(audioOnly, imageOnly))
+}
+
+// castMediaName is the file cast from ./data/chunks/<id>/: the HLS playlist, a static
+// PNG (no audio) in the image cast mode or when FFmpeg isn't installed, or the TTS
+// audio for speakers
+func castMediaName(audioOnly, imageOnly bool) string {
+	if audioOnly {
+		return speakerAudioName()
+	}
+	if imageOnly || !ffmpegAvailable {
+		return staticCastImage
+	}
+	return "playlist.m3u8"
+}
+
+// castMediaPath is the local path of the media cast for a notification (or clip)
+func castMediaPath(notifID string, audioOnly, imageOnly bool) string {
+	return filepath.Join(chunksDir, notifID, castMediaName(audioOnly, imageOnly))
+}
+
+// notificationCastPath is castMediaPath for a notification's device and cast mode
+func (a *App) notificationCastPath(notif Notification) string {
+	return castMediaPath(notif.ID, a.isSpeakerDevice(notif.Device), castsImage(notif))
+}
+
+// Cast modes: "video" casts the HLS video, "image" the static PNG without audio or
+// any FFmpeg work, and "auto" the image for muted notifications that need no video
+// (no slideshow, clip or overlay) and the video otherwise
+const (
+	castModeAuto  = "auto"
+	castModeVideo = "video"
+	castModeImage = "image"
+)
+
+// castsImage reports whether a notification is cast as its static image
+func castsImage(notif Notification) bool {
+	switch notif.CastMode {
+	case castModeImage:
+		return true
+	case castModeVideo:
+		return false
+	}
+	return notif.Muted && len(notif.Images) == 0 && notif.Clip == "" && notif.Overlay == nil
+}
+
+// castDevicePort is the Chromecast control port, used when a device IP is given without one
+const castDevicePort = "8009"
+
+// parseDeviceAddress recognizes a device given as "ip" or "ip:port" instead of a name.
+// ok reports whether it looks like an address; err is set if it does but is invalid.
+func parseDeviceAddress(device string) (address string, ok bool, err error) {
+	if net.ParseIP(device) != nil {
+		return net.JoinHostPort(device, castDevicePort), true, nil
+	}
+
+	host, port, splitErr := net.SplitHostPort(device)
+	if splitErr != nil || net.ParseIP(host) == nil {
+		return "", false, nil
+	}
+	if portNum, convErr := strconv.Atoi(port); convErr != nil || portNum < 1 || portNum > 65535 {
+		return "", true, fmt.Errorf("invalid port in device address '%s'", device)
+	}
+	return net.JoinHostPort(host, port), true, nil
+}
+
+// checkDeviceReachable opens (and closes) a TCP connection to a device address
+func checkDeviceReachable(address string) error {
+	conn, err := net.DialTimeout("tcp", address, 3*time.Second)
+	if err != nil {
+		return fmt.Errorf("device at %s is not reachable: %w", address, err)
+	}
+	conn.Close()
 	return nil
 }
 
@@ -244,6 +1153,10 @@ func getDevice(ipv6 *bool, waitTime *int, targetDevice *string) (mdns.Device, er
 	mdnsCancel()
 
 	for _, device := range devices {
+		// Match by ID (what aliases resolve to) or by the device's own name
+		if device.Url == *targetDevice {
+			return device, nil
+		}
 		for _, name := range device.Names {
 			if name == *targetDevice {
 				return device, nil
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// clipsDir holds uploaded looping clips, already transcoded for HLS
+var clipsDir = filepath.Join(uploadsDir, "clips")
+
+const (
+	// maxClipSeconds bounds uploaded clips; they are looped to fill the notification
+	maxClipSeconds = 60
+	// clipFrameRate is the frame rate clips are normalized to
+	clipFrameRate = 15
+)
+
+// clipExtensions are the accepted upload containers
+var clipExtensions = map[string]bool{".mp4": true, ".gif": true, ".webm": true, ".mov": true}
+
+// clipCodecs are the video codecs accepted in uploaded clips
+var clipCodecs = map[string]bool{"h264": true, "hevc": true, "vp8": true, "vp9": true, "gif": true, "mpeg4": true}
+
+// uploadClip stores a short MP4/GIF clip that loops for the notification's duration
+// (instead of the generated image) and returns its ID
+func uploadClip(c *fiber.Ctx) error {
+	if !ffmpegAvailable {
+		return c.Status(503).JSON(fiber.Map{"error": "Clips require FFmpeg, which is not installed"})
+	}
+
+	fileHeader, err := c.FormFile("clip")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing 'clip' file"})
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
+	if !clipExtensions[ext] {
+		return c.Status(400).JSON(fiber.Map{"error": "Only MP4, GIF, WebM and MOV clips are supported"})
+	}
+
+	if err := os.MkdirAll(clipsDir, 0755); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create clips directory"})
+	}
+
+	clipID := uuid.New().String() + ".mp4"
+	uploadPath := filepath.Join(clipsDir, "upload-"+uuid.New().String()+ext)
+	if err := c.SaveFile(fileHeader, uploadPath); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save clip"})
+	}
+	defer os.Remove(uploadPath)
+
+	if err := probeClip(uploadPath); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := transcodeClip(uploadPath, filepath.Join(clipsDir, clipID)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	recordAudit(auditActor(c), auditCreate, "clip", clipID, fiber.Map{"filename": fileHeader.Filename})
+
+	return c.Status(201).JSON(fiber.Map{"id": clipID})
+}
+
+// probeClip checks an uploaded clip has a supported video codec and is short enough
+func probeClip(path string) error {
+	out, err := exec.Command("ffprobe", "-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=codec_name:format=duration",
+		"-of", "default=noprint_wrappers=1",
+		path).Output()
+	if err != nil {
+		return fmt.Errorf("not a readable video clip")
+	}
+
+	var codec string
+	var duration float64
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "codec_name":
+			codec = value
+		case "duration":
+			duration, _ = strconv.ParseFloat(value, 64)
+		}
+	}
+
+	if codec == "" {
+		return fmt.Errorf("clip has no video stream")
+	}
+	if !clipCodecs[codec] {
+		return fmt.Errorf("unsupported clip codec '%s'", codec)
+	}
+	if duration > maxClipSeconds {
+		return fmt.Errorf("clip is %.0f seconds long, the maximum is %d", duration, maxClipSeconds)
+	}
+	return nil
+}
+
+// transcodeClip converts a clip to the H.264 baseline profile used for the HLS output,
+// at the output resolution and without audio (the TTS is muxed over it), so it can be
+// looped with a stream copy when the notification video is generated
+func transcodeClip(inputPath, outputPath string) error {
+	videoFilter := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,fps=%d",
+		imageWidth, imageHeight, imageWidth, imageHeight, clipFrameRate)
+
+	cmd := exec.Command("ffmpeg", "-y",
+		"-i", inputPath,
+		"-an", // drop any audio
+		"-vf", videoFilter,
+		"-c:v", "libx264",
+		"-profile:v", "baseline",
+		"-preset", "veryfast",
+		"-crf", "28",
+		"-pix_fmt", "yuv420p",
+		"-g", strconv.Itoa(clipFrameRate*2), // keyframe every 2 seconds so HLS segments can cut cleanly
+		"-movflags", "+faststart",
+		outputPath,
+	)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("failed to transcode clip: %w", err)
+	}
+	return nil
+}
+
+// resolveClip maps an uploaded clip ID to its file path
+func resolveClip(clipID string) (string, error) {
+	if clipID == "" || filepath.Base(clipID) != clipID || strings.HasPrefix(clipID, ".") {
+		return "", fmt.Errorf("invalid clip reference '%s'", clipID)
+	}
+	clipPath := filepath.Join(clipsDir, clipID)
+	if _, err := os.Stat(clipPath); err != nil {
+		return "", fmt.Errorf("clip '%s' not found", clipID)
+	}
+	return clipPath, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+// envString reads a string from the environment
+func envString(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// envDuration reads a Go duration (e.g. "10m", "1h30m") from the environment
+func envDuration(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Printf("Warning: Invalid %s '%s', using default %v", name, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+// envInt reads an integer from the environment
+func envInt(name string, defaultValue int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: Invalid %s '%s', using default %d", name, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// envFloat reads a floating point number from the environment
+func envFloat(name string, defaultValue float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Warning: Invalid %s '%s', using default %g", name, value, defaultValue)
+		return defaultValue
+	}
+	return f
+}
+
+// envBool reads a boolean ("true", "1", ...) from the environment
+func envBool(name string, defaultValue bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Warning: Invalid %s '%s', using default %t", name, value, defaultValue)
+		return defaultValue
+	}
+	return b
+}
+
+// corsConfigFromEnv builds the CORS middleware config from CORS_ALLOWED_ORIGINS
+// (comma-separated, default "*") and CORS_ALLOW_CREDENTIALS.
+// Credentials can't be combined with a wildcard origin, so that is rejected at startup.
+func corsConfigFromEnv() (cors.Config, error) {
+	allowCredentials := envBool("CORS_ALLOW_CREDENTIALS", false)
+
+	var origins []string
+	for _, origin := range strings.Split(envString("CORS_ALLOWED_ORIGINS", "*"), ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		if origin != "*" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return cors.Config{}, fmt.Errorf("invalid CORS origin '%s': expected scheme://host[:port]", origin)
+			}
+		}
+		origins = append(origins, origin)
+	}
+	if len(origins) == 0 {
+		return cors.Config{}, fmt.Errorf("CORS_ALLOWED_ORIGINS is empty")
+	}
+
+	for _, origin := range origins {
+		if origin == "*" && allowCredentials {
+			return cors.Config{}, fmt.Errorf("CORS_ALLOW_CREDENTIALS cannot be used with a wildcard origin; list the allowed origins explicitly")
+		}
+	}
+
+	return cors.Config{
+		AllowOrigins:     strings.Join(origins, ","),
+		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
+		AllowHeaders:     "Origin,Content-Type,Accept,Authorization",
+		AllowCredentials: allowCredentials,
+	}, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// getDashboard returns what the frontend's main view needs in one response: the active
+// casts, the notifications starting within ?within= (default 15m, like
+// /api/notifications/upcoming), the devices and the stats of /api/stats. The devices
+// come from the last discovery rather than a new one, so the call never waits on mDNS;
+// GET /api/devices still discovers on demand.
+func getDashboard(c *fiber.Ctx) error {
+	within := defaultUpcomingWindow
+	if value := c.Query("within"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 || d > maxUpcomingWindow {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("within must be a duration like 15m or 2h, at most %s", maxUpcomingWindow)})
+		}
+		within = d
+	}
+
+	active, err := activeNotifications(c)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+	upcoming, err := upcomingNotifications(c, within)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+	stats, err := collectStats()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	// applyDeviceAliases renames in place, so it gets a copy of the shared cache
+	devices := append([]ChromecastDevice{}, getCachedDevices()...)
+	devices = applyDeviceAliases(devices, loadDeviceAliases(appInstance.ReadDB))
+
+	return c.JSON(fiber.Map{
+		"active":   active,
+		"upcoming": upcoming,
+		"devices":  devices,
+		"stats":    stats,
+		"now":      time.Now().UTC(),
+	})
+}
+
+// activeNotifications returns the notifications being cast, with the device each is on
+func activeNotifications(c *fiber.Ctx) ([]Notification, error) {
+	rows, err := appInstance.ReadDB.Query(`
+		SELECT ` + notificationColumns + `
+		FROM notifications
+		WHERE status = 'active' AND deleted_at IS NULL
+		ORDER BY end_time
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	localIP := lanIP()
+	notifications := []Notification{}
+	for rows.Next() {
+		notif, err := scanNotification(rows)
+		if err != nil {
+			log.Printf("Error reading notification: %v", err)
+			continue
+		}
+		withMediaURLs(c, &notif, localIP)
+		notif.CurrentDevice = appInstance.currentCastDevice(notif.ID)
+		notif.CastResolution = appInstance.castResolution(notif.ID)
+		notifications = append(notifications, notif)
+	}
+	return notifications, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// A separate read pool lets the list and report GET handlers (see App.ReadDB) read
+// without queueing behind the scheduler's and the API's writes. SQLite allows one
+// writer at a time, and in WAL mode readers never block it nor wait for it, so the
+// pool opens the same file read-only (DB_READ_POOL), or a replica of it kept up to date
+// by an external tool such as Litestream or LiteFS (DB_READ_PATH). Either way the file
+// must be in WAL mode; otherwise the GET handlers stay on the primary connection.
+var (
+	dbReadPool = envBool("DB_READ_POOL", false)
+	dbReadPath = envString("DB_READ_PATH", "")
+	// dbReadConnections bounds the read pool's open connections
+	dbReadConnections = max(envInt("DB_READ_CONNECTIONS", 4), 1)
+)
+
+// openReadDB opens the read-only pool, or returns the primary when none is configured
+// or the read database can't be used
+func openReadDB(primary *sql.DB, dbPath string) *sql.DB {
+	if !dbReadPool && dbReadPath == "" {
+		return primary
+	}
+	if mode, err := journalMode(primary); err != nil || mode != "wal" {
+		log.Printf("Warning: The database is not in WAL mode (%q, %v); GET handlers read from the primary connection", mode, err)
+		return primary
+	}
+
+	path := dbPath
+	if dbReadPath != "" {
+		path = dbReadPath
+	}
+	// mode=ro opens the file read-only and _query_only rejects writes on the
+	// connection, so a handler can never write through the read pool
+	readDB, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&_query_only=true")
+	if err != nil {
+		log.Printf("Warning: Could not open the read database %s: %v; GET handlers read from the primary connection", path, err)
+		return primary
+	}
+	readDB.SetMaxOpenConns(dbReadConnections)
+
+	// A replica must be in WAL mode too, or its readers could see a half-applied write
+	if mode, err := journalMode(readDB); err != nil || mode != "wal" {
+		log.Printf("Warning: The read database %s is not in WAL mode (%q, %v); GET handlers read from the primary connection", path, mode, err)
+		readDB.Close()
+		return primary
+	}
+	log.Printf("GET handlers read from %s through a read-only pool of %d connections", path, dbReadConnections)
+	return readDB
+}
+
+// journalMode returns a database's journal mode ("wal", "delete", ...)
+func journalMode(db *sql.DB) (string, error) {
+	var mode string
+	err := db.QueryRow("PRAGMA journal_mode").Scan(&mode)
+	return strings.ToLower(mode), err
+}
+
+// Statements are the hot-path queries, prepared once at startup and shared by the
+// handlers and the scheduler (*sql.Stmt is safe for concurrent use)
+type Statements struct {
+	InsertNotification  *sql.Stmt
+	GetNotification     *sql.Stmt // by ID, including soft-deleted rows
+	GetLiveNotification *sql.Stmt // by ID, excluding soft-deleted rows
+	SetStatus           *sql.Stmt // status, ID
+}
+
+func prepareStatements(db *sql.DB) (*Statements, error) {
+	stmts := &Statements{}
+	queries := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&stmts.InsertNotification, insertNotificationSQL},
+		{&stmts.GetNotification, "SELECT " + notificationColumns + " FROM notifications WHERE id = ?"},
+		{&stmts.GetLiveNotification, "SELECT " + notificationColumns + " FROM notifications WHERE id = ? AND deleted_at IS NULL"},
+		{&stmts.SetStatus, "UPDATE notifications SET status = ? WHERE id = ?"},
+	}
+
+	for _, q := range queries {
+		stmt, err := db.Prepare(q.query)
+		if err != nil {
+			stmts.Close()
+			return nil, fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		*q.stmt = stmt
+	}
+	return stmts, nil
+}
+
+// Close releases every prepared statement
+func (s *Statements) Close() {
+	for _, stmt := range []*sql.Stmt{s.InsertNotification, s.GetNotification, s.GetLiveNotification, s.SetStatus} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// withTx runs fn in a transaction, committing if it succeeds and rolling back otherwise
+func withTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
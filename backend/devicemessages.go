@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DeviceMessages overrides a notification's message on some of the devices it casts to
+// (its device and the devices of its device_sequence), e.g. one thing for the kitchen
+// and another for the office. Each override gets its own rendered variant of the media;
+// the other devices get the notification's message.
+type DeviceMessages map[string]string
+
+// MarshalXML writes the overrides as <device name="...">message</device> elements,
+// sorted by device (encoding/xml can't marshal maps)
+func (m DeviceMessages) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type deviceMessage struct {
+		Name    string `xml:"name,attr"`
+		Message string `xml:",chardata"`
+	}
+	devices := make([]string, 0, len(m))
+	for device := range m {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	entries := make([]deviceMessage, len(devices))
+	for i, device := range devices {
+		entries[i] = deviceMessage{Name: device, Message: m[device]}
+	}
+	return e.EncodeElement(struct {
+		Devices []deviceMessage `xml:"device"`
+	}{entries}, start)
+}
+
+// validateDeviceMessages checks device_messages against the devices the notification
+// casts to and returns it with the names trimmed
+func validateDeviceMessages(messages map[string]string, device string, sequence []string) (DeviceMessages, error) {
+	if len(messages) > maxSequenceDevices {
+		return nil, fmt.Errorf("device_messages cannot have more than %d devices", maxSequenceDevices)
+	}
+
+	devices := map[string]bool{device: true}
+	for _, d := range sequence {
+		devices[d] = true
+	}
+
+	validated := make(DeviceMessages, len(messages))
+	for name, message := range messages {
+		name = strings.TrimSpace(name)
+		if !devices[name] {
+			return nil, fmt.Errorf("device_messages entry %s is not the notification's device or in its device_sequence", name)
+		}
+		if strings.TrimSpace(message) == "" {
+			return nil, fmt.Errorf("device_messages entry %s has an empty message", name)
+		}
+		if err := validateMessage(message); err != nil {
+			return nil, fmt.Errorf("device_messages entry %s: %w", name, err)
+		}
+		validated[name] = message
+	}
+	return validated, nil
+}
+
+// deviceVariantID is the media directory of a notification's variant for a device
+// (hashed, since a device name can contain anything)
+func deviceVariantID(notifID, device string) string {
+	sum := sha256.Sum256([]byte(device))
+	return notifID + "_device-" + hex.EncodeToString(sum[:6])
+}
+
+// deviceVariant is the notification as rendered for a device with its own message.
+// It has nothing the base media already covers: no overrides, hand-offs or end action.
+func deviceVariant(notif Notification, device string) Notification {
+	variant := notif
+	variant.ID = deviceVariantID(notif.ID, device)
+	variant.Message = notif.DeviceMessages[device]
+	variant.Device = device
+	variant.DeviceMessages = nil
+	variant.DeviceSequence = nil
+	variant.EndAction = endActionStop
+	variant.MediaHash = ""
+	return variant
+}
+
+// generateDeviceVariants renders the variant of each device_messages override. Only the
+// media is rendered: the metrics and media hash belong to the notification. A variant
+// that fails is logged and skipped: its device then casts the notification's message.
+func generateDeviceVariants(ctx context.Context, notif Notification) error {
+	for device := range notif.DeviceMessages {
+		variant := deviceVariant(notif, device)
+		_, _, err := renderNotificationMedia(ctx, variant, variant.ID+stagingSuffix)
+		if err == nil {
+			err = publishMedia(variant.ID)
+		}
+		if err != nil {
+			os.RemoveAll(filepath.Join(chunksDir, variant.ID+stagingSuffix))
+			if ctx.Err() != nil {
+				return fmt.Errorf("generation cancelled: %w", ctx.Err())
+			}
+			log.Printf("Failed to generate the %s variant of notification %s, it will get the notification's message: %v", device, notif.ID, err)
+		}
+	}
+	return nil
+}
+
+// deviceMediaID is the media directory cast to a device: the device's variant when it
+// has its own message and the variant was generated, the notification's otherwise
+func deviceMediaID(notif Notification, deviceName string, audioOnly, imageOnly bool) string {
+	if _, ok := notif.DeviceMessages[deviceName]; !ok {
+		return notif.ID
+	}
+	variantID := deviceVariantID(notif.ID, deviceName)
+	if _, err := os.Stat(castMediaPath(variantID, audioOnly, imageOnly)); err != nil {
+		log.Printf("Warning: No %s variant of notification %s, casting the notification's message: %v", deviceName, notif.ID, err)
+		return notif.ID
+	}
+	return variantID
+}
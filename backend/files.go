@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// debugToken authorizes the debugging endpoints (sent as "Authorization: Bearer <token>");
+// they are disabled when unset since they reveal filesystem paths
+var debugToken = envString("DEBUG_TOKEN", "")
+
+// generatedFile describes one on-disk artifact of a notification
+type generatedFile struct {
+	Kind   string `json:"kind"` // "image", "audio", "playlist", ...
+	Path   string `json:"path"`
+	Exists bool   `json:"exists"`
+	Size   int64  `json:"size,omitempty"` // bytes
+}
+
+// generatedMedia summarizes a notification's HLS output directory
+type generatedMedia struct {
+	Dir          string          `json:"dir"`
+	Exists       bool            `json:"exists"`
+	SegmentCount int             `json:"segment_count"`
+	SegmentBytes int64           `json:"segment_bytes"`
+	Files        []generatedFile `json:"files"`
+}
+
+// requireDebugToken is middleware that only lets debugging requests with the
+// DEBUG_TOKEN bearer token through
+func requireDebugToken(c *fiber.Ctx) error {
+	if debugToken == "" {
+		return c.Status(404).JSON(fiber.Map{"error": "Debug endpoints are not enabled"})
+	}
+	token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(debugToken)) != 1 {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid debug token"})
+	}
+	return c.Next()
+}
+
+// statGeneratedFile reports whether an artifact exists and its size
+func statGeneratedFile(kind, path string) generatedFile {
+	file := generatedFile{Kind: kind, Path: path}
+	if info, err := os.Stat(path); err == nil {
+		file.Exists = true
+		file.Size = info.Size()
+	}
+	return file
+}
+
+// inspectMediaDir lists the playlists and counts the segments in a ./data/chunks directory
+func inspectMediaDir(id string) generatedMedia {
+	dir := filepath.Join(chunksDir, id)
+	media := generatedMedia{Dir: dir, Files: []generatedFile{}}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return media
+	}
+	media.Exists = true
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if isHLSSegment(entry.Name()) {
+			media.SegmentCount++
+			media.SegmentBytes += info.Size()
+			continue
+		}
+		media.Files = append(media.Files, generatedFile{
+			Kind:   "media",
+			Path:   filepath.Join(dir, entry.Name()),
+			Exists: true,
+			Size:   info.Size(),
+		})
+	}
+	return media
+}
+
+// getNotificationFiles lists the generated artifacts of a notification and whether they
+// exist, to diagnose casts that show a blank screen
+func getNotificationFiles(c *fiber.Ctx) error {
+	notif, err := scanNotification(appInstance.Stmts.GetNotification.QueryRow(c.Params("id")))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Database error: %v", err)})
+	}
+
+	files := []generatedFile{
+		statGeneratedFile("image", filepath.Join(imagesDir, notif.ID+".png")),
+		statGeneratedFile("audio", filepath.Join(audioDir, notif.ID+ttsAudio.Extension)),
+		statGeneratedFile("audio_single", filepath.Join(audioDir, notif.ID+"_single"+ttsAudio.Extension)),
+	}
+	if notif.EndingSoonMinutes > 0 {
+		files = append(files, statGeneratedFile("audio_ending_soon", filepath.Join(audioDir, notif.ID+"_ending"+ttsAudio.Extension)))
+	}
+
+	response := fiber.Map{
+		"id":          notif.ID,
+		"status":      notif.Status,
+		"ffmpeg":      ffmpegAvailable,
+		"media_ready": mediaUpToDate(notif),
+		"cast_media":  statGeneratedFile("cast_media", appInstance.notificationCastPath(notif)),
+		"files":       files,
+		"media":       inspectMediaDir(notif.ID),
+	}
+	if notif.EndAction == endActionEndedScreen {
+		response["ended_media"] = inspectMediaDir(notif.ID + "_ended")
+	}
+	return c.JSON(response)
+}
@@ -3,12 +3,13 @@ module notification-backend
 go 1.24
 
 require (
+	github.com/fogleman/gg v1.3.0
 	github.com/gofiber/fiber/v2 v2.52.0
-	github.com/mattn/go-sqlite3 v1.14.19
 	github.com/google/uuid v1.5.0
+	github.com/mattn/go-sqlite3 v1.14.19
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 )
 
-
 require (
 	github.com/andybalholm/brotli v1.0.5 // indirect
 	github.com/buger/jsonparser v1.1.1 // indirect
@@ -26,4 +27,3 @@ require (
 	golang.org/x/sys v0.15.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 )
-
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// icsTimeZone is the zone notification times are displayed and spoken in (see image.go);
+// exported events use it too, so a calendar shows the same times as the screen
+const icsTimeZone = "America/New_York"
+
+// icsVTimezone describes icsTimeZone for calendars that need the zone's rules in the
+// file (current US daylight saving rules)
+const icsVTimezone = `BEGIN:VTIMEZONE
+TZID:America/New_York
+X-LIC-LOCATION:America/New_York
+BEGIN:DAYLIGHT
+TZOFFSETFROM:-0500
+TZOFFSETTO:-0400
+TZNAME:EDT
+DTSTART:19700308T020000
+RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU
+END:DAYLIGHT
+BEGIN:STANDARD
+TZOFFSETFROM:-0400
+TZOFFSETTO:-0500
+TZNAME:EST
+DTSTART:19701101T020000
+RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU
+END:STANDARD
+END:VTIMEZONE`
+
+// icsMaxLineOctets is the longest content line allowed before folding (RFC 5545)
+const icsMaxLineOctets = 75
+
+// exportICS returns the notifications as a downloadable iCalendar file, one VEVENT per
+// notification with the message as its summary, so scheduled casts can be backed up or
+// viewed in a calendar app. It accepts the filters of GET /api/notifications.
+func exportICS(c *fiber.Ctx) error {
+	where, args, err := notificationFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	rows, err := appInstance.ReadDB.Query(`
+		SELECT `+notificationColumns+`
+		FROM notifications
+		`+where+`
+		ORDER BY start_time
+	`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+	defer rows.Close()
+
+	location, err := time.LoadLocation(icsTimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	stamp := time.Now().UTC()
+
+	var b strings.Builder
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//meetingCaster//Notifications "+version+"//EN")
+	writeICSLine(&b, "CALSCALE:GREGORIAN")
+	writeICSLine(&b, "METHOD:PUBLISH")
+	writeICSLine(&b, "X-WR-CALNAME:meetingCaster notifications")
+	if location != time.UTC {
+		writeICSLine(&b, "X-WR-TIMEZONE:"+icsTimeZone)
+		for _, line := range strings.Split(icsVTimezone, "\n") {
+			writeICSLine(&b, line)
+		}
+	}
+
+	count := 0
+	for rows.Next() {
+		notif, err := scanNotification(rows)
+		if err != nil {
+			log.Printf("Error reading notification: %v", err)
+			continue
+		}
+		writeICSEvent(&b, notif, location, stamp)
+		count++
+	}
+	writeICSLine(&b, "END:VCALENDAR")
+
+	log.Printf("Exported %d notifications to ICS", count)
+	c.Set("Content-Type", "text/calendar; charset=utf-8")
+	c.Set("Content-Disposition", `attachment; filename="notifications.ics"`)
+	return c.SendString(b.String())
+}
+
+// writeICSEvent writes one notification as a VEVENT
+func writeICSEvent(b *strings.Builder, notif Notification, location *time.Location, stamp time.Time) {
+	summary := plainMessage(filterMessage(notif.Message))
+	if summary == "" {
+		summary = messageURLFallback
+	}
+
+	var description []string
+	description = append(description, "Type: "+notif.Type, "Status: "+notif.Status)
+	if notif.RepeatCount > 1 {
+		description = append(description, fmt.Sprintf("Repeats: %d", notif.RepeatCount))
+	}
+	if notif.MessageURL != "" {
+		description = append(description, "Message URL: "+notif.MessageURL)
+	}
+	if notif.AgendaFile != "" {
+		description = append(description, "Agenda file: "+notif.AgendaFile)
+	}
+	if notif.FailureReason != "" {
+		description = append(description, "Failure: "+notif.FailureReason)
+	}
+
+	writeICSLine(b, "BEGIN:VEVENT")
+	writeICSLine(b, "UID:"+notif.ID+"@meetingcaster")
+	writeICSLine(b, "DTSTAMP:"+stamp.Format("20060102T150405Z"))
+	writeICSLine(b, "DTSTART"+icsTime(notif.StartTime, location))
+	// Pinned statuses have no end; they show as a one-hour event
+	if notif.Pinned {
+		writeICSLine(b, "DURATION:PT1H")
+	} else {
+		writeICSLine(b, "DTEND"+icsTime(notif.EndTime, location))
+	}
+	writeICSLine(b, "SUMMARY:"+icsEscape(summary))
+	writeICSLine(b, "DESCRIPTION:"+icsEscape(strings.Join(description, "\n")))
+	if notif.Device != "" {
+		writeICSLine(b, "LOCATION:"+icsEscape(notif.Device))
+	}
+	writeICSLine(b, "CATEGORIES:"+icsEscape(notif.Type))
+	if notif.DeletedAt != nil || notif.Status == "failed" || notif.Status == "missed" {
+		writeICSLine(b, "STATUS:CANCELLED")
+	} else {
+		writeICSLine(b, "STATUS:CONFIRMED")
+	}
+	writeICSLine(b, "TRANSP:TRANSPARENT")
+	writeICSLine(b, "END:VEVENT")
+}
+
+// icsTime formats a DTSTART/DTEND value (with its TZID parameter) in the export zone,
+// or in UTC when the zone database isn't available
+func icsTime(t time.Time, location *time.Location) string {
+	if location == time.UTC {
+		return ":" + t.UTC().Format("20060102T150405Z")
+	}
+	return ";TZID=" + icsTimeZone + ":" + t.In(location).Format("20060102T150405")
+}
+
+// icsEscape escapes a TEXT value: backslashes, semicolons, commas and line breaks
+func icsEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(value)
+}
+
+// writeICSLine writes a content line ending in CRLF, folded at 75 octets without
+// splitting a UTF-8 character (continuation lines start with a space)
+func writeICSLine(b *strings.Builder, line string) {
+	limit := icsMaxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		limit = icsMaxLineOctets - 1 // the leading space counts
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
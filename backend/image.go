@@ -2,126 +2,725 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"image"
-	"image/color"
 	"log"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
+	_ "time/tzdata" // embedded zone database so America/New_York resolves even without system tzdata
 
-	texttospeech "cloud.google.com/go/texttospeech/apiv1"
 	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
 	"github.com/fogleman/gg"
 )
 
+const (
+	// Output resolution for generated images and videos
+	imageWidth  = 1280
+	imageHeight = 800
 
-// wrapText wraps text into multiple lines
-func wrapText(text string, maxWidth int) []string {
-	words := strings.Fields(text)
-	if len(words) == 0 {
-		return []string{""}
+	// uploadsDir holds images uploaded for slideshows
+	uploadsDir = "/data/uploads"
+
+	// messageSlideRef refers to the generated notification image in a slideshow
+	messageSlideRef = "message"
+
+	// defaultSlideInterval is how long each slideshow image is shown, in seconds
+	defaultSlideInterval = 10
+)
+
+// ffmpegAvailable is false when FFmpeg isn't installed; casts then fall back to
+// the static notification image, without audio (see detectFFmpeg)
+var ffmpegAvailable = true
+
+// staticCastImage is the file name of the image cast in place of the HLS video
+const staticCastImage = "image.png"
+
+// detectFFmpeg checks that FFmpeg is on the PATH and reads its version
+func detectFFmpeg() {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		ffmpegAvailable = false
+		log.Printf("Warning: FFmpeg not found (%v); notifications will be cast as static images without audio", err)
+		return
 	}
+	ffmpegVersion = readFFmpegVersion()
+}
 
-	var lines []string
-	currentLine := words[0]
+// ttsAudioFormat is a TTS output encoding and what FFmpeg needs to handle it
+type ttsAudioFormat struct {
+	Encoding      texttospeechpb.AudioEncoding
+	Extension     string
+	FFmpegDecoder string // to read the TTS output (concat, video muxing)
+	FFmpegEncoder string // to write the re-encoded concat filter output
+}
 
-	for _, word := range words[1:] {
-		testLine := currentLine + " " + word
-		if len(testLine) <= maxWidth {
-			currentLine = testLine
-		} else {
-			lines = append(lines, currentLine)
-			currentLine = word
+// ttsAudioFormats are the encodings supported by both Google TTS and FFmpeg
+var ttsAudioFormats = map[string]ttsAudioFormat{
+	"mp3": {texttospeechpb.AudioEncoding_MP3, ".mp3", "mp3", "libmp3lame"},
+	"ogg": {texttospeechpb.AudioEncoding_OGG_OPUS, ".ogg", "opus", "libopus"},
+}
+
+// ttsAudio is the selected TTS output format (see initTTSAudioFormat)
+var ttsAudio = ttsAudioFormats["mp3"]
+
+// initTTSAudioFormat selects the TTS encoding from TTS_AUDIO_ENCODING ("mp3" or "ogg"),
+// falling back to MP3 if the value is unknown or FFmpeg can't handle the format
+func initTTSAudioFormat() {
+	name := strings.ToLower(envString("TTS_AUDIO_ENCODING", "mp3"))
+	format, ok := ttsAudioFormats[name]
+	if !ok {
+		log.Printf("Warning: Unsupported TTS_AUDIO_ENCODING '%s' (expected mp3 or ogg), using mp3", name)
+		return
+	}
+
+	if name != "mp3" && ffmpegAvailable {
+		if err := checkFFmpegCodecs(format.FFmpegDecoder, format.FFmpegEncoder); err != nil {
+			log.Printf("Warning: FFmpeg cannot handle %s TTS audio (%v), using mp3", name, err)
+			return
+		}
+	}
+
+	ttsAudio = format
+	log.Printf("Using %s TTS audio", name)
+}
+
+// checkFFmpegCodecs verifies the installed FFmpeg has the given decoder and encoder
+func checkFFmpegCodecs(decoder, encoder string) error {
+	decoders, err := exec.Command("ffmpeg", "-hide_banner", "-decoders").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list ffmpeg decoders: %w", err)
+	}
+	if !strings.Contains(string(decoders), " "+decoder+" ") {
+		return fmt.Errorf("decoder %s not available", decoder)
+	}
+
+	encoders, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list ffmpeg encoders: %w", err)
+	}
+	if !strings.Contains(string(encoders), " "+encoder+" ") {
+		return fmt.Errorf("encoder %s not available", encoder)
+	}
+	return nil
+}
+
+// synthesizeSpeech synthesizes a segment with the TTS provider (see tts.go) and writes
+// the audio to outputPath
+func synthesizeSpeech(segment speechSegment, outputPath string) error {
+	// Respect the monthly TTS budget before calling a paid API
+	metered := speechProvider.Metered()
+	if metered {
+		if err := checkTTSQuota(segment.Text); err != nil {
+			return err
+		}
+	}
+	// Fail fast while TTS is down (see ttsbreaker.go)
+	if err := ttsBreakerAllow(); err != nil {
+		return err
+	}
+
+	audio, err := speechProvider.Synthesize(segment)
+	ttsBreakerRecord(err)
+	if err != nil {
+		return err
+	}
+	if metered {
+		recordTTSUsage(segment.Text)
+	}
+
+	// Write the audio content to file
+	if err := os.WriteFile(outputPath, audio, 0644); err != nil {
+		return fmt.Errorf("failed to write audio file: %w", err)
+	}
+	return nil
+}
+
+// cachedSpeech returns the cached audio of a segment, synthesizing it on a miss.
+// Segments repeat across notifications (the greeting only varies with the end time).
+func cachedSpeech(segment speechSegment) (string, error) {
+	if err := os.MkdirAll(ttsCacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create TTS cache directory: %w", err)
+	}
+
+	keyText := segment.Voice + "\x00" + segment.Text
+	if ttsSpeakingRate != 1.0 {
+		// Only a non-default rate is part of the key, so existing entries stay valid
+		keyText = fmt.Sprintf("%s\x00%g", keyText, ttsSpeakingRate)
+	}
+	if ttsProviderName != "google" {
+		// Likewise only another provider is part of the key
+		keyText = ttsProviderName + "\x00" + keyText
+	}
+	key := sha256.Sum256([]byte(keyText))
+	cachePath := filepath.Join(ttsCacheDir, hex.EncodeToString(key[:])+ttsAudio.Extension)
+	if _, err := os.Stat(cachePath); err == nil {
+		// Refresh the modification time so the janitor keeps segments still in use
+		now := time.Now()
+		os.Chtimes(cachePath, now, now)
+		return cachePath, nil
+	}
+
+	// Write to a temporary name so a failed synthesis never leaves a partial cache entry
+	tmpPath := cachePath + ".tmp"
+	if err := synthesizeSpeech(segment, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := os.Rename(tmpPath, cachePath); err != nil {
+		return "", fmt.Errorf("failed to cache TTS segment: %w", err)
+	}
+	return cachePath, nil
+}
+
+// Speech speed. TTS_SPEAKING_RATE is Google's own speaking rate (0.25-4.0): the voice
+// is synthesized faster, at no extra cost, but some voices ignore it. TTS_TEMPO
+// (0.5-2.0) time-stretches the synthesized audio with FFmpeg's atempo filter, which
+// keeps the pitch and works with every voice. Both can be combined.
+var (
+	ttsSpeakingRate = envFloat("TTS_SPEAKING_RATE", 1.0)
+	ttsTempo        = envFloat("TTS_TEMPO", 1.0)
+)
+
+// speechLoudness is the integrated loudness the speech is normalized to with FFmpeg's
+// loudnorm filter (AUDIO_LOUDNESS_LUFS, e.g. -16; 0 = off), so short and long
+// messages and different voices play at the same volume
+var speechLoudness = envFloat("AUDIO_LOUDNESS_LUFS", 0)
+
+// initSpeechAudio falls back to the defaults for out-of-range speed and loudness
+// settings; TTS_TEMPO and AUDIO_LOUDNESS_LUFS need FFmpeg
+func initSpeechAudio() {
+	if ttsSpeakingRate < 0.25 || ttsSpeakingRate > 4.0 {
+		log.Printf("Warning: TTS_SPEAKING_RATE %g is outside 0.25-4.0, using 1.0", ttsSpeakingRate)
+		ttsSpeakingRate = 1.0
+	}
+	if ttsTempo < 0.5 || ttsTempo > 2.0 {
+		log.Printf("Warning: TTS_TEMPO %g is outside 0.5-2.0, using 1.0", ttsTempo)
+		ttsTempo = 1.0
+	}
+	if speechLoudness != 0 && (speechLoudness < -70 || speechLoudness > -5) {
+		log.Printf("Warning: AUDIO_LOUDNESS_LUFS %g is outside -70 to -5, not normalizing", speechLoudness)
+		speechLoudness = 0
+	}
+	if attentionBeepFrequency < 20 || attentionBeepFrequency > 20000 {
+		log.Printf("Warning: ATTENTION_BEEP_FREQUENCY %d is outside 20-20000 Hz, using 880", attentionBeepFrequency)
+		attentionBeepFrequency = 880
+	}
+	if attentionBeepDuration < 50*time.Millisecond || attentionBeepDuration > 3*time.Second {
+		log.Printf("Warning: ATTENTION_BEEP_DURATION %s is outside 50ms-3s, using 300ms", attentionBeepDuration)
+		attentionBeepDuration = 300 * time.Millisecond
+	}
+	if !ffmpegAvailable && (ttsTempo != 1.0 || speechLoudness != 0) {
+		log.Printf("Warning: TTS_TEMPO and AUDIO_LOUDNESS_LUFS need FFmpeg, speech is played as synthesized")
+		ttsTempo, speechLoudness = 1.0, 0
+	}
+}
+
+// speechFilters is the FFmpeg audio filter chain applied to synthesized speech
+// (empty when the speech is used as synthesized)
+func speechFilters() []string {
+	var filters []string
+	if ttsTempo != 1.0 {
+		filters = append(filters, fmt.Sprintf("atempo=%g", ttsTempo))
+	}
+	if speechLoudness != 0 {
+		// Single-pass loudnorm, after the tempo change so it measures the final speech
+		filters = append(filters, fmt.Sprintf("loudnorm=I=%g:TP=-1.5:LRA=11", speechLoudness))
+	}
+	return filters
+}
+
+// processSpeechAudio applies the speech filters (TTS_TEMPO, AUDIO_LOUDNESS_LUFS) to an
+// audio file in place, in one FFmpeg pass whose time is logged
+func processSpeechAudio(path string) error {
+	filters := speechFilters()
+	if len(filters) == 0 {
+		return nil
+	}
+
+	started := time.Now()
+	tmpPath := strings.TrimSuffix(path, ttsAudio.Extension) + "_processed" + ttsAudio.Extension
+	cmd := exec.Command("ffmpeg", "-y", "-i", path,
+		"-filter:a", strings.Join(filters, ","),
+		"-ar", "16000", // keep the TTS sample rate (loudnorm upsamples)
+		"-c:a", ttsAudio.FFmpegEncoder,
+		tmpPath)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("ffmpeg %s failed: %w", strings.Join(filters, ","), err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	log.Printf("Processed speech audio %s (%s) in %s", filepath.Base(path), strings.Join(filters, ","), time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// audioConcatMethod picks how repeated TTS audio is joined: "auto" (concat demuxer,
+// falling back to the concat filter), "demuxer" or "filter"
+var audioConcatMethod = envString("AUDIO_CONCAT_METHOD", "auto")
+
+// Audio pipelines (AUDIO_PIPELINE): "single" repeats the TTS, pads it with silence and
+// muxes it in the video's FFmpeg command; "two_pass" first writes the repeated audio
+// file (see AUDIO_CONCAT_METHOD), then muxes it
+const (
+	audioPipelineSingle  = "single"
+	audioPipelineTwoPass = "two_pass"
+)
+
+var audioPipeline = envString("AUDIO_PIPELINE", audioPipelineSingle)
+
+// initAudioPipeline falls back to the single-pass pipeline for unknown AUDIO_PIPELINE values
+func initAudioPipeline() {
+	if audioPipeline != audioPipelineSingle && audioPipeline != audioPipelineTwoPass {
+		log.Printf("Warning: Unknown AUDIO_PIPELINE '%s', using %s", audioPipeline, audioPipelineSingle)
+		audioPipeline = audioPipelineSingle
+	}
+}
+
+// HLS outputs (HLS_SEGMENT_MODE): "segments" cuts the video into MPEG-TS segments of
+// HLS_SEGMENT_SECONDS; "single" writes the whole video as one MPEG-TS segment; "fmp4"
+// uses fragmented MP4 segments (with an init.mp4). Only "segments" has been checked on
+// Chromecasts: the other two are experimental.
+const (
+	hlsModeSegments = "segments"
+	hlsModeSingle   = "single"
+	hlsModeFMP4     = "fmp4"
+)
+
+var (
+	hlsSegmentMode    = envString("HLS_SEGMENT_MODE", hlsModeSegments)
+	hlsSegmentSeconds = envInt("HLS_SEGMENT_SECONDS", 10)
+)
+
+// initHLSOutput falls back to the defaults for unknown or invalid HLS settings
+func initHLSOutput() {
+	switch hlsSegmentMode {
+	case hlsModeSegments, hlsModeSingle, hlsModeFMP4:
+	default:
+		log.Printf("Warning: Unknown HLS_SEGMENT_MODE '%s', using %s", hlsSegmentMode, hlsModeSegments)
+		hlsSegmentMode = hlsModeSegments
+	}
+	if hlsSegmentMode != hlsModeSegments {
+		log.Printf("Warning: HLS_SEGMENT_MODE %s is experimental and hasn't been checked on Chromecasts; use %s if casts don't play", hlsSegmentMode, hlsModeSegments)
+	}
+	if hlsSegmentSeconds < 1 {
+		log.Printf("Warning: HLS_SEGMENT_SECONDS must be at least 1, using 10")
+		hlsSegmentSeconds = 10
+	}
+}
+
+// hlsSegmentArgs are the FFmpeg HLS muxer options that shape the segments of a video
+// lasting durationSeconds, written to videosDir
+func hlsSegmentArgs(videosDir string, durationSeconds int) []string {
+	segmentSeconds := hlsSegmentSeconds
+	if hlsSegmentMode == hlsModeSingle {
+		segmentSeconds = durationSeconds + 1 // longer than the video: never cut
+	}
+	args := []string{"-hls_time", fmt.Sprintf("%d", segmentSeconds)} // segment duration
+	if hlsSegmentMode == hlsModeFMP4 {
+		return append(args,
+			"-hls_segment_type", "fmp4", // fragmented MP4 segments
+			"-hls_fmp4_init_filename", "init.mp4", // init segment, next to the playlist
+			"-hls_segment_filename", filepath.Join(videosDir, "%d.m4s"), // segment file naming pattern
+		)
+	}
+	return append(args, "-hls_segment_filename", filepath.Join(videosDir, "%d.ts")) // segment file naming pattern
+}
+
+// isHLSSegment reports whether a file in a chunks directory is an HLS media segment
+func isHLSSegment(name string) bool {
+	return strings.HasSuffix(name, ".ts") || strings.HasSuffix(name, ".m4s")
+}
+
+// Time formats selectable with TIME_FORMAT; anything else is a custom Go layout
+const (
+	timeFormat12h = "12h"
+	timeFormat24h = "24h"
+)
+
+// timeFormat is TIME_FORMAT: 12h (US style), 24h or a Go time layout
+var timeFormat = envString("TIME_FORMAT", timeFormat12h)
+
+// displayTimeFormat renders times on the image, e.g. "2:00 PM EDT". "MST" is Go's layout
+// token for the zone abbreviation, so it prints EST or EDT depending on the date's DST state.
+// speechTimeFormat is the spoken end time, and clockFormat the strftime format of the
+// overlay clock. All three follow TIME_FORMAT (see initTimeFormat).
+var (
+	displayTimeFormat = "3:04 PM MST"
+	speechTimeFormat  = "3:04 PM"
+	clockFormat       = "%I:%M %p"
+)
+
+// initTimeFormat applies TIME_FORMAT. A custom layout must show the hour and minutes
+// (e.g. "15h04" or "3:04 pm"); it is used on the image as is, and the speech and clock
+// use the 24-hour or 12-hour format matching its hour token.
+func initTimeFormat() {
+	switch timeFormat {
+	case timeFormat12h:
+		return
+	case timeFormat24h:
+		displayTimeFormat = "15:04 MST"
+	default:
+		if !strings.Contains(timeFormat, "04") || !(strings.Contains(timeFormat, "15") || strings.Contains(timeFormat, "3")) {
+			log.Printf("Warning: Invalid TIME_FORMAT '%s' (expected 12h, 24h or a Go time layout with the hour and minutes like 15:04), using 12h", timeFormat)
+			timeFormat = timeFormat12h
+			return
+		}
+		displayTimeFormat = timeFormat
+		if !strings.Contains(timeFormat, "15") {
+			return
 		}
 	}
-	lines = append(lines, currentLine)
+	speechTimeFormat = "15:04"
+	clockFormat = "%H:%M"
+}
+
+// imageTimeLabel is a time as shown on the image: in America/New_York (UTC if the zone
+// can't be loaded), with the EST or EDT abbreviation matching the date
+func imageTimeLabel(t time.Time) string {
+	estLocation, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		log.Printf("Warning: Could not load EST timezone, using UTC: %v", err)
+		estLocation = time.UTC
+	}
+	return t.In(estLocation).Format(displayTimeFormat)
+}
+
+// endingSoonText is spoken ahead of the end time; {minutes} is replaced with the lead time
+var endingSoonText = envString("ENDING_SOON_TEXT", "Heads up, the meeting is ending in {minutes} minutes.")
+
+// Message layout on the image: characters per wrapped line, and how many lines are
+// shown before the message is truncated with an ellipsis (MESSAGE_MAX_LINES)
+const messageLineWidth = 30
+
+var messageMaxLines = max(envInt("MESSAGE_MAX_LINES", 5), 1)
+
+// Display orientations. Portrait swaps the output resolution (800x1280) for
+// tablets and displays mounted on their side; empty means landscape.
+const (
+	orientationLandscape = "landscape"
+	orientationPortrait  = "portrait"
+)
+
+// validateOrientation checks a notification's orientation option
+func validateOrientation(orientation string) error {
+	switch orientation {
+	case "", orientationLandscape, orientationPortrait:
+		return nil
+	}
+	return fmt.Errorf("invalid orientation '%s' (expected %s or %s)", orientation, orientationLandscape, orientationPortrait)
+}
 
-	return lines
+// imageLayout is the output size and font sizes of a generated image
+type imageLayout struct {
+	Width, Height int
+	TitleSize     float64
+	MessageSize   float64
+	TimeSize      float64
+	LineWidth     int // characters per wrapped message line
+	MaxLines      int
 }
 
+// Text placement relative to the canvas, so layouts follow the resolution
+const (
+	layoutTitleTop    = 0.225  // title baseline with the "top" anchor, as a fraction of the height
+	layoutTitleGap    = 0.2125 // title baseline to the first message line, as a fraction of the height
+	layoutLineSpacing = 1.33   // message line spacing, in message font sizes
+	layoutTimeBottom  = 0.1    // time line baseline above the bottom edge, as a fraction of the height
+	layoutMarginX     = 0.06   // side margin for left/right alignment, as a fraction of the width
+)
+
+// TextLayout positions the text on the notification image. A nil TextLayout centers
+// the text horizontally with the title and message at the top.
+type TextLayout struct {
+	Align  string `json:"align" xml:"align"`   // "left", "center" (default) or "right"
+	Anchor string `json:"anchor" xml:"anchor"` // where the title and message sit: "top" (default), "middle" or "bottom"
+}
+
+// validate checks the alignment and anchor names
+func (t *TextLayout) validate() error {
+	switch t.Align {
+	case "", "left", "center", "right":
+	default:
+		return fmt.Errorf("invalid text align '%s' (expected left, center or right)", t.Align)
+	}
+	switch t.Anchor {
+	case "", "top", "middle", "bottom":
+	default:
+		return fmt.Errorf("invalid text anchor '%s' (expected top, middle or bottom)", t.Anchor)
+	}
+	return nil
+}
+
+// Overlay is a small badge drawn over the video so viewers can tell the screen is live
+// and not a stale screenshot
+type Overlay struct {
+	Content  string `json:"content" xml:"content"`   // "live", "clock" or "live_clock"
+	Position string `json:"position" xml:"position"` // "top-right" (default), "top-left", "bottom-right" or "bottom-left"
+}
+
+// validate checks the overlay content and position names
+func (o *Overlay) validate() error {
+	switch o.Content {
+	case "live", "clock", "live_clock":
+	default:
+		return fmt.Errorf("invalid overlay content '%s' (expected live, clock or live_clock)", o.Content)
+	}
+	switch o.Position {
+	case "", "top-right", "top-left", "bottom-right", "bottom-left":
+	default:
+		return fmt.Errorf("invalid overlay position '%s' (expected top-right, top-left, bottom-right or bottom-left)", o.Position)
+	}
+	return nil
+}
+
+// overlayFilter builds the FFmpeg drawtext filter for a notification's overlay ("" = none).
+// The clock adds the video's timestamp to the start time, so it shows the real time as
+// long as the cast started on schedule. A pinned status replays its clip, so it only
+// gets the LIVE badge.
+func overlayFilter(notif Notification) string {
+	overlay := notif.Overlay
+	if overlay == nil {
+		return ""
+	}
+
+	// Times are shown in Eastern time like the image; gmtime of a shifted epoch avoids
+	// depending on the container's time zone
+	estLocation, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		estLocation = time.UTC
+	}
+	_, offset := notif.StartTime.In(estLocation).Zone()
+	clock := fmt.Sprintf(`%%{pts\:gmtime\:%d\:%s}`, notif.StartTime.Unix()+int64(offset), strings.ReplaceAll(clockFormat, ":", `\\:`))
+
+	content := overlay.Content
+	if notif.Pinned {
+		content = "live"
+	}
+	var text string
+	switch content {
+	case "live":
+		text = "LIVE"
+	case "clock":
+		text = clock
+	default:
+		text = "LIVE  " + clock
+	}
+
+	const margin = 24
+	x, y := fmt.Sprintf("w-tw-%d", margin), fmt.Sprintf("%d", margin)
+	if strings.HasSuffix(overlay.Position, "left") {
+		x = fmt.Sprintf("%d", margin)
+	}
+	if strings.HasPrefix(overlay.Position, "bottom") {
+		y = fmt.Sprintf("h-th-%d", margin)
+	}
+
+	return fmt.Sprintf("drawtext=fontfile=/usr/share/fonts/dejavu/DejaVuSans-Bold.ttf:text='%s':fontsize=32:fontcolor=white:box=1:boxcolor=red@0.8:boxborderw=10:x=%s:y=%s",
+		text, x, y)
+}
+
+// textPlacement is where the text of one image is drawn: every line is drawn at X,
+// anchored horizontally by AnchorX (0 = left edge, 0.5 = centered, 1 = right edge)
+type textPlacement struct {
+	X, AnchorX  float64
+	TitleY      float64
+	MessageY    float64 // first message line
+	LineSpacing float64
+	TimeY       float64
+}
+
+// place computes the text positions for a message of lineCount lines. The time line
+// always stays at the bottom; the anchor moves the title and message block.
+func (l imageLayout) place(text *TextLayout, lineCount int) textPlacement {
+	if text == nil {
+		text = &TextLayout{}
+	}
+	w, h := float64(l.Width), float64(l.Height)
+
+	p := textPlacement{
+		X:           w / 2,
+		AnchorX:     0.5,
+		LineSpacing: l.MessageSize * layoutLineSpacing,
+		TimeY:       h * (1 - layoutTimeBottom),
+	}
+	switch text.Align {
+	case "left":
+		p.X, p.AnchorX = w*layoutMarginX, 0
+	case "right":
+		p.X, p.AnchorX = w*(1-layoutMarginX), 1
+	}
+
+	// Baseline distance from the title to the last message line
+	top := h * layoutTitleTop
+	blockHeight := h*layoutTitleGap + float64(max(lineCount-1, 0))*p.LineSpacing
+	switch text.Anchor {
+	case "middle":
+		// Center the block (from the top of the title to the last line) on the canvas
+		p.TitleY = max((h+l.TitleSize-blockHeight)/2, top)
+	case "bottom":
+		// End the block a couple of time lines above the time
+		p.TitleY = max(p.TimeY-2*l.TimeSize-blockHeight, top)
+	default:
+		p.TitleY = top
+	}
+	p.MessageY = p.TitleY + h*layoutTitleGap
+	return p
+}
+
+// layoutFor returns the layout for an orientation. Portrait lines are narrower, so
+// smaller fonts are used and a couple more message lines fit before truncating.
+func layoutFor(orientation string) imageLayout {
+	if orientation == orientationPortrait {
+		return imageLayout{
+			Width: imageHeight, Height: imageWidth,
+			TitleSize: 60, MessageSize: 52, TimeSize: 40,
+			LineWidth: 22, MaxLines: messageMaxLines + 2,
+		}
+	}
+	return imageLayout{
+		Width: imageWidth, Height: imageHeight,
+		TitleSize: 80, MessageSize: 64, TimeSize: 48,
+		LineWidth: messageLineWidth, MaxLines: messageMaxLines,
+	}
+}
+
+// accentBandHeight is the height of the device accent band, in pixels
+const accentBandHeight = 16
 
 // generateNotificationImageSimple creates a simpler PNG image with message and times
-func generateNotificationImageSimple(message string, notificationID string, startTime, endTime time.Time) (string, error) {
+// A zero endTime (pinned status) shows "Since <start>" instead of a time range
+// The text is placed according to textLayout (nil = centered, anchored at the top).
+// A non-empty accent (hex color) draws a band along the top edge, and a qr code is
+// drawn in its corner. A scroll image keeps every message line, growing taller than
+// the output (see scroll.go).
+func generateNotificationImageSimple(message string, notificationID string, startTime, endTime time.Time, bg *Background, theme string, orientation string, textLayout *TextLayout, accent string, qr *QRCode, scroll bool) (string, error) {
     // Create images directory if it doesn't exist
-    imagesDir := "/data/images"
     if err := os.MkdirAll(imagesDir, 0755); err != nil {
         return "", fmt.Errorf("failed to create images directory: %w", err)
     }
 
-    // Image dimensions (New Resolution: 1280x800)
-    width := 1280
-    height := 800
+    // Image dimensions (1280x800, or 800x1280 in portrait)
+    layout := layoutFor(orientation)
+    width := layout.Width
+    height := layout.Height
+
+    // A QR code takes a corner, so the message wraps narrower to stay clear of it
+    if qr != nil {
+        layout.LineWidth = int(float64(layout.LineWidth) * (1 - qrSizeRatio*float64(min(width, height))/float64(width)))
+    }
+
+    // Split message into lines for better display (see markup.go); overflow is cut
+    // with an ellipsis (the spoken TTS text always keeps the full message), unless the
+    // image scrolls: then the canvas grows by the extra lines, pushing the time down
+    paragraphs := parseMessageMarkup(message)
+    lines := wrapRichText(paragraphs, layout.LineWidth)
+    extraHeight := 0
+    if scroll {
+        extraHeight = int(float64(max(len(lines)-layout.MaxLines, 0)) * layout.MessageSize * layoutLineSpacing)
+    } else {
+        lines = truncateRichLines(lines, layout.MaxLines, layout.LineWidth)
+    }
+    place := layout.place(textLayout, len(lines))
+    place.TimeY += float64(extraHeight)
+    height += extraHeight
 
     // Create a new image with gradient
     dc := gg.NewContext(width, height)
 
-    // Draw gradient background
-    gradient := gg.NewLinearGradient(0, 0, float64(width), float64(height))
-    gradient.AddColorStop(0, color.RGBA{102, 126, 234, 255}) // #667eea
-    gradient.AddColorStop(1, color.RGBA{118, 75, 162, 255})  // #764ba2
-    dc.SetFillStyle(gradient)
-    dc.DrawRectangle(0, 0, float64(width), float64(height))
-    dc.Fill()
+    // Draw gradient background (a theme, see theme.go, also picks the text color)
+    bg, textColor := themeLook(bg, theme)
+    drawBackground(dc, bg, width, height)
+
+    // Device accent band, so the screen a message belongs to is recognizable at a glance
+    if accent != "" {
+        if accentColor, err := parseHexColor(accent); err == nil {
+            dc.SetColor(accentColor)
+            dc.DrawRectangle(0, 0, float64(width), accentBandHeight)
+            dc.Fill()
+        }
+    }
 
     // Load a font for the Title
-    if err := dc.LoadFontFace("/usr/share/fonts/dejavu/DejaVuSans-Bold.ttf", 80); err != nil {
+    if err := dc.LoadFontFace("/usr/share/fonts/dejavu/DejaVuSans-Bold.ttf", layout.TitleSize); err != nil {
         log.Printf("Warning: Could not load font, text may not display correctly: %v", err)
     }
     
-    dc.SetColor(color.White)
-
-    // Convert UTC times to EST
-    estLocation, err := time.LoadLocation("America/New_York")
-    if err != nil {
-        log.Printf("Warning: Could not load EST timezone, using UTC: %v", err)
-        estLocation = time.UTC
-    }
-    startTimeEST := startTime.In(estLocation)
-    endTimeEST := endTime.In(estLocation)
+    dc.SetColor(textColor)
 
-    // Format times in EST
-    timeFormat := "3:04 PM MST"
-    startStr := startTimeEST.Format(timeFormat)
-    endStr := endTimeEST.Format(timeFormat)
+    // Format times in EST/EDT
+    startStr := imageTimeLabel(startTime)
+    endStr := imageTimeLabel(endTime)
     
     // Title
     title := "MEETING IN PROGRESS"
-    titleWidth, _ := dc.MeasureString(title)
-    // New Title Position: Moved slightly down from 200 to 180 (closer to the top)
-    dc.DrawString(title, float64(width)/2-titleWidth/2, 180)
+    dc.DrawStringAnchored(title, place.X, place.TitleY, place.AnchorX, 0)
 
-    // Message font
-    if err := dc.LoadFontFace("/usr/share/fonts/dejavu/DejaVuSans-Bold.ttf", 64); err != nil {
+    // Message fonts: bold markup stands out against regular text; without any,
+    // the whole message is bold
+    boldFace, err := gg.LoadFontFace("/usr/share/fonts/dejavu/DejaVuSans-Bold.ttf", layout.MessageSize)
+    if err != nil {
         log.Printf("Warning: Could not load font for message: %v", err)
     }
-    
-    // Split message into lines for better display
-    lines := wrapText(message, 30)
-    maxLines := 5
-    if len(lines) > maxLines {
-        lines = lines[:maxLines]
+    plainFace := boldFace
+    if hasBoldMarkup(paragraphs) {
+        if plainFace, err = gg.LoadFontFace("/usr/share/fonts/dejavu/DejaVuSans.ttf", layout.MessageSize); err != nil {
+            log.Printf("Warning: Could not load regular font for message: %v", err)
+            plainFace = boldFace
+        }
+    }
+    faceFor := func(run textRun) {
+        face := plainFace
+        if run.Bold {
+            face = boldFace
+        }
+        if face != nil {
+            dc.SetFontFace(face)
+        }
     }
 
-    // Draw message lines centered
-    messageY := 350.0 
-    lineSpacing := 85.0 
-    
+    // Draw message lines with the chosen alignment, run by run
     for i, line := range lines {
-        lineWidth, _ := dc.MeasureString(line)
-        dc.DrawString(line, float64(width)/2-lineWidth/2, messageY+float64(i)*lineSpacing)
+        lineWidth := 0.0
+        for _, run := range line {
+            faceFor(run)
+            w, _ := dc.MeasureString(run.Text)
+            lineWidth += w
+        }
+        x := place.X - place.AnchorX*lineWidth
+        y := place.MessageY + float64(i)*place.LineSpacing
+        for _, run := range line {
+            faceFor(run)
+            dc.DrawString(run.Text, x, y)
+            w, _ := dc.MeasureString(run.Text)
+            x += w
+        }
     }
 
     // Time information font
-    if err := dc.LoadFontFace("/usr/share/fonts/dejavu/DejaVuSans.ttf", 48); err != nil {
+    if err := dc.LoadFontFace("/usr/share/fonts/dejavu/DejaVuSans.ttf", layout.TimeSize); err != nil {
         log.Printf("Warning: Could not load font for time: %v", err)
     }
     
     timeInfo := fmt.Sprintf("%s - %s", startStr, endStr)
-    timeWidth, _ := dc.MeasureString(timeInfo)
-    dc.DrawString(timeInfo, float64(width)/2-timeWidth/2, float64(height)-80) 
+    if endTime.IsZero() {
+        timeInfo = fmt.Sprintf("Since %s", startStr)
+    }
+    dc.DrawStringAnchored(timeInfo, place.X, place.TimeY, place.AnchorX, 0)
+
+    if qr != nil {
+        if err := drawQRCode(dc, qr, width, height); err != nil {
+            log.Printf("Warning: Could not draw the QR code: %v", err)
+        }
+    }
 
     // Save image
     imagePath := filepath.Join(imagesDir, fmt.Sprintf("%s.png", notificationID))
@@ -132,53 +731,75 @@ func generateNotificationImageSimple(message string, notificationID string, star
     return imagePath, nil
 }
 
-// generateTTSAudio creates audio from text using Google Cloud Text-to-Speech
-func generateTTSAudio(text string, notificationID string, repeatCount int) (string, error) {
-	audioDir := "/data/audio"
+// speechSegment is a piece of spoken text and the voice reading it (empty = default voice)
+type speechSegment struct {
+	Text  string
+	Voice string
+}
+
+// greetingVoice, when set, reads the fixed greeting in a different voice than the
+// user's message (GREETING_VOICE, e.g. "en-US-Chirp-HD-D"; empty = one voice for both)
+var greetingVoice = envString("GREETING_VOICE", "")
+
+// ttsCacheDir keeps synthesized segments of multi-voice audio, keyed by voice, format and text
+const ttsCacheDir = "/data/audio/cache"
+
+// maxRepeatCount caps repeat_count (MAX_REPEAT_COUNT): every repeat lengthens the
+// concatenated audio and the FFmpeg run that builds it
+var maxRepeatCount = max(envInt("MAX_REPEAT_COUNT", 10), 1)
+
+// repeatWarnThreshold is the repeat count above which generation logs the expected audio length
+const repeatWarnThreshold = 5
+
+// probeDuration returns the length of an audio or video file
+func probeDuration(path string) (time.Duration, error) {
+	out, err := exec.Command("ffprobe", "-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected ffprobe duration '%s'", strings.TrimSpace(string(out)))
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// generateTTSAudio creates audio from text with the TTS provider. Several
+// segments (e.g. greeting and message in different voices) are synthesized and cached
+// separately, then joined into one instance before repeating.
+func generateTTSAudio(segments []speechSegment, notificationID string, repeatCount int) (string, error) {
 	if err := os.MkdirAll(audioDir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create audio directory: %w", err)
 	}
 
-	singleAudioPath := filepath.Join(audioDir, fmt.Sprintf("%s_single.mp3", notificationID))
-	
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	
-	// Create Google Cloud TTS client
-	client, err := texttospeech.NewClient(ctx)
-	if err != nil {
-		return "", fmt.Errorf("failed to create TTS client: %w", err)
-	}
-	defer client.Close()
-
-	// Build the TTS request
-	req := &texttospeechpb.SynthesizeSpeechRequest{
-		Input: &texttospeechpb.SynthesisInput{
-			InputSource: &texttospeechpb.SynthesisInput_Text{Text: text},
-		},
-		Voice: &texttospeechpb.VoiceSelectionParams{
-			LanguageCode: "en-US",
-			Name:         "en-US-Chirp-HD-F", // High quality female Chirp HD voice
-			SsmlGender:   texttospeechpb.SsmlVoiceGender_FEMALE,
-		},
-		AudioConfig: &texttospeechpb.AudioConfig{
-			AudioEncoding:   texttospeechpb.AudioEncoding_MP3,
-			SpeakingRate:    1.0,   // Normal speed
-			Pitch:           0.0,   // Normal pitch
-			SampleRateHertz: 16000, // 16kHz - lower quality, faster generation
-		},
-	}
-
-	// Perform the TTS request
-	resp, err := client.SynthesizeSpeech(ctx, req)
-	if err != nil {
-		return "", fmt.Errorf("failed to synthesize speech: %w", err)
+	singleAudioPath := filepath.Join(audioDir, fmt.Sprintf("%s_single%s", notificationID, ttsAudio.Extension))
+
+	if len(segments) == 1 {
+		if err := synthesizeSpeech(segments[0], singleAudioPath); err != nil {
+			return "", err
+		}
+	} else {
+		var segmentPaths []string
+		for _, segment := range segments {
+			segmentPath, err := cachedSpeech(segment)
+			if err != nil {
+				return "", err
+			}
+			segmentPaths = append(segmentPaths, segmentPath)
+		}
+		// Same encoding and sample rate for every voice, so the segments can be stream-copied
+		if err := concatAudioFiles(segmentPaths, singleAudioPath); err != nil {
+			return "", err
+		}
 	}
 
-	// Write the audio content to file
-	if err := os.WriteFile(singleAudioPath, resp.AudioContent, 0644); err != nil {
-		return "", fmt.Errorf("failed to write audio file: %w", err)
+	// Speed up and normalize the single instance before it is repeated; the silence
+	// padding the video follows the (shorter) speech, so the cast length is unchanged
+	if err := processSpeechAudio(singleAudioPath); err != nil {
+		log.Printf("Warning: Failed to process the speech audio for notification %s, using it as synthesized: %v", notificationID, err)
 	}
 
 	// If repeatCount is 1, return the single audio
@@ -186,117 +807,384 @@ func generateTTSAudio(text string, notificationID string, repeatCount int) (stri
 		return singleAudioPath, nil
 	}
 
+	warnLongRepeat(singleAudioPath, notificationID, repeatCount)
+	return repeatAudio(singleAudioPath, notificationID, repeatCount), nil
+}
+
+// warnLongRepeat logs the expected audio length when the TTS is repeated many times
+func warnLongRepeat(singleAudioPath, notificationID string, repeatCount int) {
+	if repeatCount <= repeatWarnThreshold {
+		return
+	}
+	if single, err := probeDuration(singleAudioPath); err == nil {
+		log.Printf("Warning: Repeating the TTS audio %d times for notification %s (about %s of audio, %s per repeat)",
+			repeatCount, notificationID, (single * time.Duration(repeatCount)).Round(time.Second), single.Round(100*time.Millisecond))
+	} else {
+		log.Printf("Warning: Repeating the TTS audio %d times for notification %s", repeatCount, notificationID)
+	}
+}
+
+// repeatAudio writes the single TTS instance repeatCount times into one file (the first
+// pass of the two-pass pipeline) and returns its path, or the single audio on failure
+func repeatAudio(singleAudioPath, notificationID string, repeatCount int) string {
 	// Create repeated audio by concatenating multiple copies
-	finalAudioPath := filepath.Join(audioDir, fmt.Sprintf("%s.mp3", notificationID))
-	
+	finalAudioPath := filepath.Join(filepath.Dir(singleAudioPath), fmt.Sprintf("%s%s", notificationID, ttsAudio.Extension))
+
+	// Every copy is the same file, so the concat demuxer can stream-copy them
+	// instead of re-decoding each input through the concat filter
+	method := audioConcatMethod
+	if method != "auto" && method != "demuxer" && method != "filter" {
+		log.Printf("Warning: Unknown AUDIO_CONCAT_METHOD '%s', using auto", method)
+		method = "auto"
+	}
+
+	if method != "filter" {
+		err := concatAudioDemuxer(singleAudioPath, repeatCount, finalAudioPath)
+		if err == nil {
+			return finalAudioPath
+		}
+		if method == "demuxer" {
+			// If concat fails, just use the single audio
+			log.Printf("Warning: Failed to concatenate audio, using single instance: %v", err)
+			return singleAudioPath
+		}
+		log.Printf("Warning: Concat demuxer failed, falling back to concat filter: %v", err)
+	}
+
+	if err := concatAudioFilter(singleAudioPath, repeatCount, finalAudioPath); err != nil {
+		// If concat fails, just use the single audio
+		log.Printf("Warning: Failed to concatenate audio, using single instance: %v", err)
+		return singleAudioPath
+	}
+
+	return finalAudioPath
+}
+
+// concatAudioDemuxer repeats an audio file using the concat demuxer with stream copy (no re-encoding)
+func concatAudioDemuxer(inputPath string, repeatCount int, outputPath string) error {
+	inputs := make([]string, repeatCount)
+	for i := range inputs {
+		inputs[i] = inputPath
+	}
+	return concatAudioFiles(inputs, outputPath)
+}
+
+// concatAudioFiles joins audio files of the same format with the concat demuxer (stream copy)
+func concatAudioFiles(inputPaths []string, outputPath string) error {
+	var list strings.Builder
+	for _, inputPath := range inputPaths {
+		absPath, err := filepath.Abs(inputPath)
+		if err != nil {
+			return fmt.Errorf("failed to resolve audio path: %w", err)
+		}
+		fmt.Fprintf(&list, "file '%s'\n", absPath)
+	}
+
+	listPath := outputPath + ".txt"
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write concat list: %w", err)
+	}
+	defer os.Remove(listPath)
+
+	concatCmd := exec.Command("ffmpeg", "-y", "-f", "concat", "-safe", "0", "-i", listPath, "-c", "copy", outputPath)
+	concatCmd.Stderr = os.Stderr
+	if err := concatCmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg concat demuxer failed: %w", err)
+	}
+	return nil
+}
+
+// concatAudioFilter repeats an audio file using the concat filter (decodes every copy)
+func concatAudioFilter(inputPath string, repeatCount int, outputPath string) error {
 	// Build ffmpeg command to concatenate audio files
 	var inputs []string
 	for i := 0; i < repeatCount; i++ {
-		inputs = append(inputs, "-i", singleAudioPath)
+		inputs = append(inputs, "-i", inputPath)
 	}
 	
 	// Build filter complex for concatenation
 	filterComplex := fmt.Sprintf("concat=n=%d:v=0:a=1[out]", repeatCount)
 	
 	args := append([]string{"-y"}, inputs...)
-	args = append(args, "-filter_complex", filterComplex, "-map", "[out]", finalAudioPath)
+	args = append(args, "-filter_complex", filterComplex, "-map", "[out]", "-c:a", ttsAudio.FFmpegEncoder, outputPath)
 	
 	concatCmd := exec.Command("ffmpeg", args...)
 	concatCmd.Stderr = os.Stderr
 	if err := concatCmd.Run(); err != nil {
-		// If concat fails, just use the single audio
-		log.Printf("Warning: Failed to concatenate audio, using single instance: %v", err)
-		return singleAudioPath, nil
+		return fmt.Errorf("ffmpeg concat filter failed: %w", err)
 	}
+	return nil
+}
 
-	return finalAudioPath, nil
+// audioCue is a short clip mixed over the main audio at a fixed offset
+type audioCue struct {
+	Path          string
+	OffsetSeconds int
 }
 
-// generateNotificationVideo creates an HLS playlist (.m3u8) from the PNG image with audio
+// audioChimes are optional sound files played right before and after the speech, and
+// the optional generated attention beep played first
+type audioChimes struct {
+	Beep   bool
+	Before string
+	After  string
+}
+
+var (
+	// chimesDir holds the chime files notifications can pick by name
+	chimesDir = envString("CHIMES_DIR", "/data/chimes")
+	// Default chime paths, used when a notification doesn't choose its own
+	defaultChimeBefore = envString("CHIME_BEFORE", "")
+	defaultChimeAfter  = envString("CHIME_AFTER", "")
+)
+
+// The attention beep is a sine tone generated by FFmpeg ahead of the speech (and of
+// the chime before it), a lighter alternative to a chime file (ATTENTION_BEEP)
+var (
+	attentionBeep          = envBool("ATTENTION_BEEP", false)
+	attentionBeepFrequency = envInt("ATTENTION_BEEP_FREQUENCY", 880) // Hz
+	attentionBeepDuration  = envDuration("ATTENTION_BEEP_DURATION", 300*time.Millisecond)
+)
+
+// beepSource is the lavfi source of the attention beep, generated at the TTS track's
+// 16kHz so the concat filter accepts it without resampling
+func beepSource() string {
+	return fmt.Sprintf("sine=frequency=%d:sample_rate=16000:duration=%g", attentionBeepFrequency, attentionBeepDuration.Seconds())
+}
+
+// beepFilter makes the beep mono and fades its edges so it doesn't click
+func beepFilter() string {
+	fade := min(0.02, attentionBeepDuration.Seconds()/4)
+	return fmt.Sprintf("aformat=channel_layouts=mono,afade=t=in:d=%g,afade=t=out:st=%g:d=%g",
+		fade, attentionBeepDuration.Seconds()-fade, fade)
+}
+
+// attentionBeepSignature identifies the beep settings for the media hash ("" = no beep)
+func attentionBeepSignature() string {
+	if !attentionBeep {
+		return ""
+	}
+	return fmt.Sprintf("%dHz/%s", attentionBeepFrequency, attentionBeepDuration)
+}
+
+// chimeFilter converts a chime to the TTS track's format so the concat filter accepts it
+const chimeFilter = "aresample=16000,aformat=channel_layouts=mono"
+
+// resolveChime maps a notification's chime choice to a file path: empty uses the global
+// default, "none" disables the chime, anything else is a file name in CHIMES_DIR
+func resolveChime(choice, defaultPath string) (string, error) {
+	switch choice {
+	case "":
+		return defaultPath, nil
+	case "none":
+		return "", nil
+	}
+
+	if filepath.Base(choice) != choice || strings.HasPrefix(choice, ".") {
+		return "", fmt.Errorf("invalid chime '%s'", choice)
+	}
+	chimePath := filepath.Join(chimesDir, choice)
+	if _, err := os.Stat(chimePath); err != nil {
+		return "", fmt.Errorf("chime '%s' not found in %s", choice, chimesDir)
+	}
+	return chimePath, nil
+}
+
+// generateNotificationVideo creates an HLS playlist (.m3u8) from the PNG image(s) with audio
 // Chromecast works best with HLS format instead of direct MP4
-func generateNotificationVideo(imagePath string, notificationID string, durationSeconds int, audioPath string) (string, error) {
+// When several images are given they are shown in turn, each for slideInterval seconds
+// An optional cue (e.g. "ending soon") is mixed over the audio without interrupting it,
+// and optional chimes are played right before and after the speech
+// audioRepeat plays the audio that many times in a row (the single-pass pipeline: the
+// repeat, silence padding and muxing happen in this one FFmpeg command)
+// A clip (an uploaded, already transcoded video) is looped instead of the images.
+// The output is 1280x800, or 800x1280 for the portrait orientation. overlay is an
+// optional drawtext filter (see overlayFilter) applied to every frame, and scroll an
+// optional crop filter (see scrollFilter) panning over a single tall image. Cancelling
+// ctx kills FFmpeg.
+func generateNotificationVideo(ctx context.Context, imagePaths []string, slideInterval int, notificationID string, duration time.Duration, audioPath string, audioRepeat int, cue *audioCue, chimes audioChimes, clipPath string, orientation string, overlay string, scroll string) (string, error) {
+	if len(imagePaths) == 0 && clipPath == "" {
+		return "", fmt.Errorf("no images to build video from")
+	}
+
 	// Create chunks directory for this notification (to match server.Start expectations)
-	videosDir := filepath.Join("./data/chunks", notificationID)
+	videosDir := filepath.Join(chunksDir, notificationID)
 	if err := os.MkdirAll(videosDir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create chunks directory: %w", err)
 	}
 
 	// Output HLS master playlist path (this will be the main entry point)
 	masterPlaylistPath := filepath.Join(videosDir, "playlist.m3u8")
+
+	// Inputs and the output are cut at the exact duration (millisecond precision); the
+	// slideshow list and single-segment mode only need whole seconds covering it
+	exactDuration := ffmpegDuration(duration)
+	durationSeconds := int(math.Ceil(duration.Seconds()))
 	
-	// Media playlist and segment output pattern
-	// The master playlist will reference this media playlist (no extension, like in example)
-	segmentPattern := filepath.Join(videosDir, "%d.ts")
+	// Uploaded slides can be any size, so fit everything to the output resolution
+	layout := layoutFor(orientation)
+	fitFilter := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2",
+		layout.Width, layout.Height, layout.Width, layout.Height)
+	if overlay != "" {
+		overlay = "," + overlay
+	}
+	stillFPS := stillFrameRate(duration)
+	videoFilter := fitFilter + fmt.Sprintf(",fps=%d", stillFPS) + overlay
+	videoCodec := []string{
+		"-vf", videoFilter, // fit image(s) to output size
+		"-preset", "ultrafast", // fastest encoding
+		"-c:v", "libx264", // use H.264 codec
+		"-b:v", "512k", // video bitrate
+		"-profile:v", "baseline", // quality settings
+		"-crf", "28", // constant rate factor
+		"-pix_fmt", "yuv420p", // pixel format for maximum compatibility
+	}
+
+	// Video input: a looped clip, a single looped image, or a concat list cycling through the slides
+	var videoInput []string
+	switch {
+	case clipPath != "":
+		// Clips are transcoded to the landscape HLS profile on upload, so looping them is a
+		// stream copy; portrait output or an overlay re-encodes the clip
+		videoInput = []string{
+			"-stream_loop", "-1", // loop the clip
+			"-t", exactDuration, // duration in seconds
+			"-i", clipPath, // input clip
+		}
+		if orientation == orientationPortrait || overlay != "" {
+			videoCodec[1] = fmt.Sprintf("%s,fps=%d%s", fitFilter, clipFrameRate, overlay) // the "-vf" value
+		} else {
+			videoCodec = []string{"-c:v", "copy"}
+		}
+	case len(imagePaths) == 1:
+		frameRate := stillFPS // static image doesn't need high framerate
+		if scroll != "" {
+			// Panning needs smooth motion: crop first, so the fit is a no-op
+			frameRate = scrollFrameRate
+			videoCodec[1] = fmt.Sprintf("%s,%s,fps=%d%s", scroll, fitFilter, scrollFrameRate, overlay) // the "-vf" value
+		}
+		videoInput = []string{
+			"-loop", "1", // loop the input image
+			"-framerate", fmt.Sprintf("%d", frameRate), // input frame rate
+			"-t", exactDuration, // duration in seconds
+			"-i", imagePaths[0], // input image
+		}
+	default:
+		listPath, err := writeSlideshowList(videosDir, imagePaths, slideInterval, durationSeconds)
+		if err != nil {
+			return "", err
+		}
+		videoInput = []string{
+			"-f", "concat", // read the slides from a concat list
+			"-safe", "0", // list uses absolute paths
+			"-t", exactDuration, // duration in seconds
+			"-i", listPath, // input slide list
+		}
+	}
+
+	// Keyframes exactly on the segment boundaries, so every segment lasts HLS_SEGMENT_SECONDS
+	// and the segments add up to the duration (x264 would otherwise place them every
+	// 250 frames, i.e. minutes apart at 1 fps); a copied clip keeps its own keyframes
+	if videoCodec[0] == "-vf" && hlsSegmentMode != hlsModeSingle {
+		videoCodec = append(videoCodec, "-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", hlsSegmentSeconds))
+	}
 
 	// Use ffmpeg to create HLS format video from the image
 	// Based on gochromecast example ffmpeg settings for Chromecast compatibility
 	// Creates a master playlist that references a media playlist with segments
-	var cmd *exec.Cmd
+	args := append([]string{"-y"}, videoInput...) // overwrite output file if it exists
 	
 	if audioPath != "" {
+		// Repeat the speech at the input: it is decoded once per copy in this same process,
+		// instead of writing a repeated file first and decoding that again
+		if audioRepeat > 1 {
+			args = append(args, "-stream_loop", fmt.Sprintf("%d", audioRepeat-1))
+		}
+
 		// With audio: use anullsrc to generate silence efficiently after audio ends
 		// This prevents Chromecast from stopping when audio ends
 		// anullsrc generates silence much faster than apad
-		cmd = exec.Command("ffmpeg",
-			"-y", // overwrite output file if it exists
-			"-loop", "1", // loop the input image
-			"-framerate", "1", // 1 fps (static image doesn't need high framerate)
-			"-t", fmt.Sprintf("%d", durationSeconds), // duration in seconds
-			"-i", imagePath, // input image
-			"-i", audioPath, // input audio (already repeated as needed)
+		args = append(args,
+			"-i", audioPath, // input audio
 			"-f", "lavfi", // use lavfi for generating silence
-			"-t", fmt.Sprintf("%d", durationSeconds), // silence duration same as video
+			"-t", exactDuration, // silence duration same as video
 			"-i", "anullsrc=r=16000:cl=mono", // generate silence at 16kHz mono
-			"-filter_complex", "[1:a][2:a]concat=n=2:v=0:a=1[outa]", // concat TTS audio + silence
+		)
+
+		// Audio track: [beep] + [chime before] + TTS + [chime after] + silence
+		var filters []string
+		segments := "[1:a]"
+		nextInput := 3
+		if chimes.Beep {
+			args = append(args, "-f", "lavfi", "-i", beepSource())
+			filters = append(filters, fmt.Sprintf("[%d:a]%s[beep]", nextInput, beepFilter()))
+			nextInput++
+		}
+		if chimes.Before != "" {
+			args = append(args, "-i", chimes.Before)
+			filters = append(filters, fmt.Sprintf("[%d:a]%s[before]", nextInput, chimeFilter))
+			segments = "[before]" + segments
+			nextInput++
+		}
+		if chimes.After != "" {
+			args = append(args, "-i", chimes.After)
+			filters = append(filters, fmt.Sprintf("[%d:a]%s[after]", nextInput, chimeFilter))
+			segments += "[after]"
+			nextInput++
+		}
+		if chimes.Beep {
+			segments = "[beep]" + segments
+		}
+		segments += "[2:a]"
+		segmentCount := strings.Count(segments, "[")
+
+		if cue != nil {
+			// Delay the cue to its offset and mix it over the TTS + silence track
+			args = append(args, "-i", cue.Path)
+			filters = append(filters,
+				fmt.Sprintf("%sconcat=n=%d:v=0:a=1[main]", segments, segmentCount),
+				fmt.Sprintf("[%d:a]adelay=%d:all=1[cue]", nextInput, cue.OffsetSeconds*1000),
+				"[main][cue]amix=inputs=2:duration=first:normalize=0[outa]")
+		} else {
+			filters = append(filters, fmt.Sprintf("%sconcat=n=%d:v=0:a=1[outa]", segments, segmentCount))
+		}
+		audioFilter := strings.Join(filters, ";")
+		args = append(args,
+			"-filter_complex", audioFilter, // build the final audio track
 			"-map", "0:v", // map video from input 0 (image)
 			"-map", "[outa]", // map concatenated audio
-			"-preset", "ultrafast", // fastest encoding
-			"-c:v", "libx264", // use H.264 codec
+		)
+		args = append(args, videoCodec...)
+		args = append(args,
 			"-c:a", "aac", // audio codec
 			"-b:a", "64k", // audio bitrate
 			"-ar", "16000", // audio sample rate 16kHz
 			"-ac", "1", // 1 audio channel (mono)
-			"-b:v", "512k", // video bitrate
-			"-profile:v", "baseline", // quality settings
-			"-crf", "28", // constant rate factor
-			"-pix_fmt", "yuv420p", // pixel format for maximum compatibility
 			"-threads", "0", // use all CPUs
 			"-max_interleave_delta", "0", // fix interleaving warnings
-			"-f", "hls", // output format is HLS
-			"-hls_list_size", "0", // keep all segments
-			"-hls_time", "10", // segment duration (10 seconds)
-			"-hls_playlist_type", "event", // tell player this is an event
-			"-hls_flags", "independent_segments+append_list", // allow for streaming
-			"-hls_segment_filename", segmentPattern, // segment file naming pattern
-			"-master_pl_name", "playlist.m3u8", // create master playlist
-			filepath.Join(videosDir, "playlist"), // output media playlist (no extension)
 		)
 	} else {
 		// Without audio: optimized for speed
-		cmd = exec.Command("ffmpeg",
-			"-y", // overwrite output file if it exists
-			"-loop", "1", // loop the input image
-			"-framerate", "1", // 1 fps (static image doesn't need high framerate)
-			"-t", fmt.Sprintf("%d", durationSeconds), // duration in seconds
-			"-i", imagePath, // input image
-			"-preset", "ultrafast", // fastest encoding
-			"-c:v", "libx264", // use H.264 codec
-			"-b:v", "512k", // video bitrate (reduced from 1024k)
-			"-profile:v", "baseline", // quality settings (reduced from high)
-			"-crf", "28", // constant rate factor (increased from 22 = lower quality)
-			"-pix_fmt", "yuv420p", // pixel format for maximum compatibility
-			"-threads", "0", // use all CPUs
-			"-f", "hls", // output format is HLS
-			"-hls_list_size", "0", // keep all segments
-			"-hls_time", "10", // segment duration (10 seconds)
-			"-hls_playlist_type", "event", // tell player this is an event
-			"-hls_flags", "independent_segments+append_list", // allow for streaming
-			"-hls_segment_filename", segmentPattern, // segment file naming pattern
-			"-master_pl_name", "playlist.m3u8", // create master playlist
-			filepath.Join(videosDir, "playlist"), // output media playlist (no extension)
-		)
+		args = append(args, videoCodec...)
+		args = append(args, "-threads", "0") // use all CPUs
 	}
 
+	args = append(args,
+		"-t", exactDuration, // cut the output at the duration: the speech and chimes come on top of the silence, so the audio track alone would run longer
+		"-f", "hls", // output format is HLS
+		"-hls_list_size", "0", // keep all segments
+		"-hls_playlist_type", "event", // tell player this is an event
+		"-hls_flags", "independent_segments+append_list", // allow for streaming
+	)
+	// Segment length and format (HLS_SEGMENT_MODE); the master playlist references the
+	// media playlist (no extension, like in the gochromecast example)
+	args = append(args, hlsSegmentArgs(videosDir, durationSeconds)...)
+	args = append(args,
+		"-master_pl_name", "playlist.m3u8", // create master playlist
+		filepath.Join(videosDir, "playlist"), // output media playlist (no extension)
+	)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
 	// Capture stderr for error messages
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
@@ -315,6 +1203,489 @@ func generateNotificationVideo(imagePath string, notificationID string, duration
 	return masterPlaylistPath, nil
 }
 
+// fractionalFrameRate is the frame rate of images and slideshows whose duration has a
+// fraction of a second: at their usual 1 fps the last frame would run to the next whole
+// second, at this rate the video ends within a frame (100ms) of the duration
+const fractionalFrameRate = 10
+
+// stillFrameRate is the frame rate of an image or slideshow video lasting duration
+func stillFrameRate(duration time.Duration) int {
+	if duration%time.Second == 0 {
+		return 1
+	}
+	return fractionalFrameRate
+}
+
+// ffmpegDuration formats a duration for FFmpeg's -t, in seconds with millisecond precision
+func ffmpegDuration(d time.Duration) string {
+	return strconv.FormatFloat(d.Round(time.Millisecond).Seconds(), 'f', 3, 64)
+}
+
+// writeSlideshowList writes an ffmpeg concat list that cycles through the slides
+// until the whole duration is covered
+func writeSlideshowList(dir string, imagePaths []string, slideInterval int, durationSeconds int) (string, error) {
+	if slideInterval < 1 {
+		slideInterval = defaultSlideInterval
+	}
+
+	var list strings.Builder
+	var lastPath string
+	for elapsed, i := 0, 0; elapsed < durationSeconds; elapsed, i = elapsed+slideInterval, i+1 {
+		absPath, err := filepath.Abs(imagePaths[i%len(imagePaths)])
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve slide path: %w", err)
+		}
+		lastPath = absPath
+		fmt.Fprintf(&list, "file '%s'\nduration %d\n", lastPath, slideInterval)
+	}
+	// The concat demuxer ignores the duration of the final entry unless it is repeated
+	fmt.Fprintf(&list, "file '%s'\n", lastPath)
+
+	listPath := filepath.Join(dir, "slides.txt")
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write slideshow list: %w", err)
+	}
+	return listPath, nil
+}
+
+// resolveSlideImage maps a slideshow image ref to a file path.
+// "message" is the generated notification image; anything else is an uploaded image ID.
+func resolveSlideImage(ref string, generatedImagePath string) (string, error) {
+	if ref == messageSlideRef {
+		return generatedImagePath, nil
+	}
+
+	// Uploaded image IDs are plain file names, never paths
+	if ref == "" || filepath.Base(ref) != ref || strings.HasPrefix(ref, ".") {
+		return "", fmt.Errorf("invalid image reference '%s'", ref)
+	}
+	imagePath := filepath.Join(uploadsDir, ref)
+	if _, err := os.Stat(imagePath); err != nil {
+		return "", fmt.Errorf("image '%s' not found", ref)
+	}
+	return imagePath, nil
+}
+
+// mediaInputsHash fingerprints everything a notification's media is rendered from, so an
+// existing video can be reused only while it still matches the notification
+func mediaInputsHash(notif Notification) string {
+	inputs, _ := json.Marshal(struct {
+		Message           string
+		MessageURL        string
+		AgendaFile        string
+		Agenda            string
+		StartTime         time.Time
+		EndTime           time.Time
+		RepeatCount       int
+		Images            []string
+		SlideInterval     int
+		EndingSoonMinutes int
+		EndAction         string
+		EndScreenSeconds  int
+		Pinned            bool
+		Background        *Background
+		Theme             string
+		DefaultTheme      string
+		DeviceMessages    DeviceMessages
+		Voice             string
+		ChimeBefore       string
+		ChimeAfter        string
+		Clip              string
+		Orientation       string
+		TextLayout        *TextLayout
+		Overlay           *Overlay
+		QRCode            *QRCode
+		Scroll            bool
+		ScrollSeconds     int
+		Accent            string
+		BlockedWords      string
+		CastMode          string
+		Muted             bool
+		Speaker           bool
+		GreetingVoice     string
+		TimeFormat        string
+		TTSProvider       string
+		AttentionBeep     string
+		SpeakingRate      float64
+		Tempo             float64
+		Loudness          float64
+		AudioEncoding     string
+		HLS               string
+		FFmpeg            bool
+	}{
+		notif.Message, notif.MessageURL, notif.AgendaFile, agendaSignature(notif.AgendaFile), notif.StartTime.UTC(), notif.EndTime.UTC(), notif.RepeatCount,
+		notif.Images, notif.SlideInterval, notif.EndingSoonMinutes, notif.EndAction, notif.EndScreenSeconds,
+		notif.Pinned, notif.Background, notif.Theme, defaultTheme, notif.DeviceMessages, notif.Voice, notif.ChimeBefore, notif.ChimeAfter, notif.Clip,
+		notif.Orientation, notif.TextLayout, notif.Overlay, notif.QRCode, notif.Scroll, scrollSeconds, appInstance.accentColor(notif), blockedWordsPatternString(), notif.CastMode, notif.Muted, appInstance.isSpeakerDevice(notif.Device), greetingVoice, timeFormat, ttsProviderName, attentionBeepSignature(), ttsSpeakingRate, ttsTempo, speechLoudness, ttsAudio.Extension, hlsSegmentMode + "/" + strconv.Itoa(hlsSegmentSeconds), ffmpegAvailable,
+	})
+	sum := sha256.Sum256(inputs)
+	return hex.EncodeToString(sum[:])
+}
+
+// mediaUpToDate reports whether a notification's media exists and was generated from its
+// current inputs. Media generated before hashes were stored (empty hash) is trusted.
+func mediaUpToDate(notif Notification) bool {
+	if _, err := os.Stat(appInstance.notificationCastPath(notif)); err != nil {
+		return false
+	}
+	return notif.MediaHash == "" || notif.MediaHash == mediaInputsHash(notif)
+}
+
+// storeMediaHash records the inputs a notification's media was just generated from
+func storeMediaHash(notif Notification) {
+	if _, err := appInstance.DB.Exec("UPDATE notifications SET media_hash = ? WHERE id = ?", mediaInputsHash(notif), notif.ID); err != nil {
+		log.Printf("Warning: Failed to store media hash for notification %s: %v", notif.ID, err)
+	}
+}
+
+// notificationSpeech is the spoken greeting and message of a notification
+func notificationSpeech(notif Notification) []speechSegment {
+	// Convert end time to EST for TTS
+	estLocation, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		log.Printf("Warning: Could not load EST timezone for TTS, using UTC: %v", err)
+		estLocation = time.UTC
+	}
+	endTimeEST := notif.EndTime.In(estLocation)
+
+	// Generate TTS audio: "Michel is in the meeting until [end_time]"
+	greeting := fmt.Sprintf("Hi Dan, this message is to tell you that Michel is in a meeting until %s and he had this message for you:", endTimeEST.Format(speechTimeFormat))
+	if notif.Pinned {
+		greeting = "Hi Dan, this message is to tell you that Michel is busy and he had this message for you:"
+	}
+	// Markup is for the screen; the message is spoken as plain sentences
+	message := plainMessage(filterSpeech(notif.Message))
+	if greetingVoice != "" {
+		// Greeting and message in different voices, synthesized (and cached) separately
+		return []speechSegment{{Text: greeting, Voice: greetingVoice}, {Text: message, Voice: notif.Voice}}
+	}
+	return []speechSegment{{Text: greeting + " " + message, Voice: notif.Voice}}
+}
+
+// speechText is the text notificationSpeech sends to TTS, as one string (a greeting in
+// another voice is a separate request but is spoken right before the message).
+// It is built without synthesizing anything, so it can be previewed on create.
+func speechText(notif Notification) string {
+	if notif.Muted {
+		return ""
+	}
+	var parts []string
+	for _, segment := range notificationSpeech(notif) {
+		parts = append(parts, segment.Text)
+	}
+	return strings.Join(parts, " ")
+}
+
+// stagingSuffix names the directory a notification's media is rendered into before
+// publishMedia swaps it in for the current media
+const stagingSuffix = "_staging"
+
+// generateNotificationMedia renders the image, TTS audio and HLS video for a notification
+// (only the audio for speakers) and returns the path of the media to cast. The media is
+// rendered into a staging directory and only replaces the current media once complete.
+// Cancelling ctx kills the video's FFmpeg command and removes the partial output.
+func generateNotificationMedia(ctx context.Context, notif Notification) (string, error) {
+	// Refuse up front with a clear error instead of failing halfway through
+	if _, err := checkStorage(); err != nil {
+		return "", err
+	}
+
+	// Media derived from earlier inputs (copies scaled down for a device, see
+	// resolution.go, the variants of device_messages and the ended clip) is removed
+	// first, and so is an abandoned staging directory: the HLS muxer appends to an
+	// existing playlist rather than replacing it
+	scaledDirs, _ := filepath.Glob(filepath.Join(chunksDir, notif.ID+"_*x*"))
+	variantDirs, _ := filepath.Glob(filepath.Join(chunksDir, notif.ID+"_device-*"))
+	staleDirs := append([]string{filepath.Join(chunksDir, notif.ID+stagingSuffix), filepath.Join(chunksDir, notif.ID+"_ended")}, scaledDirs...)
+	staleDirs = append(staleDirs, variantDirs...)
+	for _, dir := range staleDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return "", fmt.Errorf("failed to clear previous media: %w", err)
+		}
+	}
+
+	// Devices with their own message get a variant generated alongside
+	if err := generateDeviceVariants(ctx, notif); err != nil {
+		return "", err
+	}
+
+	// The hash is stored for the notification as saved, not the fetched message or agenda
+	saved := notif
+	notif = withAgenda(withFetchedMessage(notif))
+
+	started := time.Now()
+	castPath, metrics, err := renderNotificationMedia(ctx, notif, notif.ID+stagingSuffix)
+	if err != nil {
+		if rmErr := os.RemoveAll(filepath.Join(chunksDir, notif.ID+stagingSuffix)); rmErr != nil {
+			log.Printf("Failed to remove the partial media of notification %s: %v", notif.ID, rmErr)
+		}
+		return "", err
+	}
+	if err := publishMedia(notif.ID); err != nil {
+		return "", err
+	}
+
+	metrics.TotalMs = time.Since(started).Milliseconds()
+	recordGenerationMetrics(notif, metrics)
+	storeMediaHash(saved)
+	return filepath.Join(chunksDir, notif.ID, filepath.Base(castPath)), nil
+}
+
+// renderNotificationMedia renders a notification's media into chunks/<castID> and returns
+// the path of the media to cast there, with the time each step took. It records nothing:
+// generateNotificationMedia publishes the result and stores its metrics and hash.
+func renderNotificationMedia(ctx context.Context, notif Notification, castID string) (string, GenerationMetrics, error) {
+	if appInstance.isSpeakerDevice(notif.Device) {
+		return generateSpeakerMedia(notif, castID)
+	}
+
+	// A pinned status has no real end time, so it gets a fixed-length clip that the
+	// scheduler replays (see refreshPinnedCasts) instead of a video for the whole window
+	imageEndTime := notif.EndTime
+	if notif.Pinned {
+		imageEndTime = time.Time{}
+	}
+
+	// Time each step so slow generations can be diagnosed (see recordGenerationMetrics)
+	var metrics GenerationMetrics
+	stepStarted := time.Now()
+
+	// Generate image first with times
+	imagePath, err := generateNotificationImageSimple(filterMessage(notif.Message), notif.ID, notif.StartTime, imageEndTime, notif.Background, notif.Theme, notif.Orientation, notif.TextLayout, appInstance.accentColor(notif), notif.QRCode, false)
+	if err != nil {
+		return "", metrics, fmt.Errorf("failed to generate image: %w", err)
+	}
+	metrics.ImageMs = time.Since(stepStarted).Milliseconds()
+
+	// Without FFmpeg, or in the image cast mode, there is no video or audio: the
+	// message image is cast as is
+	if !ffmpegAvailable || castsImage(notif) {
+		castPath, err := writeStaticCastImage(imagePath, castID)
+		return castPath, metrics, err
+	}
+
+	slides := []string{imagePath}
+	if len(notif.Images) > 0 {
+		slides = slides[:0]
+		for _, ref := range notif.Images {
+			slidePath, err := resolveSlideImage(ref, imagePath)
+			if err != nil {
+				return "", metrics, err
+			}
+			slides = append(slides, slidePath)
+		}
+	}
+
+	// Calculate video duration from start and end times, exactly: the video ends with
+	// the window rather than on a whole second or segment boundary
+	window := notif.EndTime.Sub(notif.StartTime)
+	if notif.Pinned {
+		window = statusLoopDuration
+	}
+	if window < time.Second {
+		window = 10 * time.Second
+	}
+	duration := int(window.Seconds()) // whole seconds, for the ending-soon cue and scrolling
+
+	// The single-pass pipeline leaves repeating the speech to the video's FFmpeg command
+	stepStarted = time.Now()
+	ttsRepeat, audioRepeat := notif.RepeatCount, 1
+	if audioPipeline == audioPipelineSingle {
+		ttsRepeat, audioRepeat = 1, notif.RepeatCount
+	}
+	// Muted notifications get no speech (and so no chimes or ending-soon cue either)
+	var audioPath string
+	if !notif.Muted {
+		audioPath, err = generateTTSAudio(notificationSpeech(notif), notif.ID, ttsRepeat)
+		if err != nil {
+			log.Printf("Failed to generate TTS audio for notification %s: %v (continuing without audio)", notif.ID, err)
+			audioPath = "" // Continue without audio if TTS fails
+		} else if audioRepeat > 1 {
+			warnLongRepeat(audioPath, notif.ID, audioRepeat)
+		}
+	}
+
+	// Optional "ending soon" announcement, mixed in ahead of the end time
+	var cue *audioCue
+	if audioPath != "" && notif.EndingSoonMinutes > 0 && !notif.Pinned {
+		offset := duration - notif.EndingSoonMinutes*60
+		if offset > 0 {
+			cueText := strings.ReplaceAll(endingSoonText, "{minutes}", fmt.Sprintf("%d", notif.EndingSoonMinutes))
+			cuePath, err := generateTTSAudio([]speechSegment{{Text: cueText, Voice: notif.Voice}}, notif.ID+"_ending", 1)
+			if err != nil {
+				log.Printf("Failed to generate ending-soon audio for notification %s: %v (continuing without it)", notif.ID, err)
+			} else {
+				cue = &audioCue{Path: cuePath, OffsetSeconds: offset}
+			}
+		} else {
+			log.Printf("Skipping ending-soon announcement for notification %s: shorter than %d minutes", notif.ID, notif.EndingSoonMinutes)
+		}
+	}
+
+	// Generate HLS video with audio
+	metrics.TTSMs = time.Since(stepStarted).Milliseconds()
+	if err := ctx.Err(); err != nil {
+		return "", metrics, fmt.Errorf("generation cancelled: %w", err)
+	}
+
+	// Attention beep and chimes around the speech (only when there is speech)
+	var chimes audioChimes
+	if audioPath != "" {
+		chimes.Beep = attentionBeep
+		if chimes.Before, err = resolveChime(notif.ChimeBefore, defaultChimeBefore); err != nil {
+			log.Printf("Skipping chime before speech for notification %s: %v", notif.ID, err)
+		}
+		if chimes.After, err = resolveChime(notif.ChimeAfter, defaultChimeAfter); err != nil {
+			log.Printf("Skipping chime after speech for notification %s: %v", notif.ID, err)
+		}
+	}
+
+	// An uploaded clip loops in place of the generated image
+	var clipPath string
+	if notif.Clip != "" {
+		if clipPath, err = resolveClip(notif.Clip); err != nil {
+			log.Printf("Falling back to the generated image for notification %s: %v", notif.ID, err)
+		}
+	}
+
+	// A scrolling message pans over a rendering of the whole text, when it doesn't fit
+	var scroll string
+	if notif.Scroll && len(notif.Images) == 0 && clipPath == "" {
+		layout := layoutFor(notif.Orientation)
+		overflows := false
+		scrollPath, err := generateNotificationImageSimple(filterMessage(notif.Message), notif.ID+"_scroll", notif.StartTime, imageEndTime, notif.Background, notif.Theme, notif.Orientation, notif.TextLayout, appInstance.accentColor(notif), notif.QRCode, true)
+		if err == nil {
+			overflows, err = scrollImageOverflows(scrollPath, layout.Height)
+		}
+		switch {
+		case err != nil:
+			log.Printf("Not scrolling the message of notification %s: %v", notif.ID, err)
+		case overflows:
+			slides = []string{scrollPath}
+			scroll = scrollFilter(duration, layout.Width, layout.Height)
+		}
+	}
+
+	stepStarted = time.Now()
+	playlistPath, err := generateNotificationVideo(ctx, slides, notif.SlideInterval, castID, window, audioPath, audioRepeat, cue, chimes, clipPath, notif.Orientation, overlayFilter(notif), scroll)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", metrics, fmt.Errorf("generation cancelled: %w", ctx.Err())
+		}
+		return "", metrics, fmt.Errorf("failed to generate video: %w", err)
+	}
+	metrics.VideoMs = time.Since(stepStarted).Milliseconds()
+
+	// Build the "meeting ended" clip now so it is ready when the cast ends
+	if notif.EndAction == endActionEndedScreen {
+		if _, err := generateEndedClip(notif); err != nil {
+			log.Printf("Failed to pre-generate ended screen for notification %s: %v", notif.ID, err)
+		}
+	}
+
+	return playlistPath, metrics, nil
+}
+
+// removeNotificationMedia removes a notification's cast media and everything derived from
+// it (device variants, downscaled copies, the ended clip); a restored notification's
+// media is generated again
+func removeNotificationMedia(notifID string) {
+	dirs, _ := filepath.Glob(filepath.Join(chunksDir, notifID+"_*"))
+	for _, dir := range append(dirs, filepath.Join(chunksDir, notifID)) {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Warning: Failed to remove media %s of notification %s: %v", dir, notifID, err)
+		}
+	}
+}
+
+// publishMedia swaps a notification's staged media in for its current media with two
+// renames, so the cast server never serves a half-written playlist or a mix of old and
+// new segments
+func publishMedia(notifID string) error {
+	current := filepath.Join(chunksDir, notifID)
+	previous := filepath.Join(chunksDir, notifID+"_previous")
+	if err := os.RemoveAll(previous); err != nil {
+		return fmt.Errorf("failed to clear previous media: %w", err)
+	}
+	if err := os.Rename(current, previous); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace previous media: %w", err)
+	}
+	if err := os.Rename(filepath.Join(chunksDir, notifID+stagingSuffix), current); err != nil {
+		os.Rename(previous, current)
+		return fmt.Errorf("failed to publish media: %w", err)
+	}
+	if err := os.RemoveAll(previous); err != nil {
+		log.Printf("Warning: Failed to remove the previous media of notification %s: %v", notifID, err)
+	}
+	return nil
+}
+
+// writeStaticCastImage copies a rendered image next to where the HLS output would go,
+// so the cast server can serve it when FFmpeg is unavailable. Returns the copy's path.
+func writeStaticCastImage(imagePath, id string) (string, error) {
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read image: %w", err)
+	}
+
+	castDir := filepath.Join(chunksDir, id)
+	if err := os.MkdirAll(castDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create chunks directory: %w", err)
+	}
+
+	castPath := filepath.Join(castDir, staticCastImage)
+	if err := os.WriteFile(castPath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write static cast image: %w", err)
+	}
+	return castPath, nil
+}
+
+// generateEndedClip builds the short "meeting ended" HLS clip cast when a notification
+// with the ended_screen action finishes. Returns the clip's ID under ./data/chunks.
+func generateEndedClip(notif Notification) (string, error) {
+	clipID := notif.ID + "_ended"
+	if _, err := os.Stat(castMediaPath(clipID, false, false)); err == nil {
+		return clipID, nil
+	}
+
+	if err := os.MkdirAll(imagesDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create images directory: %w", err)
+	}
+
+	layout := layoutFor(notif.Orientation)
+	dc := gg.NewContext(layout.Width, layout.Height)
+
+	// Same gradient background and text color as the notification image
+	bg, textColor := themeLook(notif.Background, notif.Theme)
+	drawBackground(dc, bg, layout.Width, layout.Height)
+
+	if err := dc.LoadFontFace("/usr/share/fonts/dejavu/DejaVuSans-Bold.ttf", layout.TitleSize*1.2); err != nil {
+		log.Printf("Warning: Could not load font, text may not display correctly: %v", err)
+	}
+	dc.SetColor(textColor)
+	dc.DrawStringAnchored("MEETING ENDED", float64(layout.Width)/2, float64(layout.Height)/2, 0.5, 0.5)
+
+	imagePath := filepath.Join(imagesDir, fmt.Sprintf("%s.png", clipID))
+	if err := dc.SavePNG(imagePath); err != nil {
+		return "", fmt.Errorf("failed to save ended image: %w", err)
+	}
+
+	if !ffmpegAvailable {
+		_, err := writeStaticCastImage(imagePath, clipID)
+		return clipID, err
+	}
+
+	// A little longer than the display time so the clip doesn't end before teardown
+	seconds := notif.EndScreenSeconds
+	if seconds < 1 {
+		seconds = defaultEndScreenSeconds
+	}
+	if _, err := generateNotificationVideo(context.Background(), []string{imagePath}, 0, clipID, time.Duration(seconds+5)*time.Second, "", 0, nil, audioChimes{}, "", notif.Orientation, "", ""); err != nil {
+		return "", err
+	}
+	return clipID, nil
+}
+
 // decodeImageFromFile decodes an image from a file
 func decodeImageFromFile(file *os.File) (image.Image, string, error) {
 	img, format, err := image.Decode(file)
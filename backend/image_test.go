@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The image shows America/New_York times with the zone abbreviation of the date's DST
+// state (resolved from the embedded tzdata on images without a zone database)
+func TestImageTimeLabelZoneAbbreviation(t *testing.T) {
+	tests := []struct {
+		name    string
+		instant time.Time
+		want    string
+	}{
+		{"winter date is standard time", time.Date(2024, time.January, 15, 19, 0, 0, 0, time.UTC), "2:00 PM EST"},
+		{"summer date is daylight time", time.Date(2024, time.July, 15, 18, 0, 0, 0, time.UTC), "2:00 PM EDT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := imageTimeLabel(tt.instant); got != tt.want {
+				t.Errorf("imageTimeLabel(%v) = %q, want %q", tt.instant, got, tt.want)
+			}
+		})
+	}
+}
+
+// The generated video lasts the requested window to within a frame, including windows
+// with a fraction of a second: the speech and its silence padding must not run past the
+// window, and the video must not stop short of it or round up to a whole second
+func TestGenerateNotificationVideoDuration(t *testing.T) {
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not on PATH", tool)
+		}
+	}
+	dir := t.TempDir()
+	t.Chdir(dir) // chunksDir is relative to the working directory
+
+	imagePath := filepath.Join(dir, "slide.png")
+	img := image.NewRGBA(image.Rect(0, 0, 320, 200))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	f, err := os.Create(imagePath)
+	if err != nil {
+		t.Fatalf("creating the image: %v", err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatalf("encoding the image: %v", err)
+	}
+	f.Close()
+
+	// Stands in for the speech: a 3s tone, repeated past the shorter window
+	audioPath := filepath.Join(dir, "speech.mp3")
+	tone := exec.Command("ffmpeg", "-y", "-f", "lavfi", "-i", "sine=frequency=440:duration=3", audioPath)
+	if out, err := tone.CombinedOutput(); err != nil {
+		t.Fatalf("generating the audio: %v\n%s", err, out)
+	}
+
+	tests := []struct {
+		name   string
+		window time.Duration
+		audio  string
+		repeat int
+	}{
+		{"whole seconds", 12 * time.Second, "", 1},
+		{"fraction of a second", 12400 * time.Millisecond, "", 1},
+		{"speech shorter than the window", 12400 * time.Millisecond, audioPath, 1},
+		{"speech longer than the window", 5300 * time.Millisecond, audioPath, 3},
+	}
+	// One frame at the rate used for windows with a fraction of a second; whole-second
+	// windows are a whole number of 1 fps frames, so they land on the window too
+	const maxDrift = time.Second / fractionalFrameRate
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := fmt.Sprintf("duration-%d", i)
+			playlist, err := generateNotificationVideo(context.Background(), []string{imagePath}, 0, id, tt.window, tt.audio, tt.repeat, nil, audioChimes{}, "", "", "", "")
+			if err != nil {
+				t.Fatalf("generating the video: %v", err)
+			}
+			got, err := probeDuration(playlist)
+			if err != nil {
+				t.Fatalf("probing the video: %v", err)
+			}
+			if drift := (got - tt.window).Abs(); drift > maxDrift {
+				t.Errorf("video lasts %v (-t %s), want %v within %v", got, ffmpegDuration(tt.window), tt.window, maxDrift)
+			}
+		})
+	}
+}
+
+// BenchmarkConcatAudio compares the two ways of repeating the TTS audio on an 8 second
+// MP3 like the TTS output: the concat demuxer (stream copy) and the concat filter
+// (decodes and re-encodes every copy). Run with: go test -run '^$' -bench ConcatAudio
+func BenchmarkConcatAudio(b *testing.B) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		b.Skip("ffmpeg is not on PATH")
+	}
+	dir := b.TempDir()
+	speechPath := filepath.Join(dir, "speech.mp3")
+	speech := exec.Command("ffmpeg", "-y", "-f", "lavfi", "-i", "sine=frequency=440:duration=8",
+		"-ar", "24000", "-ac", "1", "-c:a", "libmp3lame", "-b:a", "32k", speechPath)
+	if out, err := speech.CombinedOutput(); err != nil {
+		b.Fatalf("generating the speech: %v\n%s", err, out)
+	}
+
+	methods := []struct {
+		name   string
+		concat func(inputPath string, repeatCount int, outputPath string) error
+	}{
+		{"demuxer", concatAudioDemuxer},
+		{"filter", concatAudioFilter},
+	}
+	for _, method := range methods {
+		for _, repeats := range []int{5, 10} {
+			b.Run(fmt.Sprintf("%s/repeat=%d", method.name, repeats), func(b *testing.B) {
+				outputPath := filepath.Join(dir, fmt.Sprintf("%s_%d.mp3", method.name, repeats))
+				for b.Loop() {
+					if err := method.concat(speechPath, repeats, outputPath); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
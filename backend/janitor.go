@@ -0,0 +1,253 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// deleteUndoWindow is how long a deleted notification can still be restored
+var deleteUndoWindow = envDuration("DELETE_UNDO_WINDOW", 10*time.Minute)
+
+// statusRetention is how long notifications that finished with a status are kept
+// after their end time before the janitor purges them (0 = kept forever)
+type statusRetention struct {
+	Status  string
+	KeepFor time.Duration
+}
+
+// retentionPolicy is the per-status retention, from RETENTION_COMPLETED, RETENTION_FAILED
+// and RETENTION_MISSED
+var retentionPolicy = []statusRetention{
+	{"completed", envDuration("RETENTION_COMPLETED", 0)},
+	{"failed", envDuration("RETENTION_FAILED", 0)},
+	{"missed", envDuration("RETENTION_MISSED", 0)},
+}
+
+func (a *App) startJanitor() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.purgeDeletedNotifications()
+		a.purgeExpiredNotifications()
+		a.purgeAuditLog()
+		a.purgeOrphanedMedia()
+		purgeTTSCache()
+	}
+}
+
+// ttsCacheMaxAge is how long a cached TTS segment is kept after it was last used
+const ttsCacheMaxAge = 7 * 24 * time.Hour
+
+// purgeTTSCache removes cached TTS segments that haven't been used for a while
+func purgeTTSCache() {
+	entries, err := os.ReadDir(ttsCacheDir)
+	if err != nil {
+		return // no cache yet
+	}
+
+	cutoff := time.Now().Add(-ttsCacheMaxAge)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(ttsCacheDir, entry.Name())); err != nil {
+			log.Printf("[JANITOR] Error removing cached TTS segment %s: %v", entry.Name(), err)
+		}
+	}
+}
+
+// purgeExpiredNotifications removes finished notifications whose status' retention
+// window has passed since they ended
+func (a *App) purgeExpiredNotifications() {
+	now := time.Now().UTC()
+	for _, policy := range retentionPolicy {
+		if policy.KeepFor <= 0 {
+			continue
+		}
+		cutoff := now.Add(-policy.KeepFor)
+
+		var purged []string
+		err := withTx(a.DB, func(tx *sql.Tx) error {
+			var err error
+			purged, err = deleteNotificationRows(tx, "status = ? AND end_time <= ?",
+				policy.Status, cutoff.Format("2006-01-02 15:04:05"))
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec("DELETE FROM generation_metrics WHERE notification_id NOT IN (SELECT id FROM notifications)")
+			return err
+		})
+		if err != nil {
+			log.Printf("[JANITOR] Error purging %s notifications: %v", policy.Status, err)
+			continue
+		}
+
+		for _, id := range purged {
+			removeNotificationFiles(id)
+		}
+		if len(purged) > 0 {
+			log.Printf("[JANITOR] Purged %d %s notification(s) older than %s", len(purged), policy.Status, policy.KeepFor)
+		}
+	}
+}
+
+// getSettings reports the effective settings operators can tune through the
+// environment: the retention policy and the HLS output
+func getSettings(c *fiber.Ctx) error {
+	retention := fiber.Map{"deleted": deleteUndoWindow.String()}
+	for _, policy := range retentionPolicy {
+		keepFor := "forever"
+		if policy.KeepFor > 0 {
+			keepFor = policy.KeepFor.String()
+		}
+		retention[policy.Status] = keepFor
+	}
+	retention["audit"] = "forever"
+	if auditRetention > 0 {
+		retention["audit"] = auditRetention.String()
+	}
+	hls := fiber.Map{"mode": hlsSegmentMode, "segment_seconds": hlsSegmentSeconds, "experimental": hlsSegmentMode != hlsModeSegments}
+	return c.JSON(fiber.Map{"retention": retention, "hls": hls})
+}
+
+// purgeDeletedNotifications permanently removes soft-deleted rows whose undo window has passed
+func (a *App) purgeDeletedNotifications() {
+	cutoff := time.Now().UTC().Add(-deleteUndoWindow)
+
+	var purged []string
+	err := withTx(a.DB, func(tx *sql.Tx) error {
+		var err error
+		purged, err = deleteNotificationRows(tx, "deleted_at IS NOT NULL AND deleted_at <= ?",
+			cutoff.Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return err
+		}
+
+		// Drop generation metrics of notifications that no longer exist
+		_, err = tx.Exec("DELETE FROM generation_metrics WHERE notification_id NOT IN (SELECT id FROM notifications)")
+		return err
+	})
+	if err != nil {
+		log.Printf("[JANITOR] Error purging deleted notifications: %v", err)
+		return
+	}
+
+	for _, id := range purged {
+		removeNotificationFiles(id)
+	}
+	if len(purged) > 0 {
+		log.Printf("[JANITOR] Purged %d deleted notification(s)", len(purged))
+	}
+}
+
+// orphanedMediaMinAge is how old generated media of a notification that doesn't exist
+// must be before it is removed, so media generated around a notification's insert is kept
+const orphanedMediaMinAge = time.Hour
+
+// purgeOrphanedMedia removes generated chunks, images and audio (in CACHE_DIR when it is
+// set) left behind by notifications that no longer exist, e.g. after a crash between a
+// purge and its file cleanup or when the database was replaced. Only entries named
+// after a notification ID are considered.
+func (a *App) purgeOrphanedMedia() {
+	exists := map[string]bool{}
+	cutoff := time.Now().Add(-orphanedMediaMinAge)
+	removed := 0
+	for _, dir := range []string{chunksDir, imagesDir, audioDir} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue // nothing generated yet
+		}
+		for _, entry := range entries {
+			id := mediaNotificationID(entry.Name())
+			if id == "" {
+				continue
+			}
+			if info, err := entry.Info(); err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+			if _, checked := exists[id]; !checked {
+				var count int
+				if err := a.DB.QueryRow("SELECT COUNT(*) FROM notifications WHERE id = ?", id).Scan(&count); err != nil {
+					log.Printf("[JANITOR] Error checking media of notification %s: %v", id, err)
+					return
+				}
+				exists[id] = count > 0
+			}
+			if exists[id] {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				log.Printf("[JANITOR] Error removing orphaned media %s: %v", entry.Name(), err)
+				continue
+			}
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("[JANITOR] Removed %d orphaned media file(s) and directories", removed)
+	}
+}
+
+// mediaNotificationID is the notification ID a generated media file or directory is
+// named after (<id>, <id>.png, <id>_single.mp3, <id>_ended...), "" for anything else
+func mediaNotificationID(name string) string {
+	const idLength = 36
+	if len(name) < idLength || (len(name) > idLength && name[idLength] != '.' && name[idLength] != '_') {
+		return ""
+	}
+	if _, err := uuid.Parse(name[:idLength]); err != nil {
+		return ""
+	}
+	return name[:idLength]
+}
+
+// deleteNotificationRows deletes the notifications matching where and returns their IDs,
+// so their files can be removed once the transaction has committed
+func deleteNotificationRows(tx *sql.Tx, where string, args ...any) ([]string, error) {
+	rows, err := tx.Query("SELECT id FROM notifications WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM notifications WHERE "+where, args...); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// removeNotificationFiles removes everything generated for a purged notification: its
+// cast media (see removeNotificationMedia) and its images and audio
+func removeNotificationFiles(notifID string) {
+	removeNotificationMedia(notifID)
+	for _, dir := range []string{imagesDir, audioDir} {
+		files, _ := filepath.Glob(filepath.Join(dir, notifID+".*"))
+		derived, _ := filepath.Glob(filepath.Join(dir, notifID+"_*"))
+		for _, file := range append(files, derived...) {
+			if err := os.Remove(file); err != nil {
+				log.Printf("[JANITOR] Error removing %s of notification %s: %v", file, notifID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Purging a notification past its retention also removes its chunks (with the copies
+// derived from them), images and audio; a notification still kept keeps its files
+func TestPurgeExpiredNotificationsRemovesFiles(t *testing.T) {
+	a := newTestApp(t)
+	dir := t.TempDir()
+	t.Chdir(dir) // chunksDir is relative to the working directory
+
+	savedImages, savedAudio, savedPolicy := imagesDir, audioDir, retentionPolicy
+	imagesDir, audioDir = filepath.Join(dir, "images"), filepath.Join(dir, "audio")
+	retentionPolicy = []statusRetention{{"completed", time.Hour}}
+	t.Cleanup(func() { imagesDir, audioDir, retentionPolicy = savedImages, savedAudio, savedPolicy })
+
+	now := time.Now().UTC()
+	files := func(id string) []string {
+		return []string{
+			filepath.Join(chunksDir, id, "playlist.m3u8"),
+			filepath.Join(chunksDir, id+"_ended", "playlist.m3u8"),
+			filepath.Join(chunksDir, id+"_device-0123456789ab", "playlist.m3u8"),
+			filepath.Join(imagesDir, id+".png"),
+			filepath.Join(imagesDir, id+"_ended.png"),
+			filepath.Join(audioDir, id+"_single.mp3"),
+		}
+	}
+	for id, ended := range map[string]time.Time{"expired": now.Add(-2 * time.Hour), "kept": now.Add(-time.Minute)} {
+		_, err := a.DB.Exec(
+			"INSERT INTO notifications (id, message, start_time, end_time, device, status) VALUES (?, 'Standup', ?, ?, 'Kitchen', 'completed')",
+			id, ended.Add(-time.Hour).Format("2006-01-02 15:04:05"), ended.Format("2006-01-02 15:04:05"),
+		)
+		if err != nil {
+			t.Fatalf("inserting %s: %v", id, err)
+		}
+		for _, file := range files(id) {
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	a.purgeExpiredNotifications()
+
+	for _, file := range files("expired") {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s of the purged notification is still there (%v)", file, err)
+		}
+	}
+	for _, file := range files("kept") {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("%s of the kept notification: %v", file, err)
+		}
+	}
+	var remaining int
+	if err := a.DB.QueryRow("SELECT COUNT(*) FROM notifications").Scan(&remaining); err != nil || remaining != 1 {
+		t.Errorf("%d notifications left (%v), want 1", remaining, err)
+	}
+}
+
+// Media of a notification that no longer exists is removed once it is old enough; media
+// of existing notifications, recent media and anything not named after a notification
+// (like the TTS cache) stay
+func TestPurgeOrphanedMedia(t *testing.T) {
+	a := newTestApp(t)
+	dir := t.TempDir()
+	t.Chdir(dir) // chunksDir is relative to the working directory
+
+	savedImages, savedAudio := imagesDir, audioDir
+	imagesDir, audioDir = filepath.Join(dir, "images"), filepath.Join(dir, "audio")
+	t.Cleanup(func() { imagesDir, audioDir = savedImages, savedAudio })
+
+	const (
+		existing = "0b6f9a52-3c1e-4d6a-9f0e-2a7c5d8e1b4f"
+		orphan   = "7d2e4c18-9a3b-4f5e-8c6d-1e0f2a3b4c5d"
+		recent   = "c4a8e2f6-1b3d-4e5f-a7c9-0d2e4f6a8b1c"
+	)
+	if _, err := a.DB.Exec("INSERT INTO notifications (id, message, start_time, end_time, device) VALUES (?, 'Standup', '2024-03-10 14:00:00', '2024-03-10 15:00:00', 'Kitchen')", existing); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-2 * orphanedMediaMinAge)
+	write := func(path string, modTime time.Time) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kept := []string{
+		filepath.Join(imagesDir, existing+".png"),
+		filepath.Join(audioDir, existing+"_single.mp3"),
+		filepath.Join(imagesDir, recent+".png"),
+		filepath.Join(audioDir, "cache", "segment.mp3"),
+	}
+	removed := []string{
+		filepath.Join(imagesDir, orphan+".png"),
+		filepath.Join(imagesDir, orphan+"_ended.png"),
+		filepath.Join(audioDir, orphan+"_single.mp3"),
+	}
+	for _, path := range kept {
+		write(path, old)
+	}
+	write(kept[2], time.Now())
+	for _, path := range removed {
+		write(path, old)
+	}
+	// A chunks directory is judged by its own modification time
+	write(filepath.Join(chunksDir, orphan, "playlist.m3u8"), old)
+	if err := os.Chtimes(filepath.Join(chunksDir, orphan), old, old); err != nil {
+		t.Fatal(err)
+	}
+	removed = append(removed, filepath.Join(chunksDir, orphan))
+	if err := os.Chtimes(filepath.Join(audioDir, "cache"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	a.purgeOrphanedMedia()
+
+	for _, path := range kept {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s should be kept: %v", path, err)
+		}
+	}
+	for _, path := range removed {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed (%v)", path, err)
+		}
+	}
+}
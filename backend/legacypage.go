@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"time"
+)
+
+// defaultNotificationPage is the built-in design of the legacy /notification/:id page
+const defaultNotificationPage = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Notification</title>
+	<style>
+		body {
+			margin: 0;
+			padding: 0;
+			display: flex;
+			justify-content: center;
+			align-items: center;
+			height: 100vh;
+			background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+			font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
+		}
+		.message {
+			text-align: center;
+			color: white;
+			font-size: 4em;
+			padding: 40px;
+			text-shadow: 2px 2px 4px rgba(0,0,0,0.3);
+			word-wrap: break-word;
+			max-width: 90%;
+		}
+	</style>
+</head>
+<body>
+	<div class="message">{{.Message}}</div>
+</body>
+</html>`
+
+// The legacy page predates the generated image and video: the first casts pointed the
+// Chromecast's default receiver at this HTML page, which can't be relied on to render
+// (or keep rendering) a web page, so casts switched to the HLS video and the PNG. The
+// page is kept for browsers and dashboards that embed it. legacyPageEnabled
+// (LEGACY_PAGE_ENABLED) turns the route off for deployments that don't use it.
+var legacyPageEnabled = envBool("LEGACY_PAGE_ENABLED", true)
+
+// notificationPageData is what a legacy page template can use
+type notificationPageData struct {
+	ID        string
+	Message   string
+	Device    string
+	StartTime string // formatted like the generated image, e.g. "2:00 PM EDT"
+	EndTime   string // empty for pinned statuses
+}
+
+// notificationPage is the parsed legacy page template (see loadNotificationPageTemplate)
+var notificationPage = template.Must(template.New("notification").Parse(defaultNotificationPage))
+
+// loadNotificationPageTemplate replaces the built-in legacy page with the html/template
+// file at NOTIFICATION_PAGE_TEMPLATE, keeping the default if it is unset or invalid
+func loadNotificationPageTemplate() {
+	if !legacyPageEnabled {
+		log.Printf("LEGACY_PAGE_ENABLED is false: /notification/:id returns 404")
+		return
+	}
+	path := envString("NOTIFICATION_PAGE_TEMPLATE", "")
+	if path == "" {
+		return
+	}
+
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		log.Printf("Warning: Could not load NOTIFICATION_PAGE_TEMPLATE, using the default page: %v", err)
+		return
+	}
+	notificationPage = tmpl
+	log.Printf("Using notification page template %s", path)
+}
+
+// renderNotificationPage renders the legacy page; html/template escapes the message
+func renderNotificationPage(notif Notification) ([]byte, error) {
+	estLocation, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		estLocation = time.UTC
+	}
+
+	data := notificationPageData{
+		ID:        notif.ID,
+		Message:   plainMessage(filterMessage(notif.Message)),
+		Device:    notif.Device,
+		StartTime: notif.StartTime.In(estLocation).Format(displayTimeFormat),
+	}
+	if !notif.Pinned {
+		data.EndTime = notif.EndTime.In(estLocation).Format(displayTimeFormat)
+	}
+
+	var page bytes.Buffer
+	if err := notificationPage.Execute(&page, data); err != nil {
+		return nil, err
+	}
+	return page.Bytes(), nil
+}
@@ -1,49 +1,138 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
-	"html"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/milkam/gochromecast/pkg/ip"
 )
 
 type Notification struct {
-	ID          string    `json:"id"`
-	Message     string    `json:"message"`
-	StartTime   time.Time `json:"start_time"`
-	EndTime     time.Time `json:"end_time"`
-	Device      string    `json:"device"`
-	Status      string    `json:"status"` // "pending", "active", "completed"
-	RepeatCount int       `json:"repeat_count"` // how many times to repeat TTS audio
+	XMLName xml.Name `json:"-" xml:"notification"`
+
+	ID                string         `json:"id" xml:"id"`
+	Message           string         `json:"message" xml:"message"`
+	MessageURL        string         `json:"message_url,omitempty" xml:"message_url,omitempty"` // fetched at generation time to replace the message (which becomes the fallback)
+	StartTime         time.Time      `json:"start_time" xml:"start_time"`
+	EndTime           time.Time      `json:"end_time" xml:"end_time"`
+	Device            string         `json:"device" xml:"device"`
+	Status            string         `json:"status" xml:"status"`                                               // "pending", "active", "completed", "failed", "missed"
+	RepeatCount       int            `json:"repeat_count" xml:"repeat_count"`                                   // how many times to repeat TTS audio
+	Images            []string       `json:"images,omitempty" xml:"images>image,omitempty"`                     // slideshow image refs ("message" or uploaded image IDs)
+	SlideInterval     int            `json:"slide_interval,omitempty" xml:"slide_interval,omitempty"`           // seconds each slideshow image is shown
+	DeletedAt         *time.Time     `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`                   // set while soft-deleted (restorable until purged)
+	EndingSoonMinutes int            `json:"ending_soon_minutes,omitempty" xml:"ending_soon_minutes,omitempty"` // announce "ending soon" this many minutes before the end (0 = off)
+	EndAction         string         `json:"end_action" xml:"end_action"`                                       // "stop", "ended_screen" or "follow_up"
+	EndScreenSeconds  int            `json:"end_screen_seconds,omitempty" xml:"end_screen_seconds,omitempty"`   // how long the "meeting ended" screen shows
+	FollowUpID        string         `json:"follow_up_id,omitempty" xml:"follow_up_id,omitempty"`               // notification cast when this one ends (follow_up)
+	Pinned            bool           `json:"pinned,omitempty" xml:"pinned,omitempty"`                           // open-ended "I'm busy" status, casts until cleared
+	Background        *Background    `json:"background,omitempty" xml:"background,omitempty"`                   // custom gradient (nil = the theme's, or default diagonal purple)
+	Theme             string         `json:"theme,omitempty" xml:"theme,omitempty"`                             // built-in theme: "ocean", "sunset", "forest" or "mono" (empty = DEFAULT_THEME, see theme.go)
+	Voice             string         `json:"voice,omitempty" xml:"voice,omitempty"`                             // Google TTS voice name (empty = default Chirp HD voice)
+	ChimeBefore       string         `json:"chime_before,omitempty" xml:"chime_before,omitempty"`               // chime file in CHIMES_DIR played before the speech ("none" = off, empty = CHIME_BEFORE)
+	ChimeAfter        string         `json:"chime_after,omitempty" xml:"chime_after,omitempty"`                 // chime file in CHIMES_DIR played after the speech ("none" = off, empty = CHIME_AFTER)
+	Clip              string         `json:"clip,omitempty" xml:"clip,omitempty"`                               // uploaded clip ID looped instead of the generated image
+	Orientation       string         `json:"orientation,omitempty" xml:"orientation,omitempty"`                 // "landscape" or "portrait" (empty = landscape)
+	TextLayout        *TextLayout    `json:"text_layout,omitempty" xml:"text_layout,omitempty"`                 // text alignment and vertical anchor (nil = centered, top)
+	Overlay           *Overlay       `json:"overlay,omitempty" xml:"overlay,omitempty"`                         // "LIVE" badge and/or clock drawn over the video (nil = none)
+	QRCode            *QRCode        `json:"qr_code,omitempty" xml:"qr_code,omitempty"`                         // QR code (e.g. a join link) drawn in a corner of the image (nil = none)
+	Type              string         `json:"type" xml:"type"`                                                   // "meeting", "reminder", "alert" or "announcement" (see notificationPresets)
+	DeviceSequence    []string       `json:"device_sequence,omitempty" xml:"device_sequence>device,omitempty"`  // devices cast to in turn, starting with Device (follow-the-person)
+	DwellSeconds      int            `json:"dwell_seconds,omitempty" xml:"dwell_seconds,omitempty"`             // how long the cast stays on each device of the sequence
+	DeviceMessages    DeviceMessages `json:"device_messages,omitempty" xml:"device_messages,omitempty"`         // message shown and spoken on a given device instead of Message (see devicemessages.go)
+	CastMode          string         `json:"cast_mode" xml:"cast_mode"`                                         // "auto", "video" (HLS) or "image" (static PNG, no audio)
+	Muted             bool           `json:"muted,omitempty" xml:"muted,omitempty"`                             // no speech (or chimes); auto mode then casts the image
+	Scroll            bool           `json:"scroll,omitempty" xml:"scroll,omitempty"`                           // a message too long for the screen scrolls in the video instead of being cut off (see scroll.go)
+	FailureReason     string         `json:"failure_reason,omitempty" xml:"failure_reason,omitempty"`           // why a failed notification can't be cast, or that a missed one never was
+	MessageURLError   string         `json:"message_url_error,omitempty" xml:"message_url_error,omitempty"`     // why the last message_url fetch failed (the fallback message was used)
+	AgendaFile        string         `json:"agenda_file,omitempty" xml:"agenda_file,omitempty"`                 // agenda (file name in AGENDA_DIR or URL) re-read at generation time to replace the message
+	AgendaError       string         `json:"agenda_error,omitempty" xml:"agenda_error,omitempty"`               // why the last agenda_file read failed (the last good agenda or the message was used)
+	AcknowledgedAt    *time.Time     `json:"acknowledged_at,omitempty" xml:"acknowledged_at,omitempty"`         // when the viewer acknowledged the message (first ack only)
+	MediaHash         string         `json:"-" xml:"-"`                                                         // mediaInputsHash of the inputs the current video was generated from
+
+	// Filled in for API responses only
+	GenerationStatus  string             `json:"generation_status,omitempty" xml:"generation_status,omitempty"`   // "queued", "generating", "ready" or "not_started"
+	ImageURL          string             `json:"image_url,omitempty" xml:"image_url,omitempty"`                   // public URL for clients (PUBLIC_BASE_URL or X-Forwarded-* aware)
+	VideoURL          string             `json:"video_url,omitempty" xml:"video_url,omitempty"`                   // public HLS playlist URL for clients
+	CastURL           string             `json:"cast_url,omitempty" xml:"cast_url,omitempty"`                     // LAN URL the Chromecast plays; only reachable on the local network
+	CurrentDevice     string             `json:"current_device,omitempty" xml:"current_device,omitempty"`         // device the cast is on right now (moves along device_sequence)
+	SpeechText        string             `json:"speech_text,omitempty" xml:"speech_text,omitempty"`               // exact text sent to TTS, returned on create so the wording can be checked
+	CastStatus        string             `json:"cast_status,omitempty" xml:"cast_status,omitempty"`               // outcome of cast_now on create (see castOnCreate)
+	CastError         string             `json:"cast_error,omitempty" xml:"cast_error,omitempty"`                 // why cast_now couldn't start the cast
+	CastResolution    *CastResolution    `json:"cast_resolution,omitempty" xml:"cast_resolution,omitempty"`       // set while casting a video larger than the device plays (see resolution.go)
+	GenerationMetrics *GenerationMetrics `json:"generation_metrics,omitempty" xml:"generation_metrics,omitempty"` // how long the last generation took
 }
 
 type ChromecastDevice struct {
-	Name     string `json:"name"`
-	UUID     string `json:"uuid"`
-	Address  string `json:"address"`
+	Name         string    `json:"name"`                // alias if one is set, otherwise the device's own name
+	RealName     string    `json:"real_name,omitempty"` // device's own name when an alias is applied
+	UUID         string    `json:"uuid"`
+	Address      string    `json:"address"`
+	LastSeen     time.Time `json:"last_seen"`        // when the device last answered discovery
+	Online       bool      `json:"online"`           // seen in the most recent discovery cycle
+	Type         string    `json:"type"`             // "video" or "speaker" (audio-only)
+	Capabilities []string  `json:"capabilities"`     // media the device plays: "video" and/or "audio"
+	Color        string    `json:"color,omitempty"`  // accent color set with the device's alias
+	Volume       *float64  `json:"volume,omitempty"` // cast volume set with the device's alias
 }
 
 type App struct {
-	DB                *sql.DB
-	ActiveCasts       map[string]*CastSession
-	CastMutex         sync.RWMutex
-	VideoGenMutex     sync.Mutex  // Prevents concurrent video pre-generation
-	VideoGenInProgress map[string]bool // Track which notifications are being generated
+	DB                 *sql.DB
+	ReadDB             *sql.DB // read-only pool for list and report GET handlers (DB unless DB_READ_POOL / DB_READ_PATH)
+	ActiveCasts        map[string]*CastSession
+	CastMutex          sync.RWMutex
+	VideoGenMutex      sync.Mutex                    // Prevents concurrent video pre-generation
+	VideoGenInProgress map[string]bool               // Track which notifications are being generated
+	VideoGenCancels    map[string]context.CancelFunc // Cancels each in-progress generation (see cancelAllGenerations)
+	FailureMutex       sync.Mutex
+	RecentFailures     []FailureRecord // Latest cast/generation failures, oldest first
+	Stmts              *Statements     // Prepared hot-path queries
+	GenerationSlots    chan struct{}   // One token per running FFmpeg/TTS generation (MAX_CONCURRENT_GENERATIONS)
+	GenerationQueued   atomic.Int32    // Generations waiting for a slot
+	CastsWaiting       atomic.Int32    // Due notifications waiting for a cast slot (MAX_ACTIVE_CASTS) on the last tick
 }
 
 var appInstance *App
 
+// End-of-cast actions
+const (
+	endActionStop        = "stop"         // stop casting (device returns to its home screen)
+	endActionEndedScreen = "ended_screen" // show a "meeting ended" screen, then stop
+	endActionFollowUp    = "follow_up"    // cast another notification
+
+	defaultEndScreenSeconds = 10
+	maxEndScreenSeconds     = 300
+)
+
+// eagerGeneration makes every new notification generate its video at creation time
+var eagerGeneration = envBool("EAGER_GENERATION", false)
+
 func main() {
+	selfTest := flag.Bool("selftest", false, "run the image/TTS/video pipeline once, report each stage and exit")
+	selfTestDevice := flag.String("selftest-device", "", "with -selftest, also cast the result to this device for a few seconds")
+	benchAudio := flag.Int("bench-audio", 0, "time the single-pass and two-pass audio pipelines with this repeat count and exit")
+	flag.Parse()
+
+	log.Printf("Notification service %s (commit %s, built %s)", version, commit, buildDate)
+
 	// Initialize database
 	db, err := initDB()
 	if err != nil {
@@ -51,10 +140,71 @@ func main() {
 	}
 	defer db.Close()
 
+	// Optional read-only pool for the GET handlers (see db.go)
+	readDB := openReadDB(db, dbPath())
+	if readDB != db {
+		defer readDB.Close()
+	}
+
+	stmts, err := prepareStatements(db)
+	if err != nil {
+		log.Fatalf("Failed to prepare database statements: %v", err)
+	}
+	defer stmts.Close()
+
 	appInstance = &App{
-		DB:                db,
-		ActiveCasts:       make(map[string]*CastSession),
+		DB:                 db,
+		ReadDB:             readDB,
+		Stmts:              stmts,
+		ActiveCasts:        make(map[string]*CastSession),
 		VideoGenInProgress: make(map[string]bool),
+		VideoGenCancels:    make(map[string]context.CancelFunc),
+		GenerationSlots:    make(chan struct{}, max(maxConcurrentGenerations, 1)),
+	}
+
+	// Optional custom design for the legacy HTML page
+	loadNotificationPageTemplate()
+
+	// Without FFmpeg, casts fall back to static images
+	detectFFmpeg()
+
+	// Generated media goes to CACHE_DIR when set; a read-only or full volume is
+	// reported once here, not per notification
+	initCacheDir()
+	checkStorageAtStartup()
+
+	if castTestMode {
+		log.Printf("CAST_TEST_MODE is on: casts are exported to %s instead of being sent to devices", castTestDir)
+	}
+
+	// Pick the TTS output format (validated against the installed FFmpeg)
+	initTTSAudioFormat()
+	initAudioPipeline()
+	initHLSOutput()
+	initThemes()
+	initTimeFormat()
+	initSpeechAudio()
+	initMessageFilter()
+	initTTSProvider()
+	initAlerts()
+	initDeviceResolution()
+	if err := validateTTSVoice(greetingVoice); err != nil {
+		log.Printf("Warning: Ignoring GREETING_VOICE: %v", err)
+		greetingVoice = ""
+	}
+
+	// One-shot environment check (fonts, FFmpeg, TTS credentials) without the server
+	if *selfTest {
+		code := runSelfTest(*selfTestDevice)
+		stmts.Close()
+		db.Close()
+		os.Exit(code)
+	}
+	if *benchAudio > 0 {
+		code := runAudioBenchmark(*benchAudio)
+		stmts.Close()
+		db.Close()
+		os.Exit(code)
 	}
 
 	// Start the scheduler
@@ -63,27 +213,64 @@ func main() {
 	// Start device discovery in background
 	go appInstance.startDeviceDiscovery()
 
+	// Purge soft-deleted notifications once their undo window has passed
+	go appInstance.startJanitor()
+
+	// Write audit entries off the request path
+	go appInstance.startAuditWriter()
+
 	// Setup Fiber app
 	app := fiber.New(fiber.Config{
 		AppName: "Notification Service",
 	})
 
-	// CORS middleware
-	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders: "Origin,Content-Type,Accept,Authorization",
-	}))
+	// CORS middleware (origins allowlist from CORS_ALLOWED_ORIGINS)
+	corsConfig, err := corsConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid CORS configuration: %v", err)
+	}
+	log.Printf("CORS allowed origins: %s", corsConfig.AllowOrigins)
+	app.Use(cors.New(corsConfig))
 
 	// Routes
 	api := app.Group("/api")
+	api.Get("/health", getHealth)
+	api.Get("/version", getVersion)
+	api.Get("/settings", getSettings)
 	api.Get("/devices", getDevices)
+	api.Get("/device-aliases", getDeviceAliases)
+	api.Put("/device-aliases", setDeviceAlias)
+	api.Delete("/device-aliases", deleteDeviceAlias)
 	api.Post("/notifications", createNotification)
+	api.Post("/notifications/batch", createNotificationsBatch)
 	api.Get("/notifications", getNotifications)
+	api.Get("/notifications/upcoming", getUpcomingNotifications)
 	api.Get("/notifications/:id", getNotification)
 	api.Delete("/notifications/:id", deleteNotification)
+	api.Post("/notifications/:id/restore", restoreNotification)
+	api.Post("/notifications/:id/ack", acknowledgeNotification)
+	api.Post("/notifications/:id/recast", recastNotification)
+	api.Post("/notifications/:id/clone", cloneNotification)
+	api.Get("/notifications/:id/files", requireDebugToken, getNotificationFiles)
+	api.Get("/templates", getTemplates)
+	api.Post("/templates", createTemplate)
+	api.Get("/templates/:id", getTemplate)
+	api.Delete("/templates/:id", deleteTemplate)
+	api.Post("/templates/:id/notifications", createNotificationFromTemplate)
+	api.Post("/images", uploadImage)
+	api.Post("/clips", uploadClip)
+	api.Get("/stats", getStats)
+	api.Post("/webhook/:token", handleWebhook)
+	api.Post("/status/start", startStatus)
+	api.Post("/status/stop", stopStatus)
+	api.Post("/casts/stop-all", stopAllCasts)
+	api.Post("/generation/cancel-all", cancelAllGenerations)
+	api.Get("/scheduler/next", getSchedulerNext)
+	api.Get("/audit", getAuditLog)
+	api.Get("/export/ics", exportICS)
+	api.Get("/dashboard", getDashboard)
 
-	// Route to serve notification content for Chromecast (HTML - legacy)
+	// Route to serve notification content for Chromecast (HTML - legacy, LEGACY_PAGE_ENABLED)
 	app.Get("/notification/:id", serveNotificationContent)
 	
 	// Route to serve notification images for Chromecast
@@ -106,43 +293,172 @@ func main() {
 	}
 }
 
-func initDB() (*sql.DB, error) {
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = "/data/notifications.db"
+// dbPath is the SQLite database file (DB_PATH)
+func dbPath() string {
+	if path := os.Getenv("DB_PATH"); path != "" {
+		return path
 	}
+	return "/data/notifications.db"
+}
 
+func initDB() (*sql.DB, error) {
 	// Create directory if it doesn't exist
 	if err := os.MkdirAll("/data", 0755); err != nil {
 		log.Printf("Warning: Could not create /data directory: %v", err)
 	}
 
-	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL")
+	db, err := sql.Open("sqlite3", dbPath()+"?_journal_mode=WAL")
 	if err != nil {
 		return nil, err
 	}
 
-	// Create table
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS notifications (
-		id TEXT PRIMARY KEY,
-		message TEXT NOT NULL,
-		start_time DATETIME NOT NULL,
-		end_time DATETIME NOT NULL,
-		device TEXT NOT NULL,
-		status TEXT DEFAULT 'pending',
-		repeat_count INTEGER DEFAULT 1,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	if _, err := db.Exec(createTableSQL); err != nil {
-		return nil, fmt.Errorf("failed to create table: %w", err)
+	// Create or upgrade the schema (see migrations.go)
+	if err := runMigrations(db); err != nil {
+		return nil, err
 	}
 
 	return db, nil
 }
 
-// Helper function to parse time in multiple formats (RFC3339 or custom format)
+// notificationColumns is the column list every notification query selects,
+// in the order scanNotification expects
+const notificationColumns = "id, message, start_time, end_time, device, status, repeat_count, images, slide_interval, deleted_at, ending_soon_minutes, end_action, end_screen_seconds, follow_up_id, pinned, background, voice, chime_before, chime_after, clip, media_hash, orientation, failure_reason, acknowledged_at, text_layout, overlay, type, device_sequence, dwell_seconds, message_url, message_url_error, cast_mode, muted, qr_code, agenda_file, agenda_error, theme, scroll, device_messages"
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanNotification reads a row selected with notificationColumns and parses its times as UTC
+func scanNotification(row rowScanner) (Notification, error) {
+	var notif Notification
+	var startTimeStr, endTimeStr, imagesStr, backgroundStr, textLayoutStr, overlayStr, sequenceStr, qrCodeStr, deviceMessagesStr string
+	var deletedAtStr, acknowledgedAtStr sql.NullString
+
+	err := row.Scan(
+		&notif.ID,
+		&notif.Message,
+		&startTimeStr,
+		&endTimeStr,
+		&notif.Device,
+		&notif.Status,
+		&notif.RepeatCount,
+		&imagesStr,
+		&notif.SlideInterval,
+		&deletedAtStr,
+		&notif.EndingSoonMinutes,
+		&notif.EndAction,
+		&notif.EndScreenSeconds,
+		&notif.FollowUpID,
+		&notif.Pinned,
+		&backgroundStr,
+		&notif.Voice,
+		&notif.ChimeBefore,
+		&notif.ChimeAfter,
+		&notif.Clip,
+		&notif.MediaHash,
+		&notif.Orientation,
+		&notif.FailureReason,
+		&acknowledgedAtStr,
+		&textLayoutStr,
+		&overlayStr,
+		&notif.Type,
+		&sequenceStr,
+		&notif.DwellSeconds,
+		&notif.MessageURL,
+		&notif.MessageURLError,
+		&notif.CastMode,
+		&notif.Muted,
+		&qrCodeStr,
+		&notif.AgendaFile,
+		&notif.AgendaError,
+		&notif.Theme,
+		&notif.Scroll,
+		&deviceMessagesStr,
+	)
+	if err != nil {
+		return notif, err
+	}
+
+	// Parse as UTC time (handles multiple formats)
+	notif.StartTime, err = parseTimeInUTC(startTimeStr)
+	if err != nil {
+		return notif, fmt.Errorf("error parsing start_time '%s': %w", startTimeStr, err)
+	}
+	notif.EndTime, err = parseTimeInUTC(endTimeStr)
+	if err != nil {
+		return notif, fmt.Errorf("error parsing end_time '%s': %w", endTimeStr, err)
+	}
+
+	if imagesStr != "" {
+		if err := json.Unmarshal([]byte(imagesStr), &notif.Images); err != nil {
+			return notif, fmt.Errorf("error parsing images: %w", err)
+		}
+	}
+
+	if backgroundStr != "" {
+		notif.Background = &Background{}
+		if err := json.Unmarshal([]byte(backgroundStr), notif.Background); err != nil {
+			return notif, fmt.Errorf("error parsing background: %w", err)
+		}
+	}
+
+	if textLayoutStr != "" {
+		notif.TextLayout = &TextLayout{}
+		if err := json.Unmarshal([]byte(textLayoutStr), notif.TextLayout); err != nil {
+			return notif, fmt.Errorf("error parsing text_layout: %w", err)
+		}
+	}
+
+	if overlayStr != "" {
+		notif.Overlay = &Overlay{}
+		if err := json.Unmarshal([]byte(overlayStr), notif.Overlay); err != nil {
+			return notif, fmt.Errorf("error parsing overlay: %w", err)
+		}
+	}
+
+	if sequenceStr != "" {
+		if err := json.Unmarshal([]byte(sequenceStr), &notif.DeviceSequence); err != nil {
+			return notif, fmt.Errorf("error parsing device_sequence: %w", err)
+		}
+	}
+
+	if deviceMessagesStr != "" {
+		if err := json.Unmarshal([]byte(deviceMessagesStr), &notif.DeviceMessages); err != nil {
+			return notif, fmt.Errorf("error parsing device_messages: %w", err)
+		}
+	}
+
+	if qrCodeStr != "" {
+		notif.QRCode = &QRCode{}
+		if err := json.Unmarshal([]byte(qrCodeStr), notif.QRCode); err != nil {
+			return notif, fmt.Errorf("error parsing qr_code: %w", err)
+		}
+	}
+
+	if deletedAtStr.Valid {
+		deletedAt, err := parseTimeInUTC(deletedAtStr.String)
+		if err != nil {
+			return notif, fmt.Errorf("error parsing deleted_at '%s': %w", deletedAtStr.String, err)
+		}
+		notif.DeletedAt = &deletedAt
+	}
+
+	if acknowledgedAtStr.Valid {
+		acknowledgedAt, err := parseTimeInUTC(acknowledgedAtStr.String)
+		if err != nil {
+			return notif, fmt.Errorf("error parsing acknowledged_at '%s': %w", acknowledgedAtStr.String, err)
+		}
+		notif.AcknowledgedAt = &acknowledgedAt
+	}
+
+	return notif, nil
+}
+
+// Helper function to parse time in multiple formats (RFC3339 or custom format).
+// Create requests, query filters and stored rows all go through it, so every
+// accepted format round-trips: RFC3339 with an offset ("2024-05-01T14:00:00-04:00"),
+// RFC3339 in UTC ("2024-05-01T18:00:00Z") and "2024-05-01 18:00:00" (taken as UTC).
 func parseTimeInUTC(timeStr string) (time.Time, error) {
 	// Try RFC3339 format first (ISO 8601 with 'T' separator)
 	if t, err := time.Parse(time.RFC3339, timeStr); err == nil {
@@ -160,67 +476,685 @@ func parseTimeInUTC(timeStr string) (time.Time, error) {
 	return time.Parse(time.RFC3339, timeStr)
 }
 
+// publicBaseURL returns the externally visible base URL for links handed to clients.
+// PUBLIC_BASE_URL wins; otherwise the X-Forwarded-Proto/Host headers set by a reverse
+// proxy (nginx, ngrok) are honored before falling back to the request itself.
+func publicBaseURL(c *fiber.Ctx) string {
+	if base := os.Getenv("PUBLIC_BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+
+	proto := firstHeaderValue(c.Get(fiber.HeaderXForwardedProto))
+	if proto == "" {
+		proto = c.Protocol()
+	}
+	host := firstHeaderValue(c.Get(fiber.HeaderXForwardedHost))
+	if host == "" {
+		host = c.Hostname()
+	}
+	return proto + "://" + host
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally (use with ESCAPE '\')
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// firstHeaderValue returns the first entry of a comma-separated header (proxies may chain them)
+func firstHeaderValue(value string) string {
+	if i := strings.Index(value, ","); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+// withMediaURLs fills in the public and Chromecast-facing media links of a notification
+func withMediaURLs(c *fiber.Ctx, notif *Notification, localIP string) {
+	base := publicBaseURL(c)
+	notif.ImageURL = fmt.Sprintf("%s/notification-image/%s", base, notif.ID)
+	notif.VideoURL = fmt.Sprintf("%s/notification-video/%s/playlist.m3u8", base, notif.ID)
+	if localIP != "" {
+		notif.CastURL = castMediaURL(localIP, notif.ID, appInstance.isSpeakerDevice(notif.Device), castsImage(*notif))
+	}
+}
+
+// notificationList wraps a notification list so the XML form has a root element;
+// JSON responses stay a plain array
+type notificationList struct {
+	XMLName       xml.Name       `xml:"notifications"`
+	Notifications []Notification `xml:"notification"`
+}
+
+func (l notificationList) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.Notifications)
+}
+
+// respond writes a read endpoint's result as XML when the Accept header prefers it
+// (application/xml or text/xml) and as JSON otherwise. Errors are always JSON.
+func respond(c *fiber.Ctx, data interface{}) error {
+	c.Vary(fiber.HeaderAccept)
+	switch c.Accepts(fiber.MIMEApplicationJSON, fiber.MIMEApplicationXML, fiber.MIMETextXML) {
+	case fiber.MIMEApplicationXML, fiber.MIMETextXML:
+		return c.XML(data)
+	default:
+		return c.JSON(data)
+	}
+}
+
+// lanIP returns the LAN address used in cast URLs, or "" if it can't be determined
+func lanIP() string {
+	localIP, err := ip.GetLANIp()
+	if err != nil {
+		log.Printf("Warning: Could not resolve local IP for cast URLs: %v", err)
+		return ""
+	}
+	return localIP
+}
+
 // API Handlers
+
+// getDevices runs a discovery and returns the devices; ?timeout= (seconds, up to
+// maxDiscoveryWait) and ?ipv6= override the defaults for slow-announcing networks
 func getDevices(c *fiber.Ctx) error {
-	devices := appInstance.discoverDevices()
-	return c.JSON(devices)
+	opts := defaultDiscovery
+	if value := c.Query("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > maxDiscoveryWait {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("timeout must be between 1 and %d seconds", int(maxDiscoveryWait.Seconds()))})
+		}
+		opts.Wait = time.Duration(seconds) * time.Second
+	}
+	if value := c.Query("ipv6"); value != "" {
+		ipv6, err := strconv.ParseBool(value)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "ipv6 must be true or false"})
+		}
+		opts.IPv6 = ipv6
+	}
+
+	capability := c.Query("capability")
+	switch capability {
+	case "", capabilityVideo, capabilityAudio:
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Invalid capability '%s' (expected video or audio)", capability)})
+	}
+
+	devices := appInstance.discoverDevices(opts)
+	if capability != "" {
+		devices = filterDevicesByCapability(devices, capability)
+	}
+	return c.JSON(applyDeviceAliases(devices, loadDeviceAliases(appInstance.DB)))
 }
 
-func createNotification(c *fiber.Ctx) error {
-	var requestBody struct {
-		Message     string `json:"message"`
-		Device      string `json:"device"`
-		StartTime   string `json:"start_time"`
-		EndTime     string `json:"end_time"`
-		RepeatCount int    `json:"repeat_count"`
+// filterDevicesByCapability keeps the devices that can play the given media
+func filterDevicesByCapability(devices []ChromecastDevice, capability string) []ChromecastDevice {
+	filtered := []ChromecastDevice{}
+	for _, device := range devices {
+		if slices.Contains(device.Capabilities, capability) {
+			filtered = append(filtered, device)
+		}
 	}
-	
-	if err := c.BodyParser(&requestBody); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	return filtered
+}
+
+// notificationRequest is the body of POST /api/notifications (and each item of a batch)
+type notificationRequest struct {
+	Message           string            `json:"message"`
+	MessageURL        string            `json:"message_url"`
+	AgendaFile        string            `json:"agenda_file"`
+	Device            string            `json:"device"`
+	StartTime         string            `json:"start_time"`
+	EndTime           string            `json:"end_time"`
+	RepeatCount       int               `json:"repeat_count"`
+	Images            []string          `json:"images"`
+	SlideInterval     int               `json:"slide_interval"`
+	EndingSoonMinutes int               `json:"ending_soon_minutes"`
+	Eager             *bool             `json:"eager"` // generate the video now instead of in the pre-gen window
+	EndAction         string            `json:"end_action"`
+	EndScreenSeconds  int               `json:"end_screen_seconds"`
+	FollowUpID        string            `json:"follow_up_id"`
+	Background        *Background       `json:"background"`
+	Theme             string            `json:"theme"`
+	TextLayout        *TextLayout       `json:"text_layout"`
+	Overlay           *Overlay          `json:"overlay"`
+	QRCode            *QRCode           `json:"qr_code"`
+	Type              string            `json:"type"`
+	Voice             string            `json:"voice"`
+	ChimeBefore       string            `json:"chime_before"`
+	ChimeAfter        string            `json:"chime_after"`
+	Clip              string            `json:"clip"`
+	Orientation       string            `json:"orientation"`
+	DeviceSequence    []string          `json:"device_sequence"` // cast to each device in turn, starting with the first
+	DwellSeconds      int               `json:"dwell_seconds"`
+	DeviceMessages    map[string]string `json:"device_messages"` // device -> message cast there instead of message
+	CastMode          string            `json:"cast_mode"`
+	Muted             bool              `json:"muted"`
+	Scroll            bool              `json:"scroll"`
+	CastNow           bool              `json:"cast_now"` // cast before responding if the window has already started (single create only)
+}
+
+// errDatabase marks validation failures caused by the database rather than the request
+var errDatabase = errors.New("database error")
+
+// toNotification validates a create request and builds the pending notification
+func (req notificationRequest) toNotification() (Notification, error) {
+	// Parse the timestamps in any format the read path accepts
+	startTime, err := parseTimeInUTC(req.StartTime)
+	if err != nil {
+		return Notification{}, fmt.Errorf("Invalid start_time format: %v", err)
 	}
 
-	// Parse ISO 8601 timestamps
-	startTime, err := time.Parse(time.RFC3339, requestBody.StartTime)
+	endTime, err := parseTimeInUTC(req.EndTime)
 	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Invalid start_time format: %v", err)})
+		return Notification{}, fmt.Errorf("Invalid end_time format: %v", err)
 	}
-	
-	endTime, err := time.Parse(time.RFC3339, requestBody.EndTime)
+
+	// The type's preset fills in the styling, voice and repeats the request leaves out
+	notifType, preset, err := resolveNotificationType(req.Type)
 	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Invalid end_time format: %v", err)})
+		return Notification{}, err
 	}
+	req.applyPreset(preset)
 
 	// Default repeat count to 1 if not provided or invalid
-	repeatCount := requestBody.RepeatCount
+	repeatCount := req.RepeatCount
 	if repeatCount < 1 {
 		repeatCount = 1
 	}
-	
+	if repeatCount > maxRepeatCount {
+		return Notification{}, fmt.Errorf("repeat_count cannot exceed %d", maxRepeatCount)
+	}
+
+	// An explicit image list turns the cast into a slideshow; it can't be empty
+	if req.Images != nil {
+		if len(req.Images) == 0 {
+			return Notification{}, errors.New("Slideshow requires at least one image")
+		}
+		for _, ref := range req.Images {
+			if _, err := resolveSlideImage(ref, ""); err != nil {
+				return Notification{}, err
+			}
+		}
+	}
+
+	if req.EndingSoonMinutes < 0 {
+		return Notification{}, errors.New("ending_soon_minutes cannot be negative")
+	}
+
+	if req.Background != nil {
+		if err := req.Background.validate(); err != nil {
+			return Notification{}, err
+		}
+	}
+
+	if req.TextLayout != nil {
+		if err := req.TextLayout.validate(); err != nil {
+			return Notification{}, err
+		}
+	}
+
+	if req.Overlay != nil {
+		if err := req.Overlay.validate(); err != nil {
+			return Notification{}, err
+		}
+	}
+
+	if req.QRCode != nil {
+		req.QRCode.URL = strings.TrimSpace(req.QRCode.URL)
+		if err := req.QRCode.validate(); err != nil {
+			return Notification{}, err
+		}
+	}
+
+	if err := validateTheme(req.Theme); err != nil {
+		return Notification{}, err
+	}
+
+	if err := validateTTSVoice(req.Voice); err != nil {
+		return Notification{}, err
+	}
+
+	for _, chime := range []string{req.ChimeBefore, req.ChimeAfter} {
+		if _, err := resolveChime(chime, ""); err != nil {
+			return Notification{}, err
+		}
+	}
+
+	if err := validateOrientation(req.Orientation); err != nil {
+		return Notification{}, err
+	}
+
+	if err := validateMessage(req.Message); err != nil {
+		return Notification{}, err
+	}
+
+	req.MessageURL = strings.TrimSpace(req.MessageURL)
+	if req.MessageURL != "" {
+		if err := validateMessageURL(req.MessageURL); err != nil {
+			return Notification{}, err
+		}
+	}
+
+	req.AgendaFile = strings.TrimSpace(req.AgendaFile)
+	if req.AgendaFile != "" {
+		if req.MessageURL != "" {
+			return Notification{}, errors.New("agenda_file and message_url cannot be combined")
+		}
+		if err := validateAgendaFile(req.AgendaFile); err != nil {
+			return Notification{}, err
+		}
+	}
+
+	if req.Scroll && (len(req.Images) > 0 || req.Clip != "") {
+		return Notification{}, errors.New("scroll cannot be combined with images or clip")
+	}
+
+	if req.Clip != "" {
+		if len(req.Images) > 0 {
+			return Notification{}, errors.New("clip and images cannot be combined")
+		}
+		if _, err := resolveClip(req.Clip); err != nil {
+			return Notification{}, err
+		}
+	}
+
+	// A device sequence starts on its first device and moves on every dwell_seconds
+	dwellSeconds := 0
+	req.Device = strings.TrimSpace(req.Device)
+	if req.DeviceSequence != nil {
+		sequence, err := validateDeviceSequence(req.DeviceSequence)
+		if err != nil {
+			return Notification{}, err
+		}
+		if req.Device != "" && req.Device != sequence[0] {
+			return Notification{}, errors.New("device must match the first entry of device_sequence")
+		}
+		req.DeviceSequence = sequence
+		req.Device = sequence[0]
+
+		dwellSeconds = req.DwellSeconds
+		if dwellSeconds == 0 {
+			dwellSeconds = defaultDwellSeconds
+		}
+		if dwellSeconds < minDwellSeconds {
+			return Notification{}, fmt.Errorf("dwell_seconds must be at least %d", minDwellSeconds)
+		}
+	}
+
+	// Without a device, fall back to DEFAULT_DEVICE (the response shows which was used)
+	if req.Device == "" {
+		if defaultDevice == "" {
+			return Notification{}, errors.New("device is required (or set DEFAULT_DEVICE)")
+		}
+		req.Device = defaultDevice
+	}
+	if err := validateDeviceName(req.Device); err != nil {
+		return Notification{}, err
+	}
+
+	// A device given by IP skips discovery when casting, so check it answers now
+	if address, ok, err := parseDeviceAddress(req.Device); ok {
+		if err != nil {
+			return Notification{}, err
+		}
+		if err := checkDeviceReachable(address); err != nil {
+			return Notification{}, err
+		}
+		req.Device = address
+	}
+
+	// Devices can be given their own message, cast instead of the notification's
+	var deviceMessages DeviceMessages
+	if len(req.DeviceMessages) > 0 {
+		if req.MessageURL != "" || req.AgendaFile != "" {
+			return Notification{}, errors.New("device_messages cannot be combined with message_url or agenda_file")
+		}
+		deviceMessages, err = validateDeviceMessages(req.DeviceMessages, req.Device, req.DeviceSequence)
+		if err != nil {
+			return Notification{}, err
+		}
+	}
+
+	// How the notification is cast (defaults to auto: the image when muted)
+	castMode := req.CastMode
+	switch castMode {
+	case "":
+		castMode = castModeAuto
+	case castModeAuto, castModeVideo, castModeImage:
+	default:
+		return Notification{}, fmt.Errorf("Invalid cast_mode '%s' (expected auto, video or image)", castMode)
+	}
+	if appInstance.isSpeakerDevice(req.Device) {
+		if castMode == castModeImage {
+			return Notification{}, errors.New("cast_mode image needs a video device, not a speaker")
+		}
+		if req.Muted {
+			return Notification{}, errors.New("a muted notification has nothing to play on a speaker")
+		}
+	}
+
+	// What happens when the cast ends (defaults to simply stopping)
+	endAction := req.EndAction
+	endScreenSeconds := 0
+	followUpID := ""
+	switch endAction {
+	case "", endActionStop:
+		endAction = endActionStop
+	case endActionEndedScreen:
+		endScreenSeconds = req.EndScreenSeconds
+		if endScreenSeconds < 1 {
+			endScreenSeconds = defaultEndScreenSeconds
+		}
+		if endScreenSeconds > maxEndScreenSeconds {
+			return Notification{}, fmt.Errorf("end_screen_seconds cannot exceed %d", maxEndScreenSeconds)
+		}
+	case endActionFollowUp:
+		followUpID = req.FollowUpID
+		var exists int
+		err := appInstance.DB.QueryRow("SELECT COUNT(*) FROM notifications WHERE id = ? AND deleted_at IS NULL", followUpID).Scan(&exists)
+		if err != nil {
+			return Notification{}, fmt.Errorf("%w: %v", errDatabase, err)
+		}
+		if followUpID == "" || exists == 0 {
+			return Notification{}, errors.New("follow_up action requires an existing follow_up_id")
+		}
+	default:
+		return Notification{}, fmt.Errorf("Invalid end_action '%s' (expected stop, ended_screen or follow_up)", endAction)
+	}
+
+	slideInterval := req.SlideInterval
+	if len(req.Images) > 0 && slideInterval < 1 {
+		slideInterval = defaultSlideInterval
+	}
+
 	notif := Notification{
-		ID:          uuid.New().String(),
-		Message:     requestBody.Message,
-		Device:      requestBody.Device,
-		StartTime:   startTime,
-		EndTime:     endTime,
-		Status:      "pending",
-		RepeatCount: repeatCount,
+		ID:                uuid.New().String(),
+		Message:           req.Message,
+		MessageURL:        req.MessageURL,
+		AgendaFile:        req.AgendaFile,
+		Device:            req.Device,
+		StartTime:         startTime,
+		EndTime:           endTime,
+		Status:            "pending",
+		RepeatCount:       repeatCount,
+		Images:            req.Images,
+		SlideInterval:     slideInterval,
+		EndingSoonMinutes: req.EndingSoonMinutes,
+		EndAction:         endAction,
+		EndScreenSeconds:  endScreenSeconds,
+		FollowUpID:        followUpID,
+		Background:        req.Background,
+		Theme:             req.Theme,
+		TextLayout:        req.TextLayout,
+		Overlay:           req.Overlay,
+		QRCode:            req.QRCode,
+		Type:              notifType,
+		Voice:             req.Voice,
+		ChimeBefore:       req.ChimeBefore,
+		ChimeAfter:        req.ChimeAfter,
+		Clip:              req.Clip,
+		Orientation:       req.Orientation,
+		DeviceSequence:    req.DeviceSequence,
+		DwellSeconds:      dwellSeconds,
+		DeviceMessages:    deviceMessages,
+		CastMode:          castMode,
+		Muted:             req.Muted,
+		Scroll:            req.Scroll,
+	}
+
+	return notif, nil
+}
+
+func createNotification(c *fiber.Ctx) error {
+	var requestBody notificationRequest
+	if err := c.BodyParser(&requestBody); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	notif, err := requestBody.toNotification()
+	if errors.Is(err, errDatabase) {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := insertNotification(appInstance.Stmts.InsertNotification, notif); err != nil {
+		log.Printf("Failed to create notification: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create notification"})
+	}
+	recordAudit(auditActor(c), auditCreate, "notification", notif.ID, notificationAuditDetails(notif))
+
+	if requestBody.CastNow {
+		appInstance.castOnCreate(&notif)
+	} else {
+		startEagerGeneration(&notif, requestBody.Eager)
+	}
+	withMediaURLs(c, &notif, lanIP())
+	notif.SpeechText = speechText(notif)
+	return c.Status(201).JSON(notif)
+}
+
+// startEagerGeneration builds a new notification's video right away in eager mode (per
+// request, or EAGER_GENERATION by default) and sets its generation status accordingly
+func startEagerGeneration(notif *Notification, eagerOverride *bool) {
+	eager := eagerGeneration
+	if eagerOverride != nil {
+		eager = *eagerOverride
+	}
+	if eager {
+		go appInstance.generateVideoIfNeeded(*notif)
+		notif.GenerationStatus = "queued"
+	} else {
+		notif.GenerationStatus = "not_started"
+	}
+}
+
+// cast_status values reported by castOnCreate
+const (
+	castStatusStarted         = "started"           // the cast is playing
+	castStatusNotInWindow     = "not_in_window"     // the start time hasn't come yet; the scheduler casts it
+	castStatusWaitingForVideo = "waiting_for_video" // the video isn't ready; the scheduler casts it once it is
+	castStatusWaitingForSlot  = "waiting_for_slot"  // MAX_ACTIVE_CASTS is reached; the scheduler retries
+	castStatusFailed          = "failed"            // the cast couldn't start (see cast_error)
+)
+
+// castOnCreate handles cast_now: a notification whose window has already started (e.g.
+// "I'm already in a meeting") gets its video generated and its cast started before the
+// response, instead of on the next scheduler tick. Outside its window it is left to
+// the scheduler and generated like an eager notification.
+func (a *App) castOnCreate(notif *Notification) {
+	now := time.Now().UTC()
+	if now.Before(notif.StartTime) || !now.Before(notif.EndTime) {
+		notif.CastStatus = castStatusNotInWindow
+		eager := true
+		startEagerGeneration(notif, &eager)
+		return
+	}
+
+	a.generateVideoIfNeeded(*notif)
+	if _, err := os.Stat(a.notificationCastPath(*notif)); err != nil {
+		// Failed, or already being generated by the scheduler's pre-generation
+		log.Printf("Video not ready for cast_now notification %s, leaving it to the scheduler", notif.ID)
+		notif.CastStatus = castStatusWaitingForVideo
+		notif.GenerationStatus = a.generationStatus(*notif)
+		return
+	}
+	notif.GenerationStatus = "ready"
+
+	log.Printf("Starting cast for notification %s on create", notif.ID)
+	err := a.startCast(notif.ID, notif.Device, notif.Message)
+	switch {
+	case err == nil || a.currentCastDevice(notif.ID) != "":
+		// A scheduler tick in the meantime may have started it first
+		notif.CastStatus = castStatusStarted
+		notif.Status = "active"
+	case errors.Is(err, errCastLimitReached):
+		notif.CastStatus = castStatusWaitingForSlot
+	default:
+		log.Printf("Failed to start cast for notification %s on create: %v", notif.ID, err)
+		a.recordFailure(notif.ID, "cast", err)
+		a.markCastFailed(notif.ID, err)
+		notif.CastStatus = castStatusFailed
+		notif.CastError = err.Error()
+		var mediaErr *wrongMediaTypeError
+		if errors.As(err, &mediaErr) {
+			notif.Status = "failed"
+			notif.FailureReason = err.Error()
+		}
+	}
+}
+
+// maxBatchSize bounds how many notifications one batch request can create
+const maxBatchSize = 500
+
+// batchItemResult reports the outcome of one item of a batch create
+type batchItemResult struct {
+	Index        int           `json:"index"`
+	Error        string        `json:"error,omitempty"`
+	Notification *Notification `json:"notification,omitempty"`
+}
+
+// createNotificationsBatch validates every item first, then inserts them all in a single
+// transaction: either the whole batch is created or nothing is
+func createNotificationsBatch(c *fiber.Ctx) error {
+	var requests []notificationRequest
+	if err := c.BodyParser(&requests); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body (expected an array of notifications)"})
+	}
+	if len(requests) == 0 || len(requests) > maxBatchSize {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("A batch must contain between 1 and %d notifications", maxBatchSize)})
+	}
+
+	results := make([]batchItemResult, len(requests))
+	notifs := make([]Notification, len(requests))
+	valid := true
+	for i, req := range requests {
+		results[i].Index = i
+		if req.CastNow {
+			results[i].Error = "cast_now is only supported when creating a single notification"
+			valid = false
+			continue
+		}
+		notif, err := req.toNotification()
+		if errors.Is(err, errDatabase) {
+			return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+			valid = false
+			continue
+		}
+		notifs[i] = notif
+	}
+	if !valid {
+		return c.Status(400).JSON(fiber.Map{"error": "Batch rejected, no notifications were created", "results": results})
+	}
+
+	err := withTx(appInstance.DB, func(tx *sql.Tx) error {
+		insert := tx.Stmt(appInstance.Stmts.InsertNotification)
+		for i, notif := range notifs {
+			if err := insertNotification(insert, notif); err != nil {
+				results[i].Error = "Failed to create notification"
+				return fmt.Errorf("batch item %d: %w", i, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to create notification batch: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Batch rejected, no notifications were created", "results": results})
+	}
+
+	localIP := lanIP()
+	actor := auditActor(c)
+	for i := range notifs {
+		recordAudit(actor, auditCreate, "notification", notifs[i].ID, notificationAuditDetails(notifs[i]))
+		startEagerGeneration(&notifs[i], requests[i].Eager)
+		withMediaURLs(c, &notifs[i], localIP)
+		notifs[i].SpeechText = speechText(notifs[i])
+		results[i].Notification = &notifs[i]
+	}
+
+	log.Printf("Created %d notifications in a batch", len(notifs))
+	return c.Status(201).JSON(fiber.Map{"created": len(notifs), "results": results})
+}
+
+const insertNotificationSQL = `
+	INSERT INTO notifications (id, message, start_time, end_time, device, status, repeat_count, images, slide_interval, ending_soon_minutes, end_action, end_screen_seconds, follow_up_id, pinned, background, voice, chime_before, chime_after, clip, orientation, text_layout, overlay, type, device_sequence, dwell_seconds, message_url, cast_mode, muted, qr_code, agenda_file, theme, scroll, device_messages)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// insertNotification stores a new notification (times are converted to UTC for storage)
+// using the prepared insert, or tx.Stmt of it inside a transaction
+func insertNotification(stmt *sql.Stmt, notif Notification) error {
+	imagesJSON := ""
+	if len(notif.Images) > 0 {
+		encoded, err := json.Marshal(notif.Images)
+		if err != nil {
+			return fmt.Errorf("failed to encode images: %w", err)
+		}
+		imagesJSON = string(encoded)
+	}
+
+	backgroundJSON := ""
+	if notif.Background != nil {
+		encoded, err := json.Marshal(notif.Background)
+		if err != nil {
+			return fmt.Errorf("failed to encode background: %w", err)
+		}
+		backgroundJSON = string(encoded)
+	}
+
+	textLayoutJSON := ""
+	if notif.TextLayout != nil {
+		encoded, err := json.Marshal(notif.TextLayout)
+		if err != nil {
+			return fmt.Errorf("failed to encode text layout: %w", err)
+		}
+		textLayoutJSON = string(encoded)
+	}
+
+	overlayJSON := ""
+	if notif.Overlay != nil {
+		encoded, err := json.Marshal(notif.Overlay)
+		if err != nil {
+			return fmt.Errorf("failed to encode overlay: %w", err)
+		}
+		overlayJSON = string(encoded)
+	}
+
+	sequenceJSON := ""
+	if len(notif.DeviceSequence) > 0 {
+		encoded, err := json.Marshal(notif.DeviceSequence)
+		if err != nil {
+			return fmt.Errorf("failed to encode device sequence: %w", err)
+		}
+		sequenceJSON = string(encoded)
+	}
+
+	deviceMessagesJSON := ""
+	if len(notif.DeviceMessages) > 0 {
+		encoded, err := json.Marshal(notif.DeviceMessages)
+		if err != nil {
+			return fmt.Errorf("failed to encode device messages: %w", err)
+		}
+		deviceMessagesJSON = string(encoded)
+	}
+
+	qrCodeJSON := ""
+	if notif.QRCode != nil {
+		encoded, err := json.Marshal(notif.QRCode)
+		if err != nil {
+			return fmt.Errorf("failed to encode QR code: %w", err)
+		}
+		qrCodeJSON = string(encoded)
 	}
 
-	// Insert into database
 	// Convert to UTC for storage
 	startTimeUTC := notif.StartTime.UTC()
 	endTimeUTC := notif.EndTime.UTC()
-	
-	stmt, err := appInstance.DB.Prepare(`
-		INSERT INTO notifications (id, message, start_time, end_time, device, status, repeat_count)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
-	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(
+	_, err := stmt.Exec(
 		notif.ID,
 		notif.Message,
 		startTimeUTC.Format("2006-01-02 15:04:05"),
@@ -228,138 +1162,426 @@ func createNotification(c *fiber.Ctx) error {
 		notif.Device,
 		notif.Status,
 		notif.RepeatCount,
+		imagesJSON,
+		notif.SlideInterval,
+		notif.EndingSoonMinutes,
+		notif.EndAction,
+		notif.EndScreenSeconds,
+		notif.FollowUpID,
+		notif.Pinned,
+		backgroundJSON,
+		notif.Voice,
+		notif.ChimeBefore,
+		notif.ChimeAfter,
+		notif.Clip,
+		notif.Orientation,
+		textLayoutJSON,
+		overlayJSON,
+		notif.Type,
+		sequenceJSON,
+		notif.DwellSeconds,
+		notif.MessageURL,
+		notif.CastMode,
+		notif.Muted,
+		qrCodeJSON,
+		notif.AgendaFile,
+		notif.Theme,
+		notif.Scroll,
+		deviceMessagesJSON,
 	)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to create notification"})
-	}
-
-	return c.Status(201).JSON(notif)
+	return err
 }
 
 func getNotifications(c *fiber.Ctx) error {
-	rows, err := appInstance.DB.Query(`
-		SELECT id, message, start_time, end_time, device, status, repeat_count
+	where, args, err := notificationFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	rows, err := appInstance.ReadDB.Query(`
+		SELECT `+notificationColumns+`
 		FROM notifications
+		`+where+`
 		ORDER BY created_at DESC
-	`)
+	`, args...)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
 	}
 	defer rows.Close()
 
+	localIP := lanIP()
 	var notifications []Notification
 	for rows.Next() {
-		var notif Notification
-		var startTimeStr, endTimeStr string
-		err := rows.Scan(
-			&notif.ID,
-			&notif.Message,
-			&startTimeStr,
-			&endTimeStr,
-			&notif.Device,
-			&notif.Status,
-			&notif.RepeatCount,
-		)
+		notif, err := scanNotification(rows)
 		if err != nil {
+			log.Printf("Error reading notification: %v", err)
 			continue
 		}
+		withMediaURLs(c, &notif, localIP)
+		notif.GenerationStatus = appInstance.generationStatus(notif)
+		notif.CurrentDevice = appInstance.currentCastDevice(notif.ID)
+		notif.CastResolution = appInstance.castResolution(notif.ID)
+		notifications = append(notifications, notif)
+	}
+
+	return respond(c, notificationList{Notifications: notifications})
+}
+
+// notificationFilter builds the WHERE clause of the list filters shared by
+// GET /api/notifications and GET /api/export/ics (include_deleted, start_after,
+// start_before and q). Its errors describe the invalid parameter.
+func notificationFilter(c *fiber.Ctx) (string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
 
-		// Parse as UTC time (handles multiple formats)
-		startTime, err := parseTimeInUTC(startTimeStr)
+	// Soft-deleted notifications are hidden unless explicitly requested
+	if !c.QueryBool("include_deleted") {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+
+	// Optional start time range (e.g. for a calendar view of today or this week)
+	var startAfter, startBefore time.Time
+	if value := c.Query("start_after"); value != "" {
+		t, err := parseTimeInUTC(value)
 		if err != nil {
-			log.Printf("Error parsing start_time: %v", err)
-			continue
+			return "", nil, fmt.Errorf("Invalid start_after format: %v", err)
 		}
-		notif.StartTime = startTime
-		
-		endTime, err := parseTimeInUTC(endTimeStr)
+		startAfter = t
+		conditions = append(conditions, "start_time >= ?")
+		args = append(args, startAfter.Format("2006-01-02 15:04:05"))
+	}
+	if value := c.Query("start_before"); value != "" {
+		t, err := parseTimeInUTC(value)
 		if err != nil {
-			log.Printf("Error parsing end_time: %v", err)
-			continue
+			return "", nil, fmt.Errorf("Invalid start_before format: %v", err)
 		}
-		notif.EndTime = endTime
-		
-		notifications = append(notifications, notif)
+		startBefore = t
+		conditions = append(conditions, "start_time <= ?")
+		args = append(args, startBefore.Format("2006-01-02 15:04:05"))
+	}
+	if !startAfter.IsZero() && !startBefore.IsZero() && startAfter.After(startBefore) {
+		return "", nil, errors.New("start_after must not be later than start_before")
+	}
+
+	// Optional keyword search over the message (LIKE wildcards in the input are literal)
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		conditions = append(conditions, `message LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(q)+"%")
 	}
 
-	return c.JSON(notifications)
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	return where, args, nil
 }
 
 func getNotification(c *fiber.Ctx) error {
 	id := c.Params("id")
-	var notif Notification
-	var startTimeStr, endTimeStr string
 
-	err := appInstance.DB.QueryRow(`
-		SELECT id, message, start_time, end_time, device, status, repeat_count
-		FROM notifications
-		WHERE id = ?
-	`, id).Scan(
-		&notif.ID,
-		&notif.Message,
-		&startTimeStr,
-		&endTimeStr,
-		&notif.Device,
-		&notif.Status,
-		&notif.RepeatCount,
-	)
+	notif, err := scanNotification(appInstance.Stmts.GetLiveNotification.QueryRow(id))
 
 	if err == sql.ErrNoRows {
 		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
 	}
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Database error: %v", err)})
 	}
 
-	// Parse as UTC time (handles multiple formats)
-	startTime, err := parseTimeInUTC(startTimeStr)
+	withMediaURLs(c, &notif, lanIP())
+	notif.GenerationStatus = appInstance.generationStatus(notif)
+	notif.CurrentDevice = appInstance.currentCastDevice(notif.ID)
+	notif.CastResolution = appInstance.castResolution(notif.ID)
+	notif.GenerationMetrics = getGenerationMetrics(appInstance.DB, notif.ID)
+	return respond(c, notif)
+}
+
+func deleteNotification(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	// Soft-delete: the janitor purges the row once the undo window has passed. Nothing is
+	// stopped or removed for a notification that doesn't exist or is already deleted.
+	now := time.Now().UTC()
+	result, err := appInstance.DB.Exec(
+		"UPDATE notifications SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL",
+		now.Format("2006-01-02 15:04:05"), id,
+	)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Error parsing start_time: %v", err)})
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete notification"})
 	}
-	notif.StartTime = startTime
-	
-	endTime, err := parseTimeInUTC(endTimeStr)
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
+	}
+
+	// Stop cast if active
+	appInstance.stopCast(id, false)
+	removeNotificationMedia(id)
+	recordAudit(auditActor(c), auditDelete, "notification", id, nil)
+
+	return c.JSON(fiber.Map{
+		"message":       "Notification deleted",
+		"restore_until": now.Add(deleteUndoWindow),
+	})
+}
+
+// restoreNotification undoes a soft-delete while the undo window is still open
+func restoreNotification(c *fiber.Ctx) error {
+	id := c.Params("id")
+	cutoff := time.Now().UTC().Add(-deleteUndoWindow)
+
+	result, err := appInstance.DB.Exec(
+		"UPDATE notifications SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL AND deleted_at > ?",
+		id, cutoff.Format("2006-01-02 15:04:05"),
+	)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Error parsing end_time: %v", err)})
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to restore notification"})
 	}
-	notif.EndTime = endTime
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "No deleted notification to restore (undo window may have expired)"})
+	}
+	recordAudit(auditActor(c), auditRestore, "notification", id, nil)
 
-	return c.JSON(notif)
+	return c.JSON(fiber.Map{"message": "Notification restored"})
 }
 
-func deleteNotification(c *fiber.Ctx) error {
+// acknowledgeNotification records that the viewer saw the message (a read receipt).
+// Only the first acknowledgment is kept; repeating it returns the original time.
+func acknowledgeNotification(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	// Stop cast if active
-	appInstance.stopCast(id)
+	result, err := appInstance.DB.Exec(
+		"UPDATE notifications SET acknowledged_at = ? WHERE id = ? AND deleted_at IS NULL AND acknowledged_at IS NULL",
+		time.Now().UTC().Format("2006-01-02 15:04:05"), id,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to acknowledge notification"})
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		recordAudit(auditActor(c), auditUpdate, "notification", id, fiber.Map{"acknowledged": true})
+	}
 
-	// Delete from database
-	_, err := appInstance.DB.Exec("DELETE FROM notifications WHERE id = ?", id)
+	notif, err := scanNotification(appInstance.Stmts.GetLiveNotification.QueryRow(id))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
+	}
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete notification"})
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Database error: %v", err)})
+	}
+
+	return c.JSON(fiber.Map{"id": notif.ID, "acknowledged_at": notif.AcknowledgedAt})
+}
+
+// recastNotification moves a notification to another device without touching its
+// schedule. A running cast is moved right away with the media already generated;
+// otherwise the notification is cast to the new device when it starts.
+func recastNotification(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var requestBody struct {
+		Device string `json:"device"`
+	}
+	if err := c.BodyParser(&requestBody); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	device := strings.TrimSpace(requestBody.Device)
+	if device == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "device is required"})
+	}
+	if err := validateDeviceName(device); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	notif, err := scanNotification(appInstance.Stmts.GetLiveNotification.QueryRow(id))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Database error: %v", err)})
+	}
+	if notif.Status != "pending" && notif.Status != "active" {
+		return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("Only pending or active notifications can be recast (status is %s)", notif.Status)})
+	}
+	if len(notif.DeviceSequence) > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "Notification follows a device_sequence and can't be recast to a single device"})
+	}
+	if err := appInstance.checkDeviceExists(device); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	appInstance.CastMutex.RLock()
+	session, casting := appInstance.ActiveCasts[id]
+	appInstance.CastMutex.RUnlock()
+	if casting {
+		if appInstance.currentCastDevice(id) == device {
+			return c.Status(409).JSON(fiber.Map{"error": "Notification is already casting to " + device})
+		}
+		log.Printf("Recasting notification %s from %s to %s", id, session.Device, device)
+		if err := appInstance.moveCast(notif, session, device); err != nil {
+			if errors.Is(err, errStoppedDuringMove) {
+				return c.Status(409).JSON(fiber.Map{"error": "Notification was stopped while it was being recast"})
+			}
+			log.Printf("Failed to recast notification %s to %s: %v", id, device, err)
+			return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to cast to %s: %v", device, err)})
+		}
+	}
+
+	if _, err := appInstance.DB.Exec("UPDATE notifications SET device = ? WHERE id = ?", device, id); err != nil {
+		log.Printf("Failed to update device for notification %s: %v", id, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update notification device"})
+	}
+	recordAudit(auditActor(c), auditUpdate, "notification", id, fiber.Map{"device": device, "previous_device": notif.Device})
+	notif.Device = device
+
+	withMediaURLs(c, &notif, lanIP())
+	notif.GenerationStatus = appInstance.generationStatus(notif)
+	notif.CurrentDevice = appInstance.currentCastDevice(notif.ID)
+	notif.CastResolution = appInstance.castResolution(notif.ID)
+	return c.JSON(notif)
+}
+
+// cloneNotification creates a pending copy of a notification's content and style with
+// a new ID. The times are copied, shifted by shift_minutes, or replaced by start_time
+// (keeping the duration) and/or end_time. Nothing generated is copied: the clone's
+// media is generated on its own.
+func cloneNotification(c *fiber.Ctx) error {
+	var requestBody struct {
+		StartTime    string `json:"start_time"`
+		EndTime      string `json:"end_time"`
+		ShiftMinutes int    `json:"shift_minutes"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&requestBody); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+	if requestBody.ShiftMinutes != 0 && (requestBody.StartTime != "" || requestBody.EndTime != "") {
+		return c.Status(400).JSON(fiber.Map{"error": "shift_minutes cannot be combined with start_time or end_time"})
+	}
+
+	source, err := scanNotification(appInstance.Stmts.GetLiveNotification.QueryRow(c.Params("id")))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Database error: %v", err)})
+	}
+	if source.Pinned {
+		return c.Status(409).JSON(fiber.Map{"error": "Pinned statuses can't be cloned; start a new status instead"})
+	}
+
+	shift := time.Duration(requestBody.ShiftMinutes) * time.Minute
+	startTime, endTime := source.StartTime.Add(shift), source.EndTime.Add(shift)
+	if requestBody.StartTime != "" {
+		if startTime, err = parseTimeInUTC(requestBody.StartTime); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Invalid start_time format: %v", err)})
+		}
+		endTime = startTime.Add(source.EndTime.Sub(source.StartTime))
+	}
+	if requestBody.EndTime != "" {
+		if endTime, err = parseTimeInUTC(requestBody.EndTime); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Invalid end_time format: %v", err)})
+		}
+	}
+	if !endTime.After(startTime) {
+		return c.Status(400).JSON(fiber.Map{"error": "end_time must be after start_time"})
+	}
+
+	notif := Notification{
+		ID:                uuid.New().String(),
+		Message:           source.Message,
+		MessageURL:        source.MessageURL,
+		AgendaFile:        source.AgendaFile,
+		Device:            source.Device,
+		StartTime:         startTime,
+		EndTime:           endTime,
+		Status:            "pending",
+		RepeatCount:       source.RepeatCount,
+		Images:            source.Images,
+		SlideInterval:     source.SlideInterval,
+		EndingSoonMinutes: source.EndingSoonMinutes,
+		EndAction:         source.EndAction,
+		EndScreenSeconds:  source.EndScreenSeconds,
+		FollowUpID:        source.FollowUpID,
+		Background:        source.Background,
+		Theme:             source.Theme,
+		TextLayout:        source.TextLayout,
+		Overlay:           source.Overlay,
+		QRCode:            source.QRCode,
+		Type:              source.Type,
+		Voice:             source.Voice,
+		ChimeBefore:       source.ChimeBefore,
+		ChimeAfter:        source.ChimeAfter,
+		Clip:              source.Clip,
+		Orientation:       source.Orientation,
+		DeviceSequence:    source.DeviceSequence,
+		DwellSeconds:      source.DwellSeconds,
+		DeviceMessages:    source.DeviceMessages,
+		CastMode:          source.CastMode,
+		Muted:             source.Muted,
+		Scroll:            source.Scroll,
+	}
+
+	if err := insertNotification(appInstance.Stmts.InsertNotification, notif); err != nil {
+		log.Printf("Failed to clone notification %s: %v", source.ID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create notification"})
+	}
+	details := notificationAuditDetails(notif)
+	details["cloned_from"] = source.ID
+	recordAudit(auditActor(c), auditCreate, "notification", notif.ID, details)
+
+	startEagerGeneration(&notif, nil)
+	withMediaURLs(c, &notif, lanIP())
+	notif.SpeechText = speechText(notif)
+	return c.Status(201).JSON(notif)
+}
+
+// stopAllCasts is the panic button: it stops everything currently on screen
+func stopAllCasts(c *fiber.Ctx) error {
+	stopped := appInstance.stopAllCasts()
+	recordAudit(auditActor(c), auditCastStop, "casts", "", fiber.Map{"stopped": stopped})
+	return c.JSON(fiber.Map{"message": "All casts stopped", "stopped": stopped})
+}
+
+// cancelAllGenerations cancels every running and queued video generation
+func cancelAllGenerations(c *fiber.Ctx) error {
+	cancelled := appInstance.cancelAllGenerations()
+	recordAudit(auditActor(c), auditCancel, "generations", "", fiber.Map{"cancelled": cancelled})
+	return c.JSON(fiber.Map{"message": "All generations cancelled", "cancelled": cancelled})
+}
+
+// uploadImage stores an image for use in slideshows and returns its ID
+func uploadImage(c *fiber.Ctx) error {
+	fileHeader, err := c.FormFile("image")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing 'image' file"})
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
+	if ext != ".png" && ext != ".jpg" && ext != ".jpeg" {
+		return c.Status(400).JSON(fiber.Map{"error": "Only PNG and JPEG images are supported"})
+	}
+
+	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create uploads directory"})
+	}
+
+	imageID := uuid.New().String() + ext
+	if err := c.SaveFile(fileHeader, filepath.Join(uploadsDir, imageID)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save image"})
 	}
+	recordAudit(auditActor(c), auditCreate, "image", imageID, fiber.Map{"filename": fileHeader.Filename})
 
-	return c.JSON(fiber.Map{"message": "Notification deleted"})
+	return c.Status(201).JSON(fiber.Map{"id": imageID})
 }
 
+// serveNotificationContent serves the legacy HTML page (see legacypage.go)
 func serveNotificationContent(c *fiber.Ctx) error {
+	if !legacyPageEnabled {
+		return c.Status(404).SendString("Not found")
+	}
 	id := c.Params("id")
-	var notif Notification
-	var startTimeStr, endTimeStr string
 
-	err := appInstance.DB.QueryRow(`
-		SELECT id, message, start_time, end_time, device, status, repeat_count
-		FROM notifications
-		WHERE id = ?
-	`, id).Scan(
-		&notif.ID,
-		&notif.Message,
-		&startTimeStr,
-		&endTimeStr,
-		&notif.Device,
-		&notif.Status,
-		&notif.RepeatCount,
-	)
+	notif, err := scanNotification(appInstance.Stmts.GetNotification.QueryRow(id))
 
 	if err == sql.ErrNoRows {
 		return c.Status(404).SendString("Notification not found")
@@ -369,83 +1591,31 @@ func serveNotificationContent(c *fiber.Ctx) error {
 	}
 
 	// Return HTML content for Chromecast to display
-	html := fmt.Sprintf(`<!DOCTYPE html>
-<html>
-<head>
-	<meta charset="UTF-8">
-	<meta name="viewport" content="width=device-width, initial-scale=1.0">
-	<title>Notification</title>
-	<style>
-		body {
-			margin: 0;
-			padding: 0;
-			display: flex;
-			justify-content: center;
-			align-items: center;
-			height: 100vh;
-			background: linear-gradient(135deg, #667eea 0%%, #764ba2 100%%);
-			font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
-		}
-		.message {
-			text-align: center;
-			color: white;
-			font-size: 4em;
-			padding: 40px;
-			text-shadow: 2px 2px 4px rgba(0,0,0,0.3);
-			word-wrap: break-word;
-			max-width: 90%%;
-		}
-	</style>
-</head>
-<body>
-	<div class="message">%s</div>
-</body>
-</html>`, html.EscapeString(notif.Message))
-
-	c.Set("Content-Type", "text/html")
-	return c.SendString(html)
+	page, err := renderNotificationPage(notif)
+	if err != nil {
+		log.Printf("Error rendering notification page: %v", err)
+		return c.Status(500).SendString("Failed to render notification")
+	}
+
+	c.Set("Content-Type", "text/html; charset=utf-8")
+	return c.Send(page)
 }
 
 func serveNotificationImage(c *fiber.Ctx) error {
 	id := c.Params("id")
-	var notif Notification
-	var startTimeStr, endTimeStr string
 
-	err := appInstance.DB.QueryRow(`
-		SELECT id, message, start_time, end_time, device, status, repeat_count
-		FROM notifications
-		WHERE id = ?
-	`, id).Scan(
-		&notif.ID,
-		&notif.Message,
-		&startTimeStr,
-		&endTimeStr,
-		&notif.Device,
-		&notif.Status,
-		&notif.RepeatCount,
-	)
+	notif, err := scanNotification(appInstance.Stmts.GetNotification.QueryRow(id))
 
 	if err == sql.ErrNoRows {
 		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
 	}
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
-	}
-
-	// Parse times
-	startTime, err := parseTimeInUTC(startTimeStr)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to parse start_time"})
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Database error: %v", err)})
 	}
-	endTime, err := parseTimeInUTC(endTimeStr)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to parse end_time"})
-	}
-	notif.StartTime = startTime
-	notif.EndTime = endTime
 
-	// Generate or retrieve image with times
-	imagePath, err := generateNotificationImageSimple(notif.Message, notif.ID, notif.StartTime, notif.EndTime)
+	// Generate or retrieve image with times (a message_url is fetched, or served from its cache)
+	notif = withAgenda(withFetchedMessage(notif))
+	imagePath, err := generateNotificationImageSimple(filterMessage(notif.Message), notif.ID, notif.StartTime, notif.EndTime, notif.Background, notif.Theme, notif.Orientation, notif.TextLayout, appInstance.accentColor(notif), notif.QRCode, false)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to generate image: %v", err)})
 	}
@@ -483,10 +1653,10 @@ func serveNotificationVideo(c *fiber.Ctx) error {
 	filePath := c.Params("*") // The rest of the path (e.g., "playlist.m3u8" or "segment001.ts")
 	
 	// Build the full path to the requested file
-	requestedPath := filepath.Join("./data/chunks", id, filePath)
+	requestedPath := filepath.Join(chunksDir, id, filePath)
 	
 	// Security check: ensure we're only serving files from the notification's directory
-	if !strings.HasPrefix(requestedPath, filepath.Join("./data/chunks", id)) {
+	if !strings.HasPrefix(requestedPath, filepath.Join(chunksDir, id)) {
 		return c.Status(403).JSON(fiber.Map{"error": "Invalid path"})
 	}
 	
@@ -494,80 +1664,36 @@ func serveNotificationVideo(c *fiber.Ctx) error {
 	if filePath == "playlist.m3u8" || filePath == "" {
 		// If no file specified or it's the playlist, we might need to generate it
 		// First check if directory exists
-		videoDir := filepath.Join("./data/chunks", id)
+		videoDir := filepath.Join(chunksDir, id)
 		playlistPath := filepath.Join(videoDir, "playlist.m3u8")
 		
-		if _, err := os.Stat(playlistPath); err != nil {
-			// Playlist doesn't exist, need to generate video
-			var notif Notification
-			var startTimeStr, endTimeStr string
-			
-			err := appInstance.DB.QueryRow(`
-				SELECT id, message, start_time, end_time, device, status, repeat_count
-				FROM notifications
-				WHERE id = ?
-			`, id).Scan(
-				&notif.ID,
-				&notif.Message,
-				&startTimeStr,
-				&endTimeStr,
-			&notif.Device,
-			&notif.Status,
-			&notif.RepeatCount,
-		)
-			
-			if err == sql.ErrNoRows {
-				return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
-			}
-			if err != nil {
-				return c.Status(500).JSON(fiber.Map{"error": "Database error"})
-			}
-			
-			// Calculate video duration from start and end times
-			startTime, err := parseTimeInUTC(startTimeStr)
-			if err != nil {
-				return c.Status(500).JSON(fiber.Map{"error": "Failed to parse start_time"})
-			}
-			endTime, err := parseTimeInUTC(endTimeStr)
-			if err != nil {
-				return c.Status(500).JSON(fiber.Map{"error": "Failed to parse end_time"})
-			}
-			notif.StartTime = startTime
-			notif.EndTime = endTime
-			
-			// Generate image first with times
-			imagePath, err := generateNotificationImageSimple(notif.Message, notif.ID, notif.StartTime, notif.EndTime)
-			if err != nil {
-				return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to generate image: %v", err)})
-			}
-			
-			duration := int(endTime.Sub(startTime).Seconds())
-			if duration < 1 {
-				duration = 10
-			}
-			
-			// Convert end time to EST for TTS
-			estLocation, err := time.LoadLocation("America/New_York")
-			if err != nil {
-				log.Printf("Warning: Could not load EST timezone for TTS, using UTC: %v", err)
-				estLocation = time.UTC
-			}
-			endTimeEST := notif.EndTime.In(estLocation)
-			
-			// Generate TTS audio: "Michel is in the meeting until [end_time]"
-			ttsText := fmt.Sprintf("Hi Dan, this message is to tell you that Michel is in a meeting until %s and he had this message for you: %s", endTimeEST.Format("3:04 PM"), notif.Message)
-			audioPath, err := generateTTSAudio(ttsText, notif.ID, notif.RepeatCount)
-			if err != nil {
-				log.Printf("Failed to generate TTS audio for notification %s: %v (continuing without audio)", notif.ID, err)
-				audioPath = "" // Continue without audio if TTS fails
-			}
-			
-			// Generate HLS video with audio
-			_, err = generateNotificationVideo(imagePath, notif.ID, duration, audioPath)
-			if err != nil {
-				log.Printf("Error generating video: %v", err)
-				return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to generate video: %v", err)})
+		_, statErr := os.Stat(playlistPath)
+		notif, err := scanNotification(appInstance.Stmts.GetNotification.QueryRow(id))
+		stale, generating := false, false
+		switch {
+		case err == sql.ErrNoRows && statErr == nil:
+			// Not a notification (e.g. an "_ended" clip): serve what was generated
+		case err == sql.ErrNoRows:
+			return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Database error: %v", err)})
+		default:
+			// Regenerate if the playlist is missing, or was generated before the notification
+			// was edited (an outdated playlist is still served while a generation runs, and
+			// to the receiver of a running cast until it ends)
+			generating = appInstance.generationInProgress(notif.ID)
+			stale = statErr != nil || (!mediaUpToDate(notif) && !generating && !appInstance.isCasting(notif.ID))
+		}
+
+		if stale {
+			// Generating can take longer than the Chromecast waits for a playlist, so it
+			// runs in the background and the device is told to retry instead of blocking
+			if !generating {
+				go appInstance.generateVideoIfNeeded(notif)
 			}
+			log.Printf("Video for notification %s is being generated, returning 503", notif.ID)
+			c.Set("Retry-After", strconv.Itoa(generationRetryAfterSeconds))
+			return c.Status(503).JSON(fiber.Map{"error": "Video is being generated, retry shortly", "generation_status": "generating"})
 		}
 		
 		// Serve the playlist
@@ -583,8 +1709,12 @@ func serveNotificationVideo(c *fiber.Ctx) error {
 		c.Set("Access-Control-Allow-Origin", "*")
 		c.Set("Access-Control-Allow-Methods", "GET, POST, DELETE, PUT, OPTIONS, HEAD")
 		c.Set("Access-Control-Allow-Headers", "Authorization, Origin, X-Requested-With, Content-Type, Accept, ngrok-skip-browser-warning")
-	} else if strings.HasSuffix(filePath, ".ts") {
-		c.Set("Content-Type", "video/mp2t")
+	} else if isHLSSegment(filePath) || filePath == "init.mp4" {
+		contentType := "video/mp4" // fragmented MP4 segments (HLS_SEGMENT_MODE=fmp4) and their init segment
+		if strings.HasSuffix(filePath, ".ts") {
+			contentType = "video/mp2t"
+		}
+		c.Set("Content-Type", contentType)
 		c.Set("Cache-Control", "public, max-age=3600")
 		c.Set("Access-Control-Allow-Origin", "*")
 		c.Set("Access-Control-Allow-Methods", "GET, POST, DELETE, PUT, OPTIONS, HEAD")
@@ -592,10 +1722,24 @@ func serveNotificationVideo(c *fiber.Ctx) error {
 	}
 	
 	// Serve the file
-	if _, err := os.Stat(requestedPath); err != nil {
+	info, err := os.Stat(requestedPath)
+	if err != nil || info.IsDir() {
 		return c.Status(404).JSON(fiber.Map{"error": "File not found"})
 	}
+	if isHLSSegment(filePath) {
+		return sendSegment(c, requestedPath, info.Size())
+	}
 	
 	return c.SendFile(requestedPath)
 }
 
+// sendSegment streams a video segment from disk with its exact Content-Length, so a
+// large segment is never held in memory; the file is closed once it has been sent
+func sendSegment(c *fiber.Ctx, path string, size int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "File not found"})
+	}
+	return c.SendStream(file, int(size))
+}
+
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParseTimeInUTC(t *testing.T) {
+	want := time.Date(2024, time.March, 10, 14, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"RFC3339 with an offset", "2024-03-10T10:30:00-04:00", want, false},
+		{"RFC3339 in UTC", "2024-03-10T14:30:00Z", want, false},
+		{"space separated, no zone", "2024-03-10 14:30:00", want, false},
+		{"not a time", "10/03/2024 2:30 PM", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTimeInUTC(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseTimeInUTC(%q) = %v, want an error", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTimeInUTC(%q): %v", tt.input, err)
+			}
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("parseTimeInUTC(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// Serving a large segment streams it from disk: the memory allocated while it is served
+// stays far below its size, and the client gets every byte with the exact Content-Length
+func TestSendSegmentStreamsLargeFile(t *testing.T) {
+	const size = 64 << 20
+	content := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	path := filepath.Join(t.TempDir(), "0.ts")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("writing the segment: %v", err)
+	}
+	want := sha256.Sum256(content)
+	content = nil
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/segment", func(c *fiber.Ctx) error {
+		return sendSegment(c, path, size)
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/segment")
+	if err != nil {
+		t.Fatalf("requesting the segment: %v", err)
+	}
+	defer resp.Body.Close()
+	hash := sha256.New()
+	n, err := io.Copy(hash, resp.Body)
+	if err != nil {
+		t.Fatalf("reading the response: %v", err)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("status %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Length"); got != strconv.Itoa(size) {
+		t.Errorf("Content-Length %s, want %d", got, size)
+	}
+	if n != size || !bytes.Equal(hash.Sum(nil), want[:]) {
+		t.Errorf("got %d bytes that differ from the %d byte segment", n, size)
+	}
+	// Everything allocated by the server and the client while serving, freed or not
+	allocated := after.TotalAlloc - before.TotalAlloc
+	t.Logf("serving %d MiB allocated %d KiB", size>>20, allocated>>10)
+	if allocated > size/8 {
+		t.Errorf("serving the segment allocated %d bytes, want under %d (the segment is %d)", allocated, size/8, size)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Messages support a small markup subset, rendered on the image and stripped from
+// the spoken text:
+//   - a line break starts a new line
+//   - a line starting with "- " or "* " is a bullet point
+//   - **text** is bold; an unmatched ** is shown as is
+//
+// Anything else (HTML, other markdown) is plain text.
+
+// bulletPrefix starts a bullet point line on the image
+const bulletPrefix = "• "
+
+// textRun is a piece of a line drawn in one weight
+type textRun struct {
+	Text string
+	Bold bool
+}
+
+// markupParagraph is one line of the message as typed, split into words
+type markupParagraph struct {
+	Bullet bool
+	Words  []textRun
+}
+
+// parseMessageMarkup splits a message into paragraphs of words, each word marked bold
+// or not. Empty lines are dropped.
+func parseMessageMarkup(message string) []markupParagraph {
+	var paragraphs []markupParagraph
+	for _, line := range strings.Split(strings.ReplaceAll(message, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		var p markupParagraph
+		if strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* ") {
+			p.Bullet = true
+			line = strings.TrimSpace(line[2:])
+		}
+
+		// Segments between ** markers alternate plain and bold; with an odd number of
+		// markers the last one has no partner and stays literal
+		segments := strings.Split(line, "**")
+		if len(segments)%2 == 0 {
+			last := len(segments) - 1
+			segments = append(segments[:last-1], segments[last-1]+"**"+segments[last])
+		}
+		for i, segment := range segments {
+			for _, word := range strings.Fields(segment) {
+				p.Words = append(p.Words, textRun{Text: word, Bold: i%2 == 1})
+			}
+		}
+
+		if len(p.Words) > 0 {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
+
+// hasBoldMarkup reports whether any word is marked bold. Messages without bold
+// markup are drawn entirely in the bold face, as before markup was supported.
+func hasBoldMarkup(paragraphs []markupParagraph) bool {
+	for _, p := range paragraphs {
+		for _, word := range p.Words {
+			if word.Bold {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// richLine is one drawn line of the message
+type richLine []textRun
+
+// String is the line's text without weights
+func (l richLine) String() string {
+	var b strings.Builder
+	for _, run := range l {
+		b.WriteString(run.Text)
+	}
+	return b.String()
+}
+
+// appendWord adds a word to the line, merging it into the last run when the weight matches
+func (l richLine) appendWord(word textRun, space bool) richLine {
+	text := word.Text
+	if space {
+		text = " " + text
+	}
+	if n := len(l); n > 0 && l[n-1].Bold == word.Bold {
+		l[n-1].Text += text
+		return l
+	}
+	return append(l, textRun{Text: text, Bold: word.Bold})
+}
+
+// wrapRichText wraps paragraphs into lines of at most maxWidth characters. Bullet
+// points get a bullet and their continuation lines are indented.
+func wrapRichText(paragraphs []markupParagraph, maxWidth int) []richLine {
+	var lines []richLine
+	for _, p := range paragraphs {
+		prefix := ""
+		if p.Bullet {
+			prefix = bulletPrefix
+		}
+		indent := strings.Repeat(" ", utf8.RuneCountInString(prefix))
+
+		line := richLine{{Text: prefix}}
+		width := utf8.RuneCountInString(prefix)
+		empty := true
+		for _, word := range p.Words {
+			wordWidth := utf8.RuneCountInString(word.Text)
+			if !empty && width+1+wordWidth > maxWidth {
+				lines = append(lines, line)
+				line, width, empty = richLine{{Text: indent}}, len(indent), true
+			}
+			line = line.appendWord(word, !empty)
+			if !empty {
+				width++
+			}
+			width += wordWidth
+			empty = false
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return []richLine{{}}
+	}
+	return lines
+}
+
+// truncateRichLines keeps the first maxLines lines, ending the last one with "…" if
+// any were dropped
+func truncateRichLines(lines []richLine, maxLines, maxWidth int) []richLine {
+	if len(lines) <= maxLines {
+		return lines
+	}
+
+	lines = lines[:maxLines]
+	last := append(richLine{}, lines[maxLines-1]...)
+	// Drop characters from the end until the ellipsis fits
+	for utf8.RuneCountInString(last.String()) > maxWidth-1 && len(last) > 0 {
+		runes := []rune(last[len(last)-1].Text)
+		if len(runes) <= 1 {
+			last = last[:len(last)-1]
+			continue
+		}
+		last[len(last)-1].Text = string(runes[:len(runes)-1])
+	}
+	if len(last) == 0 {
+		last = richLine{{}}
+	}
+	end := &last[len(last)-1]
+	end.Text = strings.TrimRight(end.Text, " .,;:") + "…"
+	lines[maxLines-1] = last
+	return lines
+}
+
+// plainMessage is a message with its markup removed, for TTS and other plain text
+// uses. Lines are joined into sentences so the speech pauses between them.
+func plainMessage(message string) string {
+	paragraphs := parseMessageMarkup(message)
+	sentences := make([]string, len(paragraphs))
+	for i, p := range paragraphs {
+		words := make([]string, len(p.Words))
+		for j, word := range p.Words {
+			words[j] = word.Text
+		}
+		sentences[i] = strings.Join(words, " ")
+		if i < len(paragraphs)-1 && !strings.ContainsAny(sentences[i][len(sentences[i])-1:], ".!?:;,") {
+			sentences[i] += "."
+		}
+	}
+	return strings.Join(sentences, " ")
+}
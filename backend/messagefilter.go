@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Message filter actions (MESSAGE_FILTER_ACTION): "mask" replaces blocked words with
+// asterisks when the message is rendered and spoken; "reject" also refuses messages
+// containing them when notifications are created
+const (
+	filterActionMask   = "mask"
+	filterActionReject = "reject"
+)
+
+var (
+	// blockedWordsList and blockedWordsFile configure the blocked words: a comma-separated
+	// list (BLOCKED_WORDS) and/or a file with one word or phrase per line (BLOCKED_WORDS_FILE)
+	blockedWordsList    = envString("BLOCKED_WORDS", "")
+	blockedWordsFile    = envString("BLOCKED_WORDS_FILE", "")
+	messageFilterAction = envString("MESSAGE_FILTER_ACTION", filterActionMask)
+	// maxMessageLength caps messages at creation, in characters (MAX_MESSAGE_LENGTH, 0 = no limit)
+	maxMessageLength = max(envInt("MAX_MESSAGE_LENGTH", 0), 0)
+)
+
+// blockedWordsPattern matches any blocked word, case-insensitively and as whole words
+// (nil when no words are configured)
+var blockedWordsPattern *regexp.Regexp
+
+var errBlockedWords = errors.New("message contains blocked words")
+
+// blockedWordsPatternString identifies the blocked words for the media hash, so
+// changing the list regenerates masked videos
+func blockedWordsPatternString() string {
+	if blockedWordsPattern == nil {
+		return ""
+	}
+	return blockedWordsPattern.String()
+}
+
+// initMessageFilter loads the blocked words and checks the filter action
+func initMessageFilter() {
+	if messageFilterAction != filterActionMask && messageFilterAction != filterActionReject {
+		log.Printf("Warning: Unknown MESSAGE_FILTER_ACTION '%s', using %s", messageFilterAction, filterActionMask)
+		messageFilterAction = filterActionMask
+	}
+
+	words := strings.Split(blockedWordsList, ",")
+	if blockedWordsFile != "" {
+		fileWords, err := readBlockedWordsFile(blockedWordsFile)
+		if err != nil {
+			log.Printf("Warning: Could not read BLOCKED_WORDS_FILE: %v", err)
+		}
+		words = append(words, fileWords...)
+	}
+
+	var alternatives []string
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			alternatives = append(alternatives, regexp.QuoteMeta(word))
+		}
+	}
+	if len(alternatives) == 0 {
+		return
+	}
+	blockedWordsPattern = regexp.MustCompile(`(?i)\b(?:` + strings.Join(alternatives, "|") + `)\b`)
+	log.Printf("Message filter: %d blocked word(s), action %s", len(alternatives), messageFilterAction)
+}
+
+// readBlockedWordsFile reads one blocked word or phrase per line; empty lines and
+// lines starting with # are skipped
+func readBlockedWordsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words, scanner.Err()
+}
+
+// validateMessage checks a message being created against MAX_MESSAGE_LENGTH and, with
+// the reject action, the blocked words
+func validateMessage(message string) error {
+	if maxMessageLength > 0 && utf8.RuneCountInString(message) > maxMessageLength {
+		return fmt.Errorf("message cannot exceed %d characters", maxMessageLength)
+	}
+	if messageFilterAction == filterActionReject && blockedWordsPattern != nil && blockedWordsPattern.MatchString(message) {
+		return errBlockedWords
+	}
+	return nil
+}
+
+// filterMessage prepares a message for display: invisible characters are removed
+// (see sanitizeMessage) and blocked words are masked with #, which unlike * isn't
+// markup. It applies with either action, since a message fetched from a message_url
+// isn't checked at creation.
+func filterMessage(message string) string {
+	message = sanitizeMessage(message)
+	if blockedWordsPattern != nil {
+		message = blockedWordsPattern.ReplaceAllStringFunc(message, func(word string) string {
+			return strings.Repeat("#", utf8.RuneCountInString(word))
+		})
+	}
+	return message
+}
+
+// filterSpeech prepares a message for TTS like filterMessage, but leaves blocked words
+// out instead of having a mask read aloud
+func filterSpeech(message string) string {
+	message = sanitizeMessage(message)
+	if blockedWordsPattern != nil {
+		message = blockedWordsPattern.ReplaceAllString(message, "")
+	}
+	return message
+}
+
+// sanitizeMessage removes control and invisible formatting characters (zero-width,
+// bidi overrides) from a message, keeping line breaks for markup
+func sanitizeMessage(message string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n':
+			return r
+		case r == '\t':
+			return ' '
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		}
+		return r
+	}, message)
+}
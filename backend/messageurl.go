@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// messageURLTimeout bounds a message_url fetch
+	messageURLTimeout = envDuration("MESSAGE_URL_TIMEOUT", 5*time.Second)
+	// messageURLCacheTTL is how long a fetched message is reused for the same URL
+	messageURLCacheTTL = envDuration("MESSAGE_URL_CACHE_TTL", 1*time.Minute)
+	// messageURLFallback is shown when the fetch fails and the notification has no message
+	messageURLFallback = envString("MESSAGE_URL_FALLBACK", "No update available")
+	// messageURLAllowedNetworks are the loopback, private or link-local networks URLs
+	// given by API clients may still be fetched from, e.g. a dashboard on the LAN
+	messageURLAllowedNetworks = parseAllowedNetworks(envString("MESSAGE_URL_ALLOWED_NETWORKS", ""))
+)
+
+const (
+	// maxFetchedMessageBytes is how much of the response is read
+	maxFetchedMessageBytes = 4096
+	// maxFetchedMessageLength caps the sanitized message, in characters
+	maxFetchedMessageLength = 500
+)
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// fetchedMessage is a cached message_url response
+type fetchedMessage struct {
+	Text      string
+	FetchedAt time.Time
+}
+
+var (
+	fetchedMessages     = make(map[string]fetchedMessage)
+	fetchedMessageMutex sync.Mutex
+)
+
+var messageURLClient = newFetchClient(messageURLTimeout)
+
+// newFetchClient returns an HTTP client for URLs given by API clients. It connects only
+// to public addresses (see checkFetchAddress), including after redirects, and never
+// through a proxy, which would hide the destination from the check.
+func newFetchClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: checkFetchAddress}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+}
+
+// checkFetchAddress is the dialer hook of newFetchClient: it refuses loopback, private,
+// link-local (which includes cloud metadata endpoints) and unspecified addresses, unless
+// MESSAGE_URL_ALLOWED_NETWORKS allows them. It sees the resolved address, so a public
+// name pointing to a private address is refused too.
+func checkFetchAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("refusing to connect to %s", address)
+	}
+	for _, allowed := range messageURLAllowedNetworks {
+		if allowed.Contains(ip) {
+			return nil
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("refusing to connect to non-public address %s (see MESSAGE_URL_ALLOWED_NETWORKS)", ip)
+	}
+	return nil
+}
+
+// parseAllowedNetworks parses MESSAGE_URL_ALLOWED_NETWORKS: comma-separated CIDRs or
+// single addresses
+func parseAllowedNetworks(value string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Warning: Ignoring MESSAGE_URL_ALLOWED_NETWORKS entry '%s' (expected a CIDR or an address)", entry)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// validateMessageURL checks a message_url is an absolute http(s) URL
+func validateMessageURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return fmt.Errorf("invalid message_url '%s' (expected an http or https URL)", raw)
+	}
+	return nil
+}
+
+// fetchMessage returns the sanitized text served at a message_url, reusing a
+// response fetched within messageURLCacheTTL. Failures aren't cached.
+func fetchMessage(messageURL string) (string, error) {
+	fetchedMessageMutex.Lock()
+	cached, ok := fetchedMessages[messageURL]
+	fetchedMessageMutex.Unlock()
+	if ok && time.Since(cached.FetchedAt) < messageURLCacheTTL {
+		return cached.Text, nil
+	}
+
+	resp, err := messageURLClient.Get(messageURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch message_url: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("message_url returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchedMessageBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to read message_url: %w", err)
+	}
+	text := sanitizeFetchedMessage(body)
+	if text == "" {
+		return "", errors.New("message_url returned an empty message")
+	}
+
+	fetchedMessageMutex.Lock()
+	fetchedMessages[messageURL] = fetchedMessage{Text: text, FetchedAt: time.Now()}
+	fetchedMessageMutex.Unlock()
+	return text, nil
+}
+
+// sanitizeFetchedMessage turns a response body into a single line of plain text:
+// markup and control characters are dropped, whitespace is collapsed and the
+// result is cut to maxFetchedMessageLength characters
+func sanitizeFetchedMessage(body []byte) string {
+	text := strings.ToValidUTF8(string(body), "")
+	text = html.UnescapeString(htmlTagPattern.ReplaceAllString(text, " "))
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, text)
+	text = strings.Join(strings.Fields(text), " ")
+
+	if utf8.RuneCountInString(text) > maxFetchedMessageLength {
+		text = string([]rune(text)[:maxFetchedMessageLength-1]) + "…"
+	}
+	return text
+}
+
+// withFetchedMessage replaces a message_url notification's message with the fetched
+// text for rendering. On failure the notification's own message (or
+// MESSAGE_URL_FALLBACK) is kept; either way message_url_error records the outcome.
+func withFetchedMessage(notif Notification) Notification {
+	if notif.MessageURL == "" {
+		return notif
+	}
+
+	text, err := fetchMessage(notif.MessageURL)
+	fetchError := ""
+	if err != nil {
+		log.Printf("Using fallback message for notification %s: %v", notif.ID, err)
+		appInstance.recordFailure(notif.ID, "message_url", err)
+		fetchError = err.Error()
+		text = notif.Message
+		if text == "" {
+			text = messageURLFallback
+		}
+	}
+
+	if fetchError != notif.MessageURLError {
+		if _, dbErr := appInstance.DB.Exec("UPDATE notifications SET message_url_error = ? WHERE id = ?", fetchError, notif.ID); dbErr != nil {
+			log.Printf("Failed to record message_url outcome for notification %s: %v", notif.ID, dbErr)
+		}
+	}
+
+	notif.Message = text
+	notif.MessageURLError = fetchError
+	return notif
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migration is one schema change, applied once in order of Version. Every schema
+// change (a new table, an added column) is a new migration appended to migrations;
+// applied migrations are never edited.
+type migration struct {
+	Version int
+	Name    string
+	Apply   func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{1, "initial schema", migrateInitialSchema},
+	{2, "device volume", migrateDeviceVolume},
+	{3, "notification QR code", migrateQRCode},
+	{4, "audit log", migrateAuditLog},
+	{5, "notification agenda file", migrateAgendaFile},
+	{6, "notification theme", migrateTheme},
+	{7, "notification scroll", migrateScroll},
+	{8, "notification device messages", migrateDeviceMessages},
+}
+
+// runMigrations applies the migrations newer than the database's schema version, each
+// in its own transaction, recording them in schema_migrations
+func runMigrations(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		err := withTx(db, func(tx *sql.Tx) error {
+			if err := m.Apply(tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Applied database migration %d: %s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// migrateInitialSchema creates the schema as it was before migrations were tracked.
+// Databases created before then already have the tables, possibly without the columns
+// added since, so those are added if missing.
+func migrateInitialSchema(tx *sql.Tx) error {
+	// Create table
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS notifications (
+		id TEXT PRIMARY KEY,
+		message TEXT NOT NULL,
+		start_time DATETIME NOT NULL,
+		end_time DATETIME NOT NULL,
+		device TEXT NOT NULL,
+		status TEXT DEFAULT 'pending',
+		repeat_count INTEGER DEFAULT 1,
+		images TEXT DEFAULT '',
+		slide_interval INTEGER DEFAULT 0,
+		deleted_at DATETIME,
+		ending_soon_minutes INTEGER DEFAULT 0,
+		end_action TEXT DEFAULT 'stop',
+		end_screen_seconds INTEGER DEFAULT 0,
+		follow_up_id TEXT DEFAULT '',
+		pinned INTEGER DEFAULT 0,
+		background TEXT DEFAULT '',
+		voice TEXT DEFAULT '',
+		chime_before TEXT DEFAULT '',
+		chime_after TEXT DEFAULT '',
+		clip TEXT DEFAULT '',
+		media_hash TEXT DEFAULT '',
+		orientation TEXT DEFAULT '',
+		failure_reason TEXT DEFAULT '',
+		acknowledged_at DATETIME,
+		text_layout TEXT DEFAULT '',
+		overlay TEXT DEFAULT '',
+		type TEXT DEFAULT 'meeting',
+		device_sequence TEXT DEFAULT '',
+		dwell_seconds INTEGER DEFAULT 0,
+		message_url TEXT DEFAULT '',
+		message_url_error TEXT DEFAULT '',
+		cast_mode TEXT DEFAULT 'auto',
+		muted INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := tx.Exec(createTableSQL); err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+
+	// Characters sent to Google TTS per month (persists the usage counter across restarts)
+	createUsageTableSQL := `
+	CREATE TABLE IF NOT EXISTS tts_usage (
+		month TEXT PRIMARY KEY,
+		characters INTEGER NOT NULL DEFAULT 0
+	);`
+
+	if _, err := tx.Exec(createUsageTableSQL); err != nil {
+		return fmt.Errorf("failed to create tts_usage table: %w", err)
+	}
+
+	// User-chosen display names for discovered devices (keyed by device URL/UUID)
+	createAliasesTableSQL := `
+	CREATE TABLE IF NOT EXISTS device_aliases (
+		device_id TEXT PRIMARY KEY,
+		alias TEXT NOT NULL UNIQUE,
+		color TEXT DEFAULT ''
+	);`
+
+	if _, err := tx.Exec(createAliasesTableSQL); err != nil {
+		return fmt.Errorf("failed to create device_aliases table: %w", err)
+	}
+
+	// How long each generation step took, per notification (see recordGenerationMetrics)
+	createMetricsTableSQL := `
+	CREATE TABLE IF NOT EXISTS generation_metrics (
+		notification_id TEXT PRIMARY KEY,
+		image_ms INTEGER NOT NULL DEFAULT 0,
+		tts_ms INTEGER NOT NULL DEFAULT 0,
+		video_ms INTEGER NOT NULL DEFAULT 0,
+		total_ms INTEGER NOT NULL DEFAULT 0,
+		generated_at DATETIME NOT NULL
+	);`
+
+	if _, err := tx.Exec(createMetricsTableSQL); err != nil {
+		return fmt.Errorf("failed to create generation_metrics table: %w", err)
+	}
+
+	// Reusable notification presets (see templates.go)
+	createTemplatesTableSQL := `
+	CREATE TABLE IF NOT EXISTS templates (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		message TEXT NOT NULL,
+		device TEXT NOT NULL,
+		repeat_count INTEGER DEFAULT 1,
+		voice TEXT DEFAULT '',
+		background TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := tx.Exec(createTemplatesTableSQL); err != nil {
+		return fmt.Errorf("failed to create templates table: %w", err)
+	}
+
+	// Columns added after the initial schema (older databases won't have them)
+	addedColumns := []struct{ name, definition string }{
+		{"images", "TEXT DEFAULT ''"},
+		{"slide_interval", "INTEGER DEFAULT 0"},
+		{"deleted_at", "DATETIME"},
+		{"ending_soon_minutes", "INTEGER DEFAULT 0"},
+		{"end_action", "TEXT DEFAULT 'stop'"},
+		{"end_screen_seconds", "INTEGER DEFAULT 0"},
+		{"follow_up_id", "TEXT DEFAULT ''"},
+		{"pinned", "INTEGER DEFAULT 0"},
+		{"background", "TEXT DEFAULT ''"},
+		{"voice", "TEXT DEFAULT ''"},
+		{"chime_before", "TEXT DEFAULT ''"},
+		{"chime_after", "TEXT DEFAULT ''"},
+		{"clip", "TEXT DEFAULT ''"},
+		{"media_hash", "TEXT DEFAULT ''"},
+		{"orientation", "TEXT DEFAULT ''"},
+		{"failure_reason", "TEXT DEFAULT ''"},
+		{"acknowledged_at", "DATETIME"},
+		{"text_layout", "TEXT DEFAULT ''"},
+		{"overlay", "TEXT DEFAULT ''"},
+		{"type", "TEXT DEFAULT 'meeting'"},
+		{"device_sequence", "TEXT DEFAULT ''"},
+		{"dwell_seconds", "INTEGER DEFAULT 0"},
+		{"message_url", "TEXT DEFAULT ''"},
+		{"message_url_error", "TEXT DEFAULT ''"},
+		{"cast_mode", "TEXT DEFAULT 'auto'"},
+		{"muted", "INTEGER DEFAULT 0"},
+	}
+	for _, col := range addedColumns {
+		if err := addColumnIfMissing(tx, "notifications", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	if err := addColumnIfMissing(tx, "device_aliases", "color", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// migrateDeviceVolume adds the preferred cast volume of a device (NULL = unchanged)
+func migrateDeviceVolume(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE device_aliases ADD COLUMN volume REAL")
+	return err
+}
+
+// migrateQRCode adds the QR code drawn on a notification's image (JSON, empty = none)
+func migrateQRCode(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE notifications ADD COLUMN qr_code TEXT DEFAULT ''")
+	return err
+}
+
+// migrateAuditLog creates the audit_log table of recorded mutations (see audit.go)
+func migrateAuditLog(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME NOT NULL,
+		action TEXT NOT NULL,
+		actor TEXT NOT NULL DEFAULT '',
+		target_type TEXT NOT NULL,
+		target_id TEXT NOT NULL DEFAULT '',
+		details TEXT DEFAULT ''
+	);
+	CREATE INDEX idx_audit_log_target ON audit_log(target_type, target_id);
+	CREATE INDEX idx_audit_log_created_at ON audit_log(created_at);`)
+	return err
+}
+
+// migrateAgendaFile adds the agenda file a notification's message is read from and the
+// outcome of the last read
+func migrateAgendaFile(tx *sql.Tx) error {
+	for _, column := range []string{"agenda_file", "agenda_error"} {
+		if _, err := tx.Exec("ALTER TABLE notifications ADD COLUMN " + column + " TEXT DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTheme adds the built-in theme a notification is styled with (empty = none)
+func migrateTheme(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE notifications ADD COLUMN theme TEXT DEFAULT ''")
+	return err
+}
+
+// migrateScroll adds whether a long message scrolls in the video
+func migrateScroll(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE notifications ADD COLUMN scroll INTEGER DEFAULT 0")
+	return err
+}
+
+// migrateDeviceMessages adds the per-device message overrides (JSON, empty = none)
+func migrateDeviceMessages(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE notifications ADD COLUMN device_messages TEXT DEFAULT ''")
+	return err
+}
+
+// schemaExecer is satisfied by both *sql.DB and *sql.Tx
+type schemaExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// addColumnIfMissing adds a column to an existing table so older databases keep working
+func addColumnIfMissing(db schemaExecer, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to read %s schema: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to read %s schema: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	log.Printf("Added column %s to %s table", column, table)
+	return nil
+}
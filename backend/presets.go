@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Notification types. Each selects a preset of defaults for the fields a request leaves out.
+const (
+	notificationTypeMeeting      = "meeting"
+	notificationTypeReminder     = "reminder"
+	notificationTypeAlert        = "alert"
+	notificationTypeAnnouncement = "announcement"
+)
+
+// notificationPreset holds the defaults a notification type applies to omitted fields
+type notificationPreset struct {
+	RepeatCount int
+	Voice       string
+	Background  *Background
+	Overlay     *Overlay
+}
+
+// notificationPresets maps each type to its defaults; meeting keeps the original behavior
+var notificationPresets = map[string]notificationPreset{
+	notificationTypeMeeting: {},
+	notificationTypeReminder: {
+		RepeatCount: 1,
+		Background:  &Background{Angle: 45, Stops: []GradientStop{{Offset: 0, Color: "#11998e"}, {Offset: 1, Color: "#38ef7d"}}},
+	},
+	notificationTypeAlert: {
+		RepeatCount: 3,
+		Voice:       "en-US-Chirp-HD-D",
+		Background:  &Background{Angle: 45, Stops: []GradientStop{{Offset: 0, Color: "#cb2d3e"}, {Offset: 1, Color: "#ef473a"}}},
+		Overlay:     &Overlay{Content: "live"},
+	},
+	notificationTypeAnnouncement: {
+		RepeatCount: 2,
+		Background:  &Background{Angle: 90, Stops: []GradientStop{{Offset: 0, Color: "#1e3c72"}, {Offset: 1, Color: "#2a5298"}}},
+	},
+}
+
+// resolveNotificationType validates a type (empty = meeting) and returns it with its preset
+func resolveNotificationType(notifType string) (string, notificationPreset, error) {
+	if notifType == "" {
+		notifType = notificationTypeMeeting
+	}
+	preset, ok := notificationPresets[notifType]
+	if !ok {
+		types := make([]string, 0, len(notificationPresets))
+		for name := range notificationPresets {
+			types = append(types, name)
+		}
+		sort.Strings(types)
+		return "", notificationPreset{}, fmt.Errorf("invalid type '%s' (expected one of %s)", notifType, strings.Join(types, ", "))
+	}
+	return notifType, preset, nil
+}
+
+// applyPreset fills the fields a request left out from its type's preset
+func (req *notificationRequest) applyPreset(preset notificationPreset) {
+	if req.RepeatCount == 0 {
+		req.RepeatCount = preset.RepeatCount
+	}
+	if req.Voice == "" {
+		req.Voice = preset.Voice
+	}
+	if req.Background == nil && req.Theme == "" {
+		req.Background = preset.Background
+	}
+	if req.Overlay == nil {
+		req.Overlay = preset.Overlay
+	}
+}
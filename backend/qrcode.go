@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/fogleman/gg"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QRCode is a QR code drawn in a corner of the notification image, typically the
+// meeting's video-call join link, so viewers can join from their phone
+type QRCode struct {
+	URL      string `json:"url" xml:"url"`           // http(s) URL encoded in the code
+	Position string `json:"position" xml:"position"` // "bottom-right" (default), "bottom-left", "top-right" or "top-left"
+}
+
+const (
+	// qrMaxURLLength keeps the code at a version whose modules stay a few pixels wide
+	// at qrSizeRatio, which a phone can still resolve from across a meeting room
+	qrMaxURLLength = 200
+	// qrSizeRatio sizes the code relative to the image's shorter side (240px on 1280x800)
+	qrSizeRatio = 0.3
+	// qrMargin is the distance from the image edges, in pixels
+	qrMargin = 32
+)
+
+// validate checks the URL and position
+func (q *QRCode) validate() error {
+	parsed, err := url.Parse(q.URL)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return fmt.Errorf("invalid qr_code url '%s' (expected an http or https URL)", q.URL)
+	}
+	if len(q.URL) > qrMaxURLLength {
+		return fmt.Errorf("qr_code url cannot exceed %d characters", qrMaxURLLength)
+	}
+	switch q.Position {
+	case "", "bottom-right", "bottom-left", "top-right", "top-left":
+	default:
+		return fmt.Errorf("invalid qr_code position '%s' (expected bottom-right, bottom-left, top-right or top-left)", q.Position)
+	}
+	return nil
+}
+
+// drawQRCode draws the code in its corner. The code keeps its white quiet zone, so it
+// scans on any background; medium error correction tolerates a little glare on the screen.
+func drawQRCode(dc *gg.Context, q *QRCode, width, height int) error {
+	code, err := qrcode.New(q.URL, qrcode.Medium)
+	if err != nil {
+		return fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	size := int(float64(min(width, height)) * qrSizeRatio)
+
+	x, y := width-qrMargin-size, height-qrMargin-size
+	switch q.Position {
+	case "bottom-left":
+		x = qrMargin
+	case "top-right":
+		y = qrMargin + accentBandHeight
+	case "top-left":
+		x, y = qrMargin, qrMargin+accentBandHeight
+	}
+	dc.DrawImage(code.Image(size), x, y)
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/milkam/gochromecast/pkg/mdns"
+)
+
+// Some receivers can't play the generated video's resolution (1280x800, or 800x1280 in
+// portrait) and show a black screen instead. Discovery doesn't report resolutions, so a
+// device's limit comes from DEVICE_MAX_RESOLUTIONS or, failing that, from its model in
+// the announced names. DEVICE_RESOLUTION_POLICY decides what happens when the video is
+// too large: "warn" casts it anyway and logs a warning, "downscale" casts a copy scaled
+// to fit the device.
+var (
+	deviceMaxResolutions   = parseDeviceResolutions(envString("DEVICE_MAX_RESOLUTIONS", ""))
+	deviceResolutionPolicy = strings.ToLower(envString("DEVICE_RESOLUTION_POLICY", resolutionPolicyWarn))
+)
+
+const (
+	resolutionPolicyWarn      = "warn"
+	resolutionPolicyDownscale = "downscale"
+)
+
+// downscaleSlotWait bounds how long a cast waits for a generation slot to downscale in;
+// when none frees up the original is cast
+const downscaleSlotWait = 30 * time.Second
+
+// resolution is a width and height in pixels
+type resolution struct {
+	Width  int
+	Height int
+}
+
+func (r resolution) String() string {
+	return fmt.Sprintf("%dx%d", r.Width, r.Height)
+}
+
+// fits reports whether a video of size r plays on a device limited to max, in either
+// orientation (a receiver's limit is about pixels, not which side is longer)
+func (r resolution) fits(max resolution) bool {
+	return (r.Width <= max.Width && r.Height <= max.Height) || (r.Width <= max.Height && r.Height <= max.Width)
+}
+
+// modelResolutions are the limits of known models, matched against the announced
+// names in order (the first match wins)
+var modelResolutions = []struct {
+	Pattern    *regexp.Regexp
+	Resolution resolution
+}{
+	{regexp.MustCompile(`(?i)nest hub max`), resolution{1280, 800}},
+	{regexp.MustCompile(`(?i)nest hub|home hub`), resolution{1024, 600}},
+}
+
+// resolutionPattern matches a resolution like "1280x720"
+var resolutionPattern = regexp.MustCompile(`^(\d{2,5})x(\d{2,5})$`)
+
+// parseDeviceResolutions parses DEVICE_MAX_RESOLUTIONS: comma-separated
+// "device=WIDTHxHEIGHT" pairs, the device being a name, alias, ID or IP
+func parseDeviceResolutions(value string) map[string]resolution {
+	resolutions := make(map[string]resolution)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		device, size, ok := strings.Cut(entry, "=")
+		match := resolutionPattern.FindStringSubmatch(strings.TrimSpace(size))
+		if !ok || match == nil || strings.TrimSpace(device) == "" {
+			log.Printf("Warning: Ignoring DEVICE_MAX_RESOLUTIONS entry '%s' (expected device=WIDTHxHEIGHT)", entry)
+			continue
+		}
+		width, _ := strconv.Atoi(match[1])
+		height, _ := strconv.Atoi(match[2])
+		resolutions[strings.TrimSpace(device)] = resolution{width, height}
+	}
+	return resolutions
+}
+
+// initDeviceResolution checks DEVICE_RESOLUTION_POLICY, falling back to warn
+func initDeviceResolution() {
+	switch deviceResolutionPolicy {
+	case resolutionPolicyWarn, resolutionPolicyDownscale:
+	default:
+		log.Printf("Warning: Unknown DEVICE_RESOLUTION_POLICY '%s', using %s", deviceResolutionPolicy, resolutionPolicyWarn)
+		deviceResolutionPolicy = resolutionPolicyWarn
+	}
+}
+
+// deviceMaxResolution returns the largest video a device plays, looked up by the
+// notification's device, what it resolves to, the device's address and its announced
+// names. ok is false when the limit isn't known.
+func (a *App) deviceMaxResolution(deviceName string, device mdns.Device) (resolution, bool) {
+	keys := append([]string{deviceName, a.resolveDeviceAlias(deviceName), device.Url}, device.Names...)
+	for _, key := range keys {
+		if max, ok := deviceMaxResolutions[key]; ok {
+			return max, true
+		}
+	}
+	for _, model := range modelResolutions {
+		for _, name := range device.Names {
+			if model.Pattern.MatchString(name) {
+				return model.Resolution, true
+			}
+		}
+	}
+	return resolution{}, false
+}
+
+// CastResolution is what startCast did about a video too large for its device, kept
+// with the cast session and reported as a notification's cast_resolution
+type CastResolution struct {
+	Video          string `json:"video" xml:"video"`                                         // the generated video, e.g. "1280x800"
+	DeviceMax      string `json:"device_max" xml:"device_max"`                               // the device's limit
+	Action         string `json:"action" xml:"action"`                                       // "warned" (cast as is) or "downscaled"
+	DownscaleError string `json:"downscale_error,omitempty" xml:"downscale_error,omitempty"` // why the downscale failed (the original was cast)
+	castID         string // media directory cast instead of the notification's ("" = its own)
+}
+
+// checkCastResolution compares the video's resolution with the device's limit and, with
+// the downscale policy, produces a copy that fits. It returns nil when the video fits
+// or the limit is unknown.
+func (a *App) checkCastResolution(ctx context.Context, notifID, orientation, deviceName string, device mdns.Device) *CastResolution {
+	max, known := a.deviceMaxResolution(deviceName, device)
+	layout := layoutFor(orientation)
+	video := resolution{layout.Width, layout.Height}
+	if !known || video.fits(max) {
+		return nil
+	}
+
+	decision := &CastResolution{Video: video.String(), DeviceMax: max.String(), Action: "warned"}
+	if deviceResolutionPolicy == resolutionPolicyDownscale {
+		castID, err := a.downscaleVideo(ctx, notifID, video, max)
+		if err == nil {
+			decision.Action = "downscaled"
+			decision.castID = castID
+			log.Printf("Video of notification %s is %s but device %s plays at most %s: casting a copy scaled to fit",
+				notifID, video, deviceName, max)
+			return decision
+		}
+		decision.DownscaleError = err.Error()
+		log.Printf("Warning: Could not downscale notification %s for device %s, casting the %s original: %v",
+			notifID, deviceName, video, err)
+	}
+	log.Printf("Warning: Video of notification %s is %s but device %s plays at most %s; it may show a black screen (set DEVICE_RESOLUTION_POLICY=downscale)",
+		notifID, video, deviceName, max)
+	return decision
+}
+
+// downscaledID is the media directory of a notification's video scaled to fit max
+func downscaledID(notifID string, max resolution) string {
+	return notifID + "_" + max.String()
+}
+
+// castResolution reports what was done about the resolution of a notification's
+// active cast (nil when it isn't casting or its video fits the device)
+func (a *App) castResolution(notifID string) *CastResolution {
+	a.CastMutex.RLock()
+	session, exists := a.ActiveCasts[notifID]
+	a.CastMutex.RUnlock()
+	if !exists {
+		return nil
+	}
+	session.Mutex.RLock()
+	defer session.Mutex.RUnlock()
+	return session.Resolution
+}
+
+// downscaleVideo re-encodes a notification's HLS video to fit within max (keeping its
+// aspect ratio) into its own chunks directory, reusing a copy made from the current
+// video, and returns that directory's ID for castMediaURL. The re-encode takes one of
+// the MAX_CONCURRENT_GENERATIONS slots like any other FFmpeg job.
+func (a *App) downscaleVideo(ctx context.Context, notifID string, video, max resolution) (string, error) {
+	if !ffmpegAvailable {
+		return "", fmt.Errorf("FFmpeg is not installed")
+	}
+	source := filepath.Join(chunksDir, notifID, "playlist.m3u8")
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return "", fmt.Errorf("video not found: %w", err)
+	}
+
+	castID := downscaledID(notifID, max)
+	castDir := filepath.Join(chunksDir, castID)
+	target := filepath.Join(castDir, "playlist.m3u8")
+	if info, err := os.Stat(target); err == nil && !info.ModTime().Before(sourceInfo.ModTime()) {
+		return castID, nil
+	}
+
+	if !a.acquireGenerationSlot(ctx, downscaleSlotWait) {
+		return "", fmt.Errorf("no generation slot free within %s", downscaleSlotWait)
+	}
+	defer a.releaseGenerationSlot()
+
+	// The HLS muxer appends to an existing playlist, so an outdated copy is removed first
+	if err := os.RemoveAll(castDir); err != nil {
+		return "", fmt.Errorf("failed to clear previous copy: %w", err)
+	}
+	if err := os.MkdirAll(castDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create chunks directory: %w", err)
+	}
+
+	// Fit the longer side of the video to the matching side of the device's limit
+	fit := max
+	if (video.Width > video.Height) != (max.Width > max.Height) {
+		fit = resolution{max.Height, max.Width}
+	}
+	// Single-segment mode sizes its one segment from the duration
+	durationSeconds := 0
+	if duration, err := probeDuration(source); err == nil {
+		durationSeconds = int(math.Ceil(duration.Seconds()))
+	}
+	args := []string{"-y", "-hide_banner", "-loglevel", "error",
+		"-allowed_extensions", "ALL", // the segments may be .ts or .m4s (HLS_SEGMENT_MODE)
+		"-i", source, // the generated video
+		"-vf", fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,scale=trunc(iw/2)*2:trunc(ih/2)*2", fit.Width, fit.Height), // fit, with even dimensions for H.264
+		"-preset", "ultrafast", // fastest encoding
+		"-c:v", "libx264", // use H.264 codec
+		"-profile:v", "baseline", // quality settings
+		"-pix_fmt", "yuv420p", // pixel format for maximum compatibility
+		"-c:a", "copy", // the audio doesn't change
+		"-f", "hls", // output format is HLS
+		"-hls_list_size", "0", // keep all segments
+		"-hls_playlist_type", "event", // tell player this is an event
+		"-hls_flags", "independent_segments", // allow for streaming
+	}
+	args = append(args, hlsSegmentArgs(castDir, durationSeconds)...)
+	args = append(args,
+		"-master_pl_name", "playlist.m3u8", // create master playlist
+		filepath.Join(castDir, "playlist"), // output media playlist (no extension)
+	)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.RemoveAll(castDir)
+		return "", fmt.Errorf("failed to downscale video: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return castID, nil
+}
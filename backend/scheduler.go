@@ -1,22 +1,134 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// maxConcurrentGenerations bounds how many videos are generated at once
+	maxConcurrentGenerations = envInt("MAX_CONCURRENT_GENERATIONS", 2)
+	// maxActiveCasts bounds how many casts run at once (0 = unlimited); due notifications
+	// beyond it stay pending and are retried on the next tick
+	maxActiveCasts = max(envInt("MAX_ACTIVE_CASTS", 0), 0)
+)
+
+// preGenerationLead is how long before its start a notification's video is generated
+const preGenerationLead = 5 * time.Minute
+
+// generationRetryAfterSeconds is the Retry-After sent while a requested video is being generated
+const generationRetryAfterSeconds = 10
+
+const (
+	schedulerInterval = 10 * time.Second // normal cadence
+	// While the database keeps failing the interval doubles up to schedulerMaxBackoff,
+	// and after schedulerEscalateAfter failures in a row a single escalated error is logged
+	schedulerMaxBackoff    = 5 * time.Minute
+	schedulerEscalateAfter = 3
 )
 
 func (a *App) startScheduler() {
-	ticker := time.NewTicker(10 * time.Second) // Check every 10 seconds
-	defer ticker.Stop()
+	interval := schedulerInterval
+	failures := 0
 
-	for range ticker.C {
-		a.checkAndProcessNotifications()
+	for {
+		// On a normal tick, wake up early for a start or end due before the next tick,
+		// so casts stop at their end time rather than up to an interval later
+		if failures == 0 {
+			time.Sleep(a.untilNextTick(time.Now().UTC(), interval))
+		} else {
+			time.Sleep(interval)
+		}
+
+		err := a.checkAndProcessNotifications()
+		if err == nil {
+			if failures >= schedulerEscalateAfter {
+				log.Printf("[SCHEDULER] Database recovered after %d failed checks, back to every %s", failures, schedulerInterval)
+			}
+			failures = 0
+			interval = schedulerInterval
+			continue
+		}
+
+		failures++
+		interval = min(interval*2, schedulerMaxBackoff)
+		switch {
+		case failures < schedulerEscalateAfter:
+			log.Printf("[SCHEDULER] %v (retrying in %s)", err, interval)
+		case failures == schedulerEscalateAfter:
+			log.Printf("ERROR: [SCHEDULER] Database failing repeatedly (%d checks in a row): %v; backing off up to %s, further errors are suppressed until it recovers",
+				failures, err, schedulerMaxBackoff)
+			a.recordFailure("", "scheduler", err)
+		}
+	}
+}
+
+// untilNextTick returns how long the scheduler sleeps: the interval, or less when a
+// pending notification starts or an active one ends sooner. Start and end times are
+// stored to the second, so waking at one is exact.
+func (a *App) untilNextTick(now time.Time, interval time.Duration) time.Duration {
+	var nextAtStr sql.NullString
+	err := a.DB.QueryRow(`
+		SELECT MIN(CASE WHEN status = 'pending' THEN start_time ELSE end_time END)
+		FROM notifications
+		WHERE (status = 'pending' AND deleted_at IS NULL AND start_time > ?)
+		OR (status = 'active' AND end_time > ?)
+	`, now.Format("2006-01-02 15:04:05"), now.Format("2006-01-02 15:04:05")).Scan(&nextAtStr)
+	if err != nil || !nextAtStr.Valid {
+		return interval
+	}
+	nextAt, err := parseTimeInUTC(nextAtStr.String)
+	if err != nil {
+		return interval
+	}
+	return min(max(nextAt.Sub(now), 0), interval)
+}
+
+// markMissedNotifications marks pending notifications whose end time has passed as
+// "missed": their window went by without a cast, e.g. while the server was down or
+// because their media was never ready, and they would otherwise stay pending forever
+func (a *App) markMissedNotifications(now time.Time) {
+	cutoff := now.Format("2006-01-02 15:04:05")
+	rows, err := a.DB.Query("SELECT id FROM notifications WHERE status = 'pending' AND deleted_at IS NULL AND end_time <= ?", cutoff)
+	if err != nil {
+		log.Printf("[SCHEDULER] Error querying missed notifications: %v", err)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		_, err := a.DB.Exec(
+			"UPDATE notifications SET status = 'missed', failure_reason = ? WHERE id = ? AND status = 'pending'",
+			missedReason, id,
+		)
+		if err != nil {
+			log.Printf("[SCHEDULER] Failed to mark notification %s missed: %v", id, err)
+			continue
+		}
+		log.Printf("[SCHEDULER] Notification %s was never cast before its end time, marked missed", id)
 	}
 }
 
-func (a *App) checkAndProcessNotifications() {
+// missedReason is the failure_reason of a missed notification
+const missedReason = "the notification's window ended before it was cast"
+
+// checkAndProcessNotifications starts and stops due casts. It returns an error only when
+// the database can't be queried, so the scheduler can back off.
+func (a *App) checkAndProcessNotifications() error {
 	now := time.Now().UTC()
 
 	// Pre-generate videos for notifications starting soon (within next 5 minutes)
@@ -25,119 +137,194 @@ func (a *App) checkAndProcessNotifications() {
 
 	// Get pending notifications that should start (and haven't ended yet)
 	rows, err := a.DB.Query(`
-		SELECT id, message, start_time, end_time, device, status, repeat_count
+		SELECT `+notificationColumns+`
 		FROM notifications
 		WHERE status = 'pending' 
+		AND deleted_at IS NULL
 		AND start_time <= ? 
 		AND end_time > ?
 	`, now.Format("2006-01-02 15:04:05"), now.Format("2006-01-02 15:04:05"))
 	if err != nil {
-		log.Printf("Error querying pending notifications: %v", err)
-		return
+		return fmt.Errorf("error querying pending notifications: %w", err)
 	}
 	defer rows.Close()
 
-	for rows.Next() {
-		var notif Notification
-		var startTimeStr, endTimeStr string
-		err := rows.Scan(
-			&notif.ID,
-			&notif.Message,
-			&startTimeStr,
-			&endTimeStr,
-			&notif.Device,
-			&notif.Status,
-			&notif.RepeatCount,
-		)
-		if err != nil {
-			log.Printf("Error scanning notification row: %v", err)
-			continue
-		}
+	waitingForCast := int32(0)
+	defer func() { a.CastsWaiting.Store(waitingForCast) }()
 
-		// Parse as UTC time (handles multiple formats)
-		startTime, err := parseTimeInUTC(startTimeStr)
-		if err != nil {
-			log.Printf("Error parsing start_time '%s': %v", startTimeStr, err)
-			continue
-		}
-		notif.StartTime = startTime
-		
-		endTime, err := parseTimeInUTC(endTimeStr)
+	for rows.Next() {
+		notif, err := scanNotification(rows)
 		if err != nil {
-			log.Printf("Error parsing end_time '%s': %v", endTimeStr, err)
+			log.Printf("Error reading notification row: %v", err)
 			continue
 		}
-		notif.EndTime = endTime
+		startTime, endTime := notif.StartTime, notif.EndTime
 
 		log.Printf("[SCHEDULER DEBUG] Found pending notification %s: start=%v, end=%v, now=%v", notif.ID, startTime, endTime, now)
 
 		// Start cast if it's time (use >= for start time to catch exact matches)
 		if (now.After(notif.StartTime) || now.Equal(notif.StartTime)) && now.Before(notif.EndTime) {
 			// Check if video is ready before casting
-			playlistPath := fmt.Sprintf("./data/chunks/%s/playlist.m3u8", notif.ID)
-			if _, err := os.Stat(playlistPath); err != nil {
+			if _, err := os.Stat(a.notificationCastPath(notif)); err != nil {
 				log.Printf("[SCHEDULER] Video not ready yet for notification %s, will retry in 10 seconds", notif.ID)
 				continue
 			}
 			
 			log.Printf("[SCHEDULER] Starting cast for notification %s", notif.ID)
-			if err := a.startCast(notif.ID, notif.Device, notif.Message); err != nil {
+			err := a.startCast(notif.ID, notif.Device, notif.Message)
+			if errors.Is(err, errCastLimitReached) {
+				waitingForCast++
+				log.Printf("[SCHEDULER] Notification %s is waiting for a free cast slot (MAX_ACTIVE_CASTS %d reached)", notif.ID, maxActiveCasts)
+			} else if err != nil {
 				log.Printf("Failed to start cast for notification %s: %v", notif.ID, err)
+				a.recordFailure(notif.ID, "cast", err)
+				a.markCastFailed(notif.ID, err)
 			}
 		} else {
 			log.Printf("[SCHEDULER DEBUG] Skipping notification %s: not in time window", notif.ID)
 		}
 	}
 
+	// Pending notifications whose whole window has passed were never cast
+	a.markMissedNotifications(now)
+
+	// Keep pinned statuses playing (their clip is shorter than their open-ended window)
+	a.refreshPinnedCasts()
+
+	// Move follow-the-person casts on to their next device
+	a.advanceDeviceSequences()
+
 	// Get active notifications that should end
 	rows, err = a.DB.Query(`
-		SELECT id, message, start_time, end_time, device, status, repeat_count
+		SELECT `+notificationColumns+`
 		FROM notifications
 		WHERE status = 'active' AND end_time <= ?
 	`, now.Format("2006-01-02 15:04:05"))
 	if err != nil {
-		log.Printf("Error querying active notifications: %v", err)
-		return
+		return fmt.Errorf("error querying active notifications: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var notif Notification
-		var startTimeStr, endTimeStr string
-		err := rows.Scan(
-			&notif.ID,
-			&notif.Message,
-			&startTimeStr,
-			&endTimeStr,
-			&notif.Device,
-			&notif.Status,
-			&notif.RepeatCount,
-		)
-		if err != nil {
-			log.Printf("Error scanning active notification row: %v", err)
-			continue
-		}
-
-		// Parse as UTC time (handles multiple formats)
-		endTime, err := parseTimeInUTC(endTimeStr)
+		notif, err := scanNotification(rows)
 		if err != nil {
-			log.Printf("Error parsing end_time '%s': %v", endTimeStr, err)
+			log.Printf("Error reading active notification row: %v", err)
 			continue
 		}
-		notif.EndTime = endTime
+		endTime := notif.EndTime
 
 		log.Printf("[SCHEDULER DEBUG] Found active notification %s: end=%v, now=%v", notif.ID, endTime, now)
 
 		// Stop cast if end time reached (use >= to catch exact matches)
 		if now.After(notif.EndTime) || now.Equal(notif.EndTime) {
 			log.Printf("[SCHEDULER] Stopping cast for notification %s", notif.ID)
-			if err := a.stopCast(notif.ID); err != nil {
+			if err := a.stopCast(notif.ID, true); err != nil {
 				log.Printf("Failed to stop cast for notification %s: %v", notif.ID, err)
 			}
 		} else {
 			log.Printf("[SCHEDULER DEBUG] Not stopping notification %s yet: end time not reached", notif.ID)
 		}
 	}
+	return nil
+}
+
+// getSchedulerNext reports the next start or stop the scheduler will act on. A
+// pending notification already past its start (waiting for its video or a cast
+// slot) is due now, so in_seconds is never negative.
+func getSchedulerNext(c *fiber.Ctx) error {
+	now := time.Now().UTC()
+
+	var id, status, nextAtStr string
+	err := appInstance.ReadDB.QueryRow(`
+		SELECT id, status, CASE WHEN status = 'pending' THEN start_time ELSE end_time END AS next_at
+		FROM notifications
+		WHERE (status = 'pending' AND deleted_at IS NULL AND end_time > ?)
+		OR status = 'active'
+		ORDER BY next_at
+		LIMIT 1
+	`, now.Format("2006-01-02 15:04:05")).Scan(&id, &status, &nextAtStr)
+	if err == sql.ErrNoRows {
+		return c.JSON(fiber.Map{"next_action_at": nil, "now": now})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Database error: %v", err)})
+	}
+
+	nextAt, err := parseTimeInUTC(nextAtStr)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Invalid time for notification %s: %v", id, err)})
+	}
+	action := "stop"
+	if status == "pending" {
+		action = "start"
+	}
+
+	return c.JSON(fiber.Map{
+		"next_action_at":  nextAt,
+		"action":          action,
+		"notification_id": id,
+		"in_seconds":      max(int64(nextAt.Sub(now).Seconds()), 0),
+		"now":             now,
+	})
+}
+
+// Window of GET /api/notifications/upcoming
+const (
+	defaultUpcomingWindow = 15 * time.Minute
+	maxUpcomingWindow     = 24 * time.Hour
+)
+
+// getUpcomingNotifications lists the pending notifications starting within ?within= (a
+// Go duration, default 15m), soonest first, with their generation status, so a
+// "coming up" view can show whether each one's media is ready
+func getUpcomingNotifications(c *fiber.Ctx) error {
+	within := defaultUpcomingWindow
+	if value := c.Query("within"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 || d > maxUpcomingWindow {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("within must be a duration like 15m or 2h, at most %s", maxUpcomingWindow)})
+		}
+		within = d
+	}
+
+	notifications, err := upcomingNotifications(c, within)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+	return respond(c, notificationList{Notifications: notifications})
+}
+
+// upcomingNotifications returns the pending notifications starting within the window,
+// soonest first, with their media URLs and generation status
+func upcomingNotifications(c *fiber.Ctx, within time.Duration) ([]Notification, error) {
+	now := time.Now().UTC()
+	rows, err := appInstance.ReadDB.Query(`
+		SELECT `+notificationColumns+`
+		FROM notifications
+		WHERE status = 'pending'
+		AND deleted_at IS NULL
+		AND start_time > ?
+		AND start_time <= ?
+		ORDER BY start_time
+	`, now.Format("2006-01-02 15:04:05"), now.Add(within).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	localIP := lanIP()
+	notifications := []Notification{}
+	for rows.Next() {
+		notif, err := scanNotification(rows)
+		if err != nil {
+			log.Printf("Error reading notification: %v", err)
+			continue
+		}
+		withMediaURLs(c, &notif, localIP)
+		notif.GenerationStatus = appInstance.generationStatus(notif)
+		notifications = append(notifications, notif)
+	}
+	return notifications, nil
 }
 
 // preGenerateVideosForPendingNotifications generates videos for pending notifications
@@ -150,13 +337,14 @@ func (a *App) preGenerateVideosForPendingNotifications(now time.Time) {
 		}
 	}()
 	
-	// Look for pending notifications starting within next 5 minutes
-	futureTime := now.Add(5 * time.Minute)
+	// Look for pending notifications starting within the pre-generation lead
+	futureTime := now.Add(preGenerationLead)
 	
 	rows, err := a.DB.Query(`
-		SELECT id, message, start_time, end_time, device, status, repeat_count
+		SELECT `+notificationColumns+`
 		FROM notifications
 		WHERE status = 'pending' 
+		AND deleted_at IS NULL
 		AND start_time > ? 
 		AND start_time <= ?
 	`, now.Format("2006-01-02 15:04:05"), futureTime.Format("2006-01-02 15:04:05"))
@@ -167,100 +355,142 @@ func (a *App) preGenerateVideosForPendingNotifications(now time.Time) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var notif Notification
-		var startTimeStr, endTimeStr string
-		err := rows.Scan(
-			&notif.ID,
-			&notif.Message,
-			&startTimeStr,
-			&endTimeStr,
-			&notif.Device,
-			&notif.Status,
-			&notif.RepeatCount,
-		)
+		notif, err := scanNotification(rows)
 		if err != nil {
 			continue
 		}
 
-		// Parse times
-		startTime, err := parseTimeInUTC(startTimeStr)
-		if err != nil {
-			continue
-		}
-		endTime, err := parseTimeInUTC(endTimeStr)
-		if err != nil {
-			continue
+		if a.generateVideoIfNeeded(notif) {
+			log.Printf("Pre-generated video for notification %s starting at %v", notif.ID, notif.StartTime)
 		}
-		notif.StartTime = startTime
-		notif.EndTime = endTime
+	}
+}
 
-		// Check if video already exists (HLS playlist)
-		playlistPath := fmt.Sprintf("./data/chunks/%s/playlist.m3u8", notif.ID)
-		if _, err := os.Stat(playlistPath); err == nil {
-			// Video already exists, skip
-			continue
+// generateVideoIfNeeded builds a notification's video unless an up-to-date one already
+// exists or is already being generated. Pre-generation and eager generation both go
+// through here so they share the in-progress guard. Returns true if a video was generated.
+func (a *App) generateVideoIfNeeded(notif Notification) bool {
+	// Skip if the video (HLS playlist, or the static image without FFmpeg) already
+	// exists and was generated from the notification's current inputs
+	if mediaUpToDate(notif) {
+		return false
+	}
+	// The media of a running cast is never replaced while the receiver is still fetching
+	// it (the hash also covers settings like alias colors and blocked words, which can
+	// change mid-cast); the new inputs apply from the next cast
+	if a.isCasting(notif.ID) {
+		if _, err := os.Stat(a.notificationCastPath(notif)); err == nil {
+			log.Printf("Not regenerating the media of notification %s while it is being cast", notif.ID)
+			return false
 		}
+	}
+
+	// Check if video generation is already in progress for this notification
+	a.VideoGenMutex.Lock()
+	if a.VideoGenInProgress[notif.ID] {
+		// Already generating, skip
+		a.VideoGenMutex.Unlock()
+		return false
+	}
+	// Mark as in progress, cancellable through POST /api/generation/cancel-all
+	ctx, cancel := context.WithCancel(context.Background())
+	a.VideoGenInProgress[notif.ID] = true
+	a.VideoGenCancels[notif.ID] = cancel
+	a.VideoGenMutex.Unlock()
 
-		// Check if video generation is already in progress for this notification
+	// Ensure we clear the in-progress flag when done
+	defer func() {
 		a.VideoGenMutex.Lock()
-		if a.VideoGenInProgress[notif.ID] {
-			// Already generating, skip
-			a.VideoGenMutex.Unlock()
-			continue
-		}
-		// Mark as in progress
-		a.VideoGenInProgress[notif.ID] = true
+		delete(a.VideoGenInProgress, notif.ID)
+		delete(a.VideoGenCancels, notif.ID)
 		a.VideoGenMutex.Unlock()
+		cancel()
+	}()
 
-		// Generate video in a closure to properly handle defer cleanup
-		func(n Notification) {
-			// Ensure we clear the in-progress flag when done
-			defer func() {
-				a.VideoGenMutex.Lock()
-				delete(a.VideoGenInProgress, n.ID)
-				a.VideoGenMutex.Unlock()
-			}()
-
-			// Calculate duration
-			duration := int(n.EndTime.Sub(n.StartTime).Seconds())
-			if duration < 1 {
-				duration = 10
-			}
+	// Background generations wait as long as it takes for a slot (or until cancelled)
+	if !a.acquireGenerationSlot(ctx, 0) {
+		log.Printf("Cancelled queued video generation for notification %s", notif.ID)
+		return false
+	}
+	defer a.releaseGenerationSlot()
 
-			log.Printf("Pre-generating video for notification %s (duration: %d seconds)", n.ID, duration)
+	log.Printf("Generating video for notification %s (duration: %s)", notif.ID, notif.EndTime.Sub(notif.StartTime))
 
-			// Generate image first with times
-			imagePath, err := generateNotificationImageSimple(n.Message, n.ID, n.StartTime, n.EndTime)
-			if err != nil {
-				log.Printf("Failed to pre-generate image for notification %s: %v", n.ID, err)
-				return
-			}
+	if _, err := generateNotificationMedia(ctx, notif); err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Printf("Cancelled video generation for notification %s", notif.ID)
+			return false
+		}
+		log.Printf("Failed to generate video for notification %s: %v", notif.ID, err)
+		// A storage problem isn't this notification's failure; /api/health reports it
+		if !errors.Is(err, errStorageUnavailable) {
+			a.recordFailure(notif.ID, "generation", err)
+		}
+		return false
+	}
+	return true
+}
 
-			// Convert end time to EST for TTS
-			estLocation, err := time.LoadLocation("America/New_York")
-			if err != nil {
-				log.Printf("Warning: Could not load EST timezone for TTS, using UTC: %v", err)
-				estLocation = time.UTC
-			}
-			endTimeEST := n.EndTime.In(estLocation)
-
-			// Generate TTS audio: "Michel is in the meeting until [end_time]"
-			ttsText := fmt.Sprintf("Hi Dan, this message is to tell you that Michel is in a meeting until %s and he had this message for you: %s", endTimeEST.Format("3:04 PM"), n.Message)
-			audioPath, err := generateTTSAudio(ttsText, n.ID, n.RepeatCount)
-			if err != nil {
-				log.Printf("Failed to generate TTS audio for notification %s: %v (continuing without audio)", n.ID, err)
-				audioPath = "" // Continue without audio if TTS fails
-			}
+// generationInProgress reports whether a background generation is running for a notification
+func (a *App) generationInProgress(notifID string) bool {
+	a.VideoGenMutex.Lock()
+	defer a.VideoGenMutex.Unlock()
+	return a.VideoGenInProgress[notifID]
+}
 
-			// Generate video with audio
-			_, err = generateNotificationVideo(imagePath, n.ID, duration, audioPath)
-			if err != nil {
-				log.Printf("Failed to pre-generate video for notification %s: %v", n.ID, err)
-				return
-			}
+// acquireGenerationSlot waits for one of the MAX_CONCURRENT_GENERATIONS slots. It gives
+// up (returning false) when ctx is cancelled or, with a positive timeout, if no slot
+// frees up in time.
+func (a *App) acquireGenerationSlot(ctx context.Context, timeout time.Duration) bool {
+	a.GenerationQueued.Add(1)
+	defer a.GenerationQueued.Add(-1)
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case a.GenerationSlots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-expired:
+		return false
+	}
+}
 
-			log.Printf("Pre-generated video for notification %s starting at %v", n.ID, n.StartTime)
-		}(notif)
+// cancelAllGenerations cancels every background generation, running or waiting for a
+// slot, and returns their notification IDs. Running FFmpeg processes are killed and
+// their partial output removed; queued ones give up their place in line.
+func (a *App) cancelAllGenerations() []string {
+	a.VideoGenMutex.Lock()
+	ids := make([]string, 0, len(a.VideoGenCancels))
+	for id, cancel := range a.VideoGenCancels {
+		cancel()
+		ids = append(ids, id)
 	}
+	a.VideoGenMutex.Unlock()
+
+	log.Printf("Cancelled all video generations (%d)", len(ids))
+	return ids
+}
+
+func (a *App) releaseGenerationSlot() {
+	<-a.GenerationSlots
 }
 
+// generationStatus reports where a notification's video is: "ready", "generating" or "not_started"
+func (a *App) generationStatus(notif Notification) string {
+	if _, err := os.Stat(a.notificationCastPath(notif)); err == nil {
+		return "ready"
+	}
+
+	a.VideoGenMutex.Lock()
+	defer a.VideoGenMutex.Unlock()
+	if a.VideoGenInProgress[notif.ID] {
+		return "generating"
+	}
+	return "not_started"
+}
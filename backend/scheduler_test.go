@@ -0,0 +1,66 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// newTestApp is an App backed by a fresh in-memory database with the current schema
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening the database: %v", err)
+	}
+	// Each connection to :memory: is its own database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err := runMigrations(db); err != nil {
+		t.Fatalf("migrating the database: %v", err)
+	}
+	return &App{DB: db, ActiveCasts: make(map[string]*CastSession)}
+}
+
+// A server that comes back after a notification's window ended marks it missed instead
+// of casting it late; one whose window is still open stays pending to be cast
+func TestMarkMissedNotificationsAfterDowntime(t *testing.T) {
+	a := newTestApp(t)
+	restartedAt := time.Date(2024, time.March, 10, 10, 0, 0, 0, time.UTC)
+
+	insert := func(id string, start, end time.Time) {
+		t.Helper()
+		_, err := a.DB.Exec(
+			"INSERT INTO notifications (id, message, start_time, end_time, device, status) VALUES (?, 'Standup', ?, ?, 'Kitchen', 'pending')",
+			id, start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05"),
+		)
+		if err != nil {
+			t.Fatalf("inserting %s: %v", id, err)
+		}
+	}
+	insert("ended-while-down", restartedAt.Add(-2*time.Hour), restartedAt.Add(-time.Hour))
+	insert("still-open", restartedAt.Add(-30*time.Minute), restartedAt.Add(30*time.Minute))
+
+	a.markMissedNotifications(restartedAt)
+
+	tests := []struct {
+		id         string
+		wantStatus string
+		wantReason string
+	}{
+		{"ended-while-down", "missed", missedReason},
+		{"still-open", "pending", ""},
+	}
+	for _, tt := range tests {
+		var status, reason string
+		if err := a.DB.QueryRow("SELECT status, failure_reason FROM notifications WHERE id = ?", tt.id).Scan(&status, &reason); err != nil {
+			t.Fatalf("reading %s: %v", tt.id, err)
+		}
+		if status != tt.wantStatus || reason != tt.wantReason {
+			t.Errorf("%s: status %q, failure_reason %q, want %q, %q", tt.id, status, reason, tt.wantStatus, tt.wantReason)
+		}
+	}
+	if len(a.ActiveCasts) != 0 {
+		t.Errorf("ActiveCasts = %v, want no casts", a.ActiveCasts)
+	}
+}
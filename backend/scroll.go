@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/png"
+	"os"
+)
+
+// A scrolling notification renders its whole message on a canvas as tall as it needs
+// instead of cutting it off after MESSAGE_MAX_LINES, and the video pans down over it:
+// the first screen is held, the text scrolls at a constant speed, and the end (with
+// the times) is held again. One pass lasts the whole video unless SCROLL_SECONDS is set,
+// in which case the pass repeats.
+var scrollSeconds = max(envInt("SCROLL_SECONDS", 0), 0)
+
+const (
+	// scrollHoldSeconds is how long the top and bottom of the text stay still
+	scrollHoldSeconds = 5
+	// scrollFrameRate is the video frame rate while scrolling (static videos use 1 fps)
+	scrollFrameRate = 15
+)
+
+// scrollFilter returns the FFmpeg crop filter that pans a tall image down to the output
+// height over one scroll pass. The crop expression is evaluated per frame (t is the
+// frame time), clamped so the top and bottom holds show the ends of the image.
+func scrollFilter(durationSeconds, width, height int) string {
+	period := durationSeconds
+	if scrollSeconds > 0 && scrollSeconds < durationSeconds {
+		period = scrollSeconds
+	}
+	moving := max(period-2*scrollHoldSeconds, 1)
+	return fmt.Sprintf("crop=w=%d:h=%d:x=0:y='min(max(0,(mod(t,%d)-%d)*(ih-oh)/%d),ih-oh)'",
+		width, height, period, scrollHoldSeconds, moving)
+}
+
+// scrollImageOverflows reports whether a rendered scroll image is taller than the
+// output, i.e. whether there is anything to scroll
+func scrollImageOverflows(path string, height int) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open scroll image: %w", err)
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return false, fmt.Errorf("failed to read scroll image size: %w", err)
+	}
+	return config.Height > height, nil
+}
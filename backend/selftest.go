@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// selfTestFonts are the font files the image rendering loads
+var selfTestFonts = []string{
+	"/usr/share/fonts/dejavu/DejaVuSans-Bold.ttf",
+	"/usr/share/fonts/dejavu/DejaVuSans.ttf",
+}
+
+// selfTestStage is one step of the self-test
+type selfTestStage struct {
+	name string
+	run  func() error
+}
+
+// runSelfTest runs the generation pipeline for a synthetic notification (and optionally
+// casts it to castDevice for a few seconds), prints a PASS/FAIL line per stage and
+// returns the process exit code. Generated files are removed afterwards.
+func runSelfTest(castDevice string) int {
+	now := time.Now().UTC()
+	notif := Notification{
+		ID:          "selftest-" + uuid.New().String(),
+		Message:     "Self-test: if you can read and hear this, casting works",
+		Device:      castDevice,
+		StartTime:   now,
+		EndTime:     now.Add(30 * time.Second),
+		Status:      "pending",
+		RepeatCount: 1,
+		EndAction:   endActionStop,
+	}
+	defer cleanupSelfTest(notif.ID)
+
+	var imagePath, audioPath string
+	stages := []selfTestStage{
+		{"fonts", func() error {
+			for _, font := range selfTestFonts {
+				if _, err := os.Stat(font); err != nil {
+					return fmt.Errorf("font missing: %w", err)
+				}
+			}
+			return nil
+		}},
+		{"ffmpeg", func() error {
+			if !ffmpegAvailable {
+				return fmt.Errorf("ffmpeg not found on PATH (casts will fall back to static images)")
+			}
+			return nil
+		}},
+		{"image", func() error {
+			var err error
+			imagePath, err = generateNotificationImageSimple(notif.Message, notif.ID, notif.StartTime, notif.EndTime, nil, "", "", nil, "", nil, false)
+			return err
+		}},
+		{"tts", func() error {
+			var err error
+			audioPath, err = generateTTSAudio([]speechSegment{{Text: notif.Message}}, notif.ID, 1)
+			return err
+		}},
+		{"video", func() error {
+			if imagePath == "" {
+				return fmt.Errorf("skipped: no image")
+			}
+			_, err := generateNotificationVideo(context.Background(), []string{imagePath}, 0, notif.ID, 30*time.Second, audioPath, 1, nil, audioChimes{}, "", "", "", "")
+			return err
+		}},
+	}
+	if castDevice != "" {
+		stages = append(stages, selfTestStage{"cast", func() error {
+			if err := appInstance.startCast(notif.ID, castDevice, notif.Message); err != nil {
+				return err
+			}
+			time.Sleep(10 * time.Second)
+			return appInstance.stopCast(notif.ID, false)
+		}})
+	}
+
+	failed := 0
+	for _, stage := range stages {
+		started := time.Now()
+		err := stage.run()
+		elapsed := time.Since(started).Round(time.Millisecond)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %-7s %8s  %v\n", stage.name, elapsed, err)
+			continue
+		}
+		fmt.Printf("PASS  %-7s %8s\n", stage.name, elapsed)
+	}
+
+	if failed > 0 {
+		fmt.Printf("Self-test failed: %d of %d stages\n", failed, len(stages))
+		return 1
+	}
+	fmt.Println("Self-test passed")
+	return 0
+}
+
+// cleanupSelfTest removes the files generated for the synthetic notification
+func cleanupSelfTest(id string) {
+	os.Remove(filepath.Join(imagesDir, id+".png"))
+	os.Remove(filepath.Join(audioDir, id+"_single"+ttsAudio.Extension))
+	os.RemoveAll(filepath.Join(chunksDir, id))
+}
+
+// audioBenchmarkSeconds is the video length the audio pipelines are timed with
+const audioBenchmarkSeconds = 600
+
+// runAudioBenchmark generates the same synthetic notification with both audio pipelines
+// (the TTS is synthesized once beforehand and not timed) and prints how long each took
+func runAudioBenchmark(repeatCount int) int {
+	if !ffmpegAvailable {
+		fmt.Println("ffmpeg not found on PATH")
+		return 1
+	}
+	repeatCount = min(repeatCount, maxRepeatCount)
+
+	now := time.Now().UTC()
+	id := "bench-" + uuid.New().String()
+	defer cleanupSelfTest(id)
+	defer os.Remove(filepath.Join(audioDir, id+ttsAudio.Extension))
+
+	imagePath, err := generateNotificationImageSimple("Audio pipeline benchmark", id, now, now.Add(audioBenchmarkSeconds*time.Second), nil, "", "", nil, "", nil, false)
+	if err != nil {
+		fmt.Printf("Failed to generate image: %v\n", err)
+		return 1
+	}
+	singlePath, err := generateTTSAudio([]speechSegment{{Text: "This is the audio pipeline benchmark."}}, id, 1)
+	if err != nil {
+		fmt.Printf("Failed to generate TTS audio: %v\n", err)
+		return 1
+	}
+
+	pipelines := []selfTestStage{
+		{audioPipelineTwoPass, func() error {
+			repeated := repeatAudio(singlePath, id, repeatCount)
+			_, err := generateNotificationVideo(context.Background(), []string{imagePath}, 0, id, audioBenchmarkSeconds*time.Second, repeated, 1, nil, audioChimes{}, "", "", "", "")
+			return err
+		}},
+		{audioPipelineSingle, func() error {
+			_, err := generateNotificationVideo(context.Background(), []string{imagePath}, 0, id, audioBenchmarkSeconds*time.Second, singlePath, repeatCount, nil, audioChimes{}, "", "", "", "")
+			return err
+		}},
+	}
+
+	fmt.Printf("Audio pipelines, %d repeats, %ds video:\n", repeatCount, audioBenchmarkSeconds)
+	for _, pipeline := range pipelines {
+		// Each run starts from an empty chunks directory, as generateNotificationMedia does
+		os.RemoveAll(filepath.Join(chunksDir, id))
+		started := time.Now()
+		if err := pipeline.run(); err != nil {
+			fmt.Printf("FAIL  %-8s %v\n", pipeline.name, err)
+			return 1
+		}
+		fmt.Printf("      %-8s %8s\n", pipeline.name, time.Since(started).Round(time.Millisecond))
+	}
+	return 0
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultDwellSeconds is how long a sequenced notification stays on each device
+	defaultDwellSeconds = 300
+	// minDwellSeconds keeps hand-offs longer than a couple of scheduler ticks
+	minDwellSeconds = 30
+	// maxSequenceDevices bounds the device_sequence list
+	maxSequenceDevices = 10
+)
+
+// validateDeviceSequence checks a device_sequence (every device named, no device
+// twice in a row) and returns it with the names trimmed
+func validateDeviceSequence(sequence []string) ([]string, error) {
+	if len(sequence) < 2 {
+		return nil, errors.New("device_sequence needs at least two devices")
+	}
+	if len(sequence) > maxSequenceDevices {
+		return nil, fmt.Errorf("device_sequence cannot have more than %d devices", maxSequenceDevices)
+	}
+
+	devices := make([]string, len(sequence))
+	for i, device := range sequence {
+		devices[i] = strings.TrimSpace(device)
+		if err := validateDeviceName(devices[i]); err != nil {
+			return nil, fmt.Errorf("device_sequence entry %d: %w", i, err)
+		}
+		if i > 0 && devices[i] == devices[i-1] {
+			return nil, fmt.Errorf("device_sequence repeats %s twice in a row", devices[i])
+		}
+	}
+	return devices, nil
+}
+
+// advanceDeviceSequences moves sequenced notifications to their next device once
+// they have dwelled on the current one; the last device keeps the cast until it ends
+func (a *App) advanceDeviceSequences() {
+	rows, err := a.DB.Query(`
+		SELECT ` + notificationColumns + `
+		FROM notifications
+		WHERE status = 'active' AND device_sequence != '' AND deleted_at IS NULL
+	`)
+	if err != nil {
+		log.Printf("Error querying device sequences: %v", err)
+		return
+	}
+	var notifs []Notification
+	for rows.Next() {
+		notif, err := scanNotification(rows)
+		if err != nil {
+			log.Printf("Error reading sequenced notification row: %v", err)
+			continue
+		}
+		notifs = append(notifs, notif)
+	}
+	rows.Close()
+
+	for _, notif := range notifs {
+		a.CastMutex.RLock()
+		session, exists := a.ActiveCasts[notif.ID]
+		a.CastMutex.RUnlock()
+		if !exists {
+			continue
+		}
+
+		session.Mutex.RLock()
+		next := session.SequenceIndex + 1
+		due := session.Active && !session.Ending && next < len(notif.DeviceSequence) &&
+			time.Since(session.DeviceStartedAt) >= time.Duration(notif.DwellSeconds)*time.Second
+		session.Mutex.RUnlock()
+		if !due {
+			continue
+		}
+
+		a.handOffCast(notif, session, next)
+	}
+}
+
+// handOffCast moves a notification's cast from its current device to the device at
+// index in its sequence. A failed hand-off leaves the current device playing; it is
+// retried after another dwell.
+func (a *App) handOffCast(notif Notification, session *CastSession, index int) {
+	nextDevice := notif.DeviceSequence[index]
+	log.Printf("[SCHEDULER] Handing notification %s off from %s to %s", notif.ID, session.Device, nextDevice)
+
+	if err := a.moveCast(notif, session, nextDevice); err != nil {
+		if errors.Is(err, errStoppedDuringMove) {
+			log.Printf("Notification %s was stopped before the hand-off to %s", notif.ID, nextDevice)
+			return
+		}
+		log.Printf("Failed to hand notification %s off to %s: %v", notif.ID, nextDevice, err)
+		a.recordFailure(notif.ID, "cast", err)
+
+		session.Mutex.Lock()
+		session.DeviceStartedAt = time.Now()
+		session.Mutex.Unlock()
+		return
+	}
+
+	a.CastMutex.RLock()
+	if next, exists := a.ActiveCasts[notif.ID]; exists {
+		next.Mutex.Lock()
+		next.SequenceIndex = index
+		next.Mutex.Unlock()
+	}
+	a.CastMutex.RUnlock()
+
+	log.Printf("Notification %s is now casting to %s (device %d of %d)", notif.ID, nextDevice, index+1, len(notif.DeviceSequence))
+}
+
+// moveCast moves a running cast to another device. The new device is cast to first,
+// so when that fails the current device keeps playing. The session stays registered
+// until the new cast replaces it: a stop (the end time, a delete) during the move stops
+// the current device and the new one is not cast to (errStoppedDuringMove).
+func (a *App) moveCast(notif Notification, session *CastSession, device string) error {
+	if err := a.castTo(notif.ID, device, session); err != nil {
+		return err
+	}
+
+	// Release the previous device the same way stopCast does
+	session.Mutex.Lock()
+	session.Active = false
+	session.Mutex.Unlock()
+	if session.Cancel != nil {
+		session.Cancel()
+	}
+	if err := releaseReceiver(session); err != nil {
+		log.Printf("Warning: %v", err)
+		a.recordFailure(notif.ID, "stop", err)
+	}
+	return nil
+}
+
+// currentCastDevice is the device a notification is casting to right now ("" when idle)
+func (a *App) currentCastDevice(notifID string) string {
+	a.CastMutex.RLock()
+	session, exists := a.ActiveCasts[notifID]
+	a.CastMutex.RUnlock()
+	if !exists {
+		return ""
+	}
+	session.Mutex.RLock()
+	defer session.Mutex.RUnlock()
+	return session.Device
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/milkam/gochromecast/pkg/mdns"
+)
+
+// Device types reported in ChromecastDevice.Type
+const (
+	deviceTypeVideo   = "video"
+	deviceTypeSpeaker = "speaker" // audio-only (Google Home/Nest speakers); gets the TTS audio instead of a video
+)
+
+// Device capabilities reported in ChromecastDevice.Capabilities and filtered on with
+// GET /api/devices?capability=: every device plays audio, only video devices show video
+const (
+	capabilityVideo = "video"
+	capabilityAudio = "audio"
+)
+
+// deviceCapabilities lists the media a device of the given type can play
+func deviceCapabilities(deviceType string) []string {
+	if deviceType == deviceTypeSpeaker {
+		return []string{capabilityAudio}
+	}
+	return []string{capabilityVideo, capabilityAudio}
+}
+
+// speakerModelPattern matches the names/model info of audio-only Google speakers.
+// The mDNS client only exposes the announced names, so the model is matched there.
+var speakerModelPattern = regexp.MustCompile(`(?i)google home|home mini|nest mini|nest audio|google-home|nest-mini|nest-audio`)
+
+// speakerDevices forces devices (names, aliases, IDs or IPs) to be treated as speakers,
+// for models or IP-addressed devices discovery can't recognize (SPEAKER_DEVICES, comma separated)
+var speakerDevices = parseSpeakerDevices(envString("SPEAKER_DEVICES", ""))
+
+func parseSpeakerDevices(value string) map[string]bool {
+	devices := make(map[string]bool)
+	for _, device := range strings.Split(value, ",") {
+		if device = strings.TrimSpace(device); device != "" {
+			devices[device] = true
+		}
+	}
+	return devices
+}
+
+// speakerAudioName is the file cast to speakers from ./data/chunks/<id>/
+func speakerAudioName() string {
+	return "speech" + ttsAudio.Extension
+}
+
+// classifyDevice tells speakers from video devices using the announced names
+func classifyDevice(device mdns.Device) string {
+	if speakerDevices[device.Url] {
+		return deviceTypeSpeaker
+	}
+	for _, name := range device.Names {
+		if speakerDevices[name] || speakerModelPattern.MatchString(name) {
+			return deviceTypeSpeaker
+		}
+	}
+	return deviceTypeVideo
+}
+
+// isSpeakerDevice reports whether a notification's device (name, alias, ID or IP) is an
+// audio-only speaker, going by SPEAKER_DEVICES and the last discovery
+func (a *App) isSpeakerDevice(device string) bool {
+	target := a.resolveDeviceAlias(device)
+	if speakerDevices[device] || speakerDevices[target] {
+		return true
+	}
+	for _, known := range getCachedDevices() {
+		if known.Address == target || known.Name == target {
+			return known.Type == deviceTypeSpeaker
+		}
+	}
+	return false
+}
+
+// generateSpeakerMedia renders only the TTS audio for a speaker, skipping the image and
+// video, and places it in chunks/<castID> for the cast server. Returns the audio path.
+func generateSpeakerMedia(notif Notification, castID string) (string, GenerationMetrics, error) {
+	var metrics GenerationMetrics
+	started := time.Now()
+
+	audioPath, err := generateTTSAudio(notificationSpeech(notif), notif.ID, notif.RepeatCount)
+	if err != nil {
+		return "", metrics, fmt.Errorf("failed to generate speaker audio: %w", err)
+	}
+	metrics.TTSMs = time.Since(started).Milliseconds()
+
+	castDir := filepath.Join(chunksDir, castID)
+	if err := os.MkdirAll(castDir, 0755); err != nil {
+		return "", metrics, fmt.Errorf("failed to create chunks directory: %w", err)
+	}
+	data, err := os.ReadFile(audioPath)
+	if err != nil {
+		return "", metrics, fmt.Errorf("failed to read speaker audio: %w", err)
+	}
+	castPath := filepath.Join(castDir, speakerAudioName())
+	if err := os.WriteFile(castPath, data, 0644); err != nil {
+		return "", metrics, fmt.Errorf("failed to write speaker audio: %w", err)
+	}
+	return castPath, metrics, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// FailureRecord describes a recent cast or media generation failure
+type FailureRecord struct {
+	NotificationID string    `json:"notification_id"`
+	Stage          string    `json:"stage"` // "generation", "cast"
+	Error          string    `json:"error"`
+	Time           time.Time `json:"time"`
+}
+
+// maxRecentFailures caps how many failures are kept in memory for /api/stats
+const maxRecentFailures = 20
+
+// mediaDirs are the directories holding generated or uploaded media
+var mediaDirs = []string{imagesDir, audioDir, uploadsDir, chunksDir}
+
+var (
+	diskUsageBytes    int64
+	diskUsageComputed time.Time
+	diskUsageMutex    sync.Mutex
+)
+
+// TTS cost controls: a monthly character cap (0 = unlimited) and the price used for estimates
+var (
+	ttsMonthlyCharLimit = envInt("TTS_MONTHLY_CHAR_LIMIT", 0)
+	ttsPricePerMillion  = envFloat("TTS_PRICE_PER_MILLION_CHARS", 30.0) // Chirp HD list price in USD
+)
+
+// diskUsageCacheTTL avoids walking the media directories on every stats request
+const diskUsageCacheTTL = 1 * time.Minute
+
+// GenerationMetrics is how long each step of a notification's media generation took
+type GenerationMetrics struct {
+	ImageMs     int64     `json:"image_ms" xml:"image_ms"`
+	TTSMs       int64     `json:"tts_ms" xml:"tts_ms"` // speech and ending-soon announcement
+	VideoMs     int64     `json:"video_ms" xml:"video_ms"`
+	TotalMs     int64     `json:"total_ms" xml:"total_ms"`
+	GeneratedAt time.Time `json:"generated_at" xml:"generated_at"`
+}
+
+// recordGenerationMetrics stores a notification's generation timings and warns when
+// generating took most of the pre-generation lead (the video may not be ready in time)
+func recordGenerationMetrics(notif Notification, metrics GenerationMetrics) {
+	total := time.Duration(metrics.TotalMs) * time.Millisecond
+	log.Printf("Generated media for notification %s in %s (image %dms, TTS %dms, video %dms)",
+		notif.ID, total, metrics.ImageMs, metrics.TTSMs, metrics.VideoMs)
+	if total >= preGenerationLead*8/10 {
+		log.Printf("Warning: Generating notification %s took %s, close to the %s pre-generation lead (repeat_count %d); it may start late",
+			notif.ID, total, preGenerationLead, notif.RepeatCount)
+	}
+
+	_, err := appInstance.DB.Exec(`
+		INSERT INTO generation_metrics (notification_id, image_ms, tts_ms, video_ms, total_ms, generated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(notification_id) DO UPDATE SET
+			image_ms = excluded.image_ms, tts_ms = excluded.tts_ms, video_ms = excluded.video_ms,
+			total_ms = excluded.total_ms, generated_at = excluded.generated_at
+	`, notif.ID, metrics.ImageMs, metrics.TTSMs, metrics.VideoMs, metrics.TotalMs, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Printf("Warning: Failed to record generation metrics: %v", err)
+	}
+}
+
+// getGenerationMetrics returns a notification's last generation timings, or nil if it
+// hasn't been generated yet
+func getGenerationMetrics(db *sql.DB, notifID string) *GenerationMetrics {
+	var metrics GenerationMetrics
+	var generatedAtStr string
+	err := db.QueryRow(`
+		SELECT image_ms, tts_ms, video_ms, total_ms, generated_at
+		FROM generation_metrics WHERE notification_id = ?
+	`, notifID).Scan(&metrics.ImageMs, &metrics.TTSMs, &metrics.VideoMs, &metrics.TotalMs, &generatedAtStr)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error reading generation metrics for %s: %v", notifID, err)
+		}
+		return nil
+	}
+	metrics.GeneratedAt, _ = parseTimeInUTC(generatedAtStr)
+	return &metrics
+}
+
+// recordFailure remembers a failure so it shows up in the stats summary, and alerts
+// the operator of cast and generation failures (see alerts.go)
+func (a *App) recordFailure(notifID, stage string, err error) {
+	now := time.Now().UTC()
+	a.alertFailure(notifID, stage, err, now)
+
+	a.FailureMutex.Lock()
+	defer a.FailureMutex.Unlock()
+
+	a.RecentFailures = append(a.RecentFailures, FailureRecord{
+		NotificationID: notifID,
+		Stage:          stage,
+		Error:          err.Error(),
+		Time:           now,
+	})
+	if len(a.RecentFailures) > maxRecentFailures {
+		a.RecentFailures = a.RecentFailures[len(a.RecentFailures)-maxRecentFailures:]
+	}
+}
+
+// getRecentFailures returns a copy of the recent failures, newest first
+func (a *App) getRecentFailures() []FailureRecord {
+	a.FailureMutex.Lock()
+	defer a.FailureMutex.Unlock()
+
+	failures := make([]FailureRecord, 0, len(a.RecentFailures))
+	for i := len(a.RecentFailures) - 1; i >= 0; i-- {
+		failures = append(failures, a.RecentFailures[i])
+	}
+	return failures
+}
+
+// getMediaDiskUsage returns the total size of generated media, cached for diskUsageCacheTTL
+func getMediaDiskUsage() int64 {
+	diskUsageMutex.Lock()
+	defer diskUsageMutex.Unlock()
+
+	if time.Since(diskUsageComputed) < diskUsageCacheTTL {
+		return diskUsageBytes
+	}
+
+	var total int64
+	for _, dir := range mediaDirs {
+		// With CACHE_DIR, chunksDir is a symlink, which WalkDir doesn't follow
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+		}
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil // Missing directories just count as empty
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+			return nil
+		})
+	}
+
+	diskUsageBytes = total
+	diskUsageComputed = time.Now()
+	return total
+}
+
+// currentUsageMonth is the key for this month's TTS usage row
+func currentUsageMonth() string {
+	return time.Now().UTC().Format("2006-01")
+}
+
+// getTTSUsage returns the characters sent to TTS this month
+func getTTSUsage(db *sql.DB) (int, error) {
+	var characters int
+	err := db.QueryRow("SELECT characters FROM tts_usage WHERE month = ?", currentUsageMonth()).Scan(&characters)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return characters, err
+}
+
+// checkTTSQuota returns an error when sending text would exceed the monthly character cap,
+// so callers fall back to the no-audio path
+func checkTTSQuota(text string) error {
+	if ttsMonthlyCharLimit <= 0 {
+		return nil
+	}
+
+	used, err := getTTSUsage(appInstance.DB)
+	if err != nil {
+		return fmt.Errorf("failed to read TTS usage: %w", err)
+	}
+	if used+utf8.RuneCountInString(text) > ttsMonthlyCharLimit {
+		return fmt.Errorf("monthly TTS limit reached (%d of %d characters used)", used, ttsMonthlyCharLimit)
+	}
+	return nil
+}
+
+// recordTTSUsage adds the characters of a successful TTS request to this month's counter
+func recordTTSUsage(text string) {
+	_, err := appInstance.DB.Exec(`
+		INSERT INTO tts_usage (month, characters) VALUES (?, ?)
+		ON CONFLICT(month) DO UPDATE SET characters = characters + excluded.characters
+	`, currentUsageMonth(), utf8.RuneCountInString(text))
+	if err != nil {
+		log.Printf("Warning: Failed to record TTS usage: %v", err)
+	}
+}
+
+// getStats returns a quick operational snapshot
+func getStats(c *fiber.Ctx) error {
+	stats, err := collectStats()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+	return c.JSON(stats)
+}
+
+// collectStats builds the snapshot of GET /api/stats (also part of GET /api/dashboard)
+func collectStats() (fiber.Map, error) {
+	rows, err := appInstance.ReadDB.Query("SELECT status, COUNT(*) FROM notifications GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byStatus := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			log.Printf("Error scanning status count: %v", err)
+			continue
+		}
+		byStatus[status] = count
+	}
+
+	appInstance.CastMutex.RLock()
+	activeCasts := len(appInstance.ActiveCasts)
+	appInstance.CastMutex.RUnlock()
+
+	ttsCharacters, err := getTTSUsage(appInstance.DB)
+	if err != nil {
+		log.Printf("Error reading TTS usage: %v", err)
+	}
+	ttsStats := fiber.Map{
+		"month":              currentUsageMonth(),
+		"characters":         ttsCharacters,
+		"estimated_cost_usd": float64(ttsCharacters) / 1e6 * ttsPricePerMillion,
+	}
+	if ttsMonthlyCharLimit > 0 {
+		ttsStats["monthly_limit"] = ttsMonthlyCharLimit
+		ttsStats["remaining"] = max(ttsMonthlyCharLimit-ttsCharacters, 0)
+	}
+
+	return fiber.Map{
+		"notifications_by_status": byStatus,
+		"active_casts":            activeCasts,
+		"max_active_casts":        maxActiveCasts,
+		"casts_waiting":           appInstance.CastsWaiting.Load(),
+		"media_disk_bytes":        getMediaDiskUsage(),
+		"recent_failures":         appInstance.getRecentFailures(),
+		"tts":                     ttsStats,
+		"generation": fiber.Map{
+			"max_concurrent": cap(appInstance.GenerationSlots),
+			"running":        len(appInstance.GenerationSlots),
+			"queued":         appInstance.GenerationQueued.Load(),
+		},
+	}, nil
+}
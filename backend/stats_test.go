@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// With CACHE_DIR, chunksDir is a symlink into the cache; the chunks behind it still count
+// toward the media disk usage
+func TestMediaDiskUsageFollowsSymlinkedChunks(t *testing.T) {
+	dir := t.TempDir()
+	cache := filepath.Join(dir, "cache", "chunks")
+	if err := os.MkdirAll(filepath.Join(cache, "notification"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cache, "notification", "0.ts"), make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "chunks")
+	if err := os.Symlink(cache, link); err != nil {
+		t.Fatal(err)
+	}
+
+	savedDirs := mediaDirs
+	mediaDirs = []string{link}
+	diskUsageComputed = time.Time{}
+	t.Cleanup(func() {
+		mediaDirs = savedDirs
+		diskUsageComputed = time.Time{}
+	})
+
+	if got := getMediaDiskUsage(); got != 4096 {
+		t.Errorf("getMediaDiskUsage() = %d, want 4096", got)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/milkam/gochromecast/pkg/chromecast"
+)
+
+// statusEndTime is the open-ended end time stored for pinned statuses, so the
+// scheduler's time-window queries treat them as always running until cleared
+var statusEndTime = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// statusLoopDuration is the length of the clip generated for a pinned status;
+// the scheduler replays it shortly before it runs out
+var statusLoopDuration = envDuration("STATUS_LOOP_DURATION", 1*time.Hour)
+
+// errStatusExists is returned when a device already has a pinned status
+var errStatusExists = errors.New("a status is already pinned on this device")
+
+// startStatus pins an "I'm busy" status on a device until it is cleared
+func startStatus(c *fiber.Ctx) error {
+	var requestBody struct {
+		Message     string `json:"message"`
+		Device      string `json:"device"`
+		RepeatCount int    `json:"repeat_count"`
+	}
+	if err := c.BodyParser(&requestBody); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := validateDeviceName(requestBody.Device); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if requestBody.Message == "" {
+		requestBody.Message = "I'm busy"
+	}
+	if err := validateMessage(requestBody.Message); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	repeatCount := requestBody.RepeatCount
+	if repeatCount < 1 {
+		repeatCount = 1
+	}
+	if repeatCount > maxRepeatCount {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("repeat_count cannot exceed %d", maxRepeatCount)})
+	}
+
+	notif := Notification{
+		ID:          uuid.New().String(),
+		Message:     requestBody.Message,
+		Device:      requestBody.Device,
+		StartTime:   time.Now().UTC(),
+		EndTime:     statusEndTime,
+		Status:      "pending",
+		RepeatCount: repeatCount,
+		EndAction:   endActionStop,
+		Type:        notificationTypeMeeting,
+		CastMode:    castModeAuto,
+		Pinned:      true,
+	}
+
+	// Only one status per device: check and insert in one transaction so two
+	// concurrent requests can't both pin a status
+	var existingID string
+	err := withTx(appInstance.DB, func(tx *sql.Tx) error {
+		err := tx.QueryRow(`
+			SELECT id FROM notifications
+			WHERE pinned = 1 AND device = ? AND status != 'completed' AND deleted_at IS NULL
+		`, notif.Device).Scan(&existingID)
+		if err == nil {
+			return errStatusExists
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+		return insertNotification(tx.Stmt(appInstance.Stmts.InsertNotification), notif)
+	})
+	if err == errStatusExists {
+		return c.Status(409).JSON(fiber.Map{"error": "A status is already pinned on this device", "id": existingID})
+	}
+	if err != nil {
+		log.Printf("Failed to create status: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create status"})
+	}
+
+	// Starts right away, so build the video now rather than waiting for the scheduler
+	go appInstance.generateVideoIfNeeded(notif)
+
+	log.Printf("Pinned status %s on device %s", notif.ID, notif.Device)
+	recordAudit(auditActor(c), auditCreate, "status", notif.ID, fiber.Map{"device": notif.Device, "message": notif.Message})
+	return c.Status(201).JSON(notif)
+}
+
+// stopStatus clears pinned statuses (all of them, or only the given device's)
+func stopStatus(c *fiber.Ctx) error {
+	var requestBody struct {
+		Device string `json:"device"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&requestBody); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	query := "SELECT id FROM notifications WHERE pinned = 1 AND status != 'completed' AND deleted_at IS NULL"
+	var args []interface{}
+	if requestBody.Device != "" {
+		query += " AND device = ?"
+		args = append(args, requestBody.Device)
+	}
+
+	rows, err := appInstance.DB.Query(query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	for _, id := range ids {
+		appInstance.stopCast(id, false)
+
+		// Close the open-ended window so the record shows when it was cleared
+		_, err := appInstance.DB.Exec("UPDATE notifications SET status = 'completed', end_time = ? WHERE id = ?", now, id)
+		if err != nil {
+			log.Printf("Failed to clear status %s: %v", id, err)
+			continue
+		}
+		recordAudit(auditActor(c), auditDelete, "status", id, nil)
+	}
+
+	return c.JSON(fiber.Map{"message": "Status cleared", "cleared": len(ids)})
+}
+
+// refreshPinnedCasts replays the loop clip of pinned statuses before it runs out,
+// since their cast has no finite video covering the whole window
+func (a *App) refreshPinnedCasts() {
+	rows, err := a.DB.Query("SELECT id FROM notifications WHERE pinned = 1 AND status = 'active' AND deleted_at IS NULL")
+	if err != nil {
+		log.Printf("Error querying pinned statuses: %v", err)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	// Replay a little early so the screen never drops back to the home screen
+	replayAfter := statusLoopDuration - 30*time.Second
+
+	for _, id := range ids {
+		a.CastMutex.RLock()
+		session, exists := a.ActiveCasts[id]
+		a.CastMutex.RUnlock()
+		if !exists {
+			continue
+		}
+
+		// Speakers say the status once instead of repeating it every loop
+		session.Mutex.Lock()
+		if !session.Active || session.AudioOnly || session.ImageOnly || session.CastClient == nil || time.Since(session.StartedAt) < replayAfter {
+			session.Mutex.Unlock()
+			continue
+		}
+		session.StartedAt = time.Now()
+		// The media cast, which may be a device's variant or a downscaled copy
+		mediaURL := session.MediaURL
+		session.Mutex.Unlock()
+
+		log.Printf("[SCHEDULER] Replaying pinned status %s", id)
+		err := session.CastClient.PlayMedia(session.Context, chromecast.PlayMediaRequest{
+			ChromeCastDeviceURI: session.DeviceURI,
+			MediaURL:            mediaURL,
+		})
+		if err != nil {
+			log.Printf("Failed to replay status %s: %v", id, err)
+			a.recordFailure(id, "cast", err)
+		}
+	}
+}
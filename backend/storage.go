@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// cacheDir, when set, holds the generated images, TTS audio and HLS chunks instead of
+// the data directory, e.g. on a tmpfs when the persistent disk is slow. The database,
+// uploads and TTS cache stay on persistent storage; everything in cacheDir is
+// regenerated when it's missing.
+var cacheDir = envString("CACHE_DIR", "")
+
+// Directories of the generated media
+var (
+	imagesDir = cachePath("/data/images", "images")
+	audioDir  = cachePath("/data/audio", "audio")
+)
+
+// chunksDir holds each notification's cast media. The cast server only serves this
+// path, so with CACHE_DIR it is a symlink into the cache (see initCacheDir).
+const chunksDir = "./data/chunks"
+
+// cachePath returns the directory name under CACHE_DIR, or the default without one
+func cachePath(defaultDir, name string) string {
+	if cacheDir == "" {
+		return defaultDir
+	}
+	return filepath.Join(cacheDir, name)
+}
+
+// initCacheDir points chunksDir at CACHE_DIR. What was in chunksDir before is
+// removed: chunks are regenerated on demand.
+func initCacheDir() {
+	if cacheDir == "" {
+		return
+	}
+	target, err := filepath.Abs(filepath.Join(cacheDir, "chunks"))
+	if err == nil {
+		err = os.MkdirAll(target, 0755)
+	}
+	if err != nil {
+		log.Printf("ERROR: Can't use CACHE_DIR for chunks, keeping them in %s: %v", chunksDir, err)
+		return
+	}
+	if current, err := os.Readlink(chunksDir); err == nil && current == target {
+		return
+	}
+
+	if err := os.RemoveAll(chunksDir); err != nil {
+		log.Printf("ERROR: Can't replace %s with a link to CACHE_DIR: %v", chunksDir, err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(chunksDir), 0755); err == nil {
+		err = os.Symlink(target, chunksDir)
+	}
+	if err != nil {
+		log.Printf("ERROR: Can't link %s to %s: %v", chunksDir, target, err)
+		return
+	}
+	log.Printf("Generated media is kept in CACHE_DIR %s", cacheDir)
+}
+
+// minFreeDiskMB is the free space generation needs on every media volume
+// (MIN_FREE_DISK_MB, 0 = don't check)
+var minFreeDiskMB = envInt("MIN_FREE_DISK_MB", 200)
+
+// errStorageUnavailable marks generation refused because the media directories
+// can't be written (read-only mount, full volume)
+var errStorageUnavailable = errors.New("media storage unavailable")
+
+// checkStorage verifies every media directory can be created and written to and
+// has at least minFreeDiskMB free. It returns the free space of the fullest volume.
+func checkStorage() (freeMB int64, err error) {
+	freeMB = -1
+	for _, dir := range mediaDirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return freeMB, fmt.Errorf("%w: can't create %s: %v", errStorageUnavailable, dir, err)
+		}
+		probe, err := os.CreateTemp(dir, ".write-check-*")
+		if err != nil {
+			return freeMB, fmt.Errorf("%w: %s is not writable: %v", errStorageUnavailable, dir, err)
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(dir, &stat); err != nil {
+			continue // free space unknown; the write check passed
+		}
+		free := int64(uint64(stat.Bavail) * uint64(stat.Bsize) / (1024 * 1024))
+		if freeMB < 0 || free < freeMB {
+			freeMB = free
+		}
+		if minFreeDiskMB > 0 && free < int64(minFreeDiskMB) {
+			return freeMB, fmt.Errorf("%w: only %d MB free for %s (MIN_FREE_DISK_MB is %d)", errStorageUnavailable, free, dir, minFreeDiskMB)
+		}
+	}
+	return freeMB, nil
+}
+
+// checkStorageAtStartup reports unusable media directories once at startup instead
+// of as a cryptic error per notification; generation keeps refusing until they recover
+func checkStorageAtStartup() {
+	freeMB, err := checkStorage()
+	if err != nil {
+		log.Printf("ERROR: %v; videos won't be generated until this is fixed (see /api/health)", err)
+		return
+	}
+	if freeMB >= 0 {
+		log.Printf("Media storage is writable (%d MB free)", freeMB)
+	}
+}
+
+// getHealth reports whether the database and the media storage are usable
+// (503 when either isn't), and the TTS circuit breaker
+func getHealth(c *fiber.Ctx) error {
+	status := 200
+	health := fiber.Map{"status": "ok", "database": "ok", "storage": "ok", "time": time.Now().UTC()}
+
+	if err := appInstance.DB.Ping(); err != nil {
+		status = 503
+		health["status"] = "unhealthy"
+		health["database"] = err.Error()
+	}
+
+	freeMB, err := checkStorage()
+	if err != nil {
+		status = 503
+		health["status"] = "unhealthy"
+		health["storage"] = err.Error()
+	}
+	if freeMB >= 0 {
+		health["free_disk_mb"] = freeMB
+	}
+
+	// An open TTS breaker degrades notifications (no audio) but doesn't make the service unhealthy
+	tts := ttsBreakerState()
+	health["tts"] = tts
+	if tts["state"] == "open" && status == 200 {
+		health["status"] = "degraded"
+	}
+
+	return c.Status(status).JSON(health)
+}
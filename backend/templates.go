@@ -0,0 +1,198 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// NotificationTemplate is a reusable notification preset; only the times
+// are supplied when a notification is created from it
+type NotificationTemplate struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Message     string      `json:"message"`
+	Device      string      `json:"device"`
+	RepeatCount int         `json:"repeat_count"`
+	Voice       string      `json:"voice,omitempty"`
+	Background  *Background `json:"background,omitempty"`
+}
+
+const templateColumns = "id, name, message, device, repeat_count, voice, background"
+
+func scanTemplate(row rowScanner) (NotificationTemplate, error) {
+	var tmpl NotificationTemplate
+	var backgroundStr string
+
+	err := row.Scan(&tmpl.ID, &tmpl.Name, &tmpl.Message, &tmpl.Device, &tmpl.RepeatCount, &tmpl.Voice, &backgroundStr)
+	if err != nil {
+		return tmpl, err
+	}
+
+	if backgroundStr != "" {
+		tmpl.Background = &Background{}
+		if err := json.Unmarshal([]byte(backgroundStr), tmpl.Background); err != nil {
+			return tmpl, fmt.Errorf("error parsing background: %w", err)
+		}
+	}
+	return tmpl, nil
+}
+
+func getTemplates(c *fiber.Ctx) error {
+	rows, err := appInstance.ReadDB.Query("SELECT " + templateColumns + " FROM templates ORDER BY name")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Database error: %v", err)})
+	}
+	defer rows.Close()
+
+	templates := []NotificationTemplate{}
+	for rows.Next() {
+		tmpl, err := scanTemplate(rows)
+		if err != nil {
+			log.Printf("Error scanning template: %v", err)
+			continue
+		}
+		templates = append(templates, tmpl)
+	}
+	return c.JSON(templates)
+}
+
+func getTemplate(c *fiber.Ctx) error {
+	tmpl, err := scanTemplate(appInstance.ReadDB.QueryRow("SELECT "+templateColumns+" FROM templates WHERE id = ?", c.Params("id")))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Template not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Database error: %v", err)})
+	}
+	return c.JSON(tmpl)
+}
+
+func createTemplate(c *fiber.Ctx) error {
+	var tmpl NotificationTemplate
+	if err := c.BodyParser(&tmpl); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	tmpl.Name = strings.TrimSpace(tmpl.Name)
+	if tmpl.Name == "" || tmpl.Message == "" || tmpl.Device == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "name, message and device are required"})
+	}
+	if err := validateDeviceName(tmpl.Device); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := validateMessage(tmpl.Message); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if tmpl.RepeatCount < 1 {
+		tmpl.RepeatCount = 1
+	}
+	if tmpl.RepeatCount > maxRepeatCount {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("repeat_count cannot exceed %d", maxRepeatCount)})
+	}
+	if err := validateTTSVoice(tmpl.Voice); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	backgroundJSON := ""
+	if tmpl.Background != nil {
+		if err := tmpl.Background.validate(); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		encoded, err := json.Marshal(tmpl.Background)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid background"})
+		}
+		backgroundJSON = string(encoded)
+	}
+
+	tmpl.ID = uuid.New().String()
+	_, err := appInstance.DB.Exec(`
+		INSERT INTO templates (id, name, message, device, repeat_count, voice, background)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, tmpl.ID, tmpl.Name, tmpl.Message, tmpl.Device, tmpl.RepeatCount, tmpl.Voice, backgroundJSON)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return c.Status(409).JSON(fiber.Map{"error": "A template with this name already exists"})
+		}
+		log.Printf("Failed to create template: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create template"})
+	}
+	recordAudit(auditActor(c), auditCreate, "template", tmpl.ID, fiber.Map{"name": tmpl.Name, "device": tmpl.Device})
+
+	return c.Status(201).JSON(tmpl)
+}
+
+func deleteTemplate(c *fiber.Ctx) error {
+	result, err := appInstance.DB.Exec("DELETE FROM templates WHERE id = ?", c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete template"})
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Template not found"})
+	}
+	recordAudit(auditActor(c), auditDelete, "template", c.Params("id"), nil)
+	return c.JSON(fiber.Map{"message": "Template deleted"})
+}
+
+// createNotificationFromTemplate materializes a template into a pending
+// notification; the request body only carries start_time and end_time
+func createNotificationFromTemplate(c *fiber.Ctx) error {
+	tmpl, err := scanTemplate(appInstance.DB.QueryRow("SELECT "+templateColumns+" FROM templates WHERE id = ?", c.Params("id")))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Template not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Database error: %v", err)})
+	}
+
+	var requestBody struct {
+		StartTime string `json:"start_time"`
+		EndTime   string `json:"end_time"`
+	}
+	if err := c.BodyParser(&requestBody); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	startTime, err := parseTimeInUTC(requestBody.StartTime)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Invalid start_time format: %v", err)})
+	}
+	endTime, err := parseTimeInUTC(requestBody.EndTime)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Invalid end_time format: %v", err)})
+	}
+
+	notif := Notification{
+		ID:          uuid.New().String(),
+		Message:     tmpl.Message,
+		Device:      tmpl.Device,
+		StartTime:   startTime,
+		EndTime:     endTime,
+		Status:      "pending",
+		RepeatCount: tmpl.RepeatCount,
+		EndAction:   endActionStop,
+		Type:        notificationTypeMeeting,
+		CastMode:    castModeAuto,
+		Background:  tmpl.Background,
+		Voice:       tmpl.Voice,
+	}
+
+	if err := insertNotification(appInstance.Stmts.InsertNotification, notif); err != nil {
+		log.Printf("Failed to create notification from template %s: %v", tmpl.ID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create notification"})
+	}
+	details := notificationAuditDetails(notif)
+	details["template_id"] = tmpl.ID
+	recordAudit(auditActor(c), auditCreate, "notification", notif.ID, details)
+
+	startEagerGeneration(&notif, nil)
+	withMediaURLs(c, &notif, lanIP())
+	notif.SpeechText = speechText(notif)
+	return c.Status(201).JSON(notif)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// Test mode (CAST_TEST_MODE) replaces casting for development without a Chromecast:
+// the media a cast would play is exported to CAST_TEST_DIR and its file:// URL is
+// logged (and opened with CAST_TEST_OPEN), so the pipeline output can be checked locally.
+// Scheduling, statuses and end actions run as usual.
+var (
+	castTestMode = envBool("CAST_TEST_MODE", false)
+	castTestDir  = envString("CAST_TEST_DIR", "/data/test-casts")
+	castTestOpen = envBool("CAST_TEST_OPEN", false)
+)
+
+// startTestCast stands in for a real cast in test mode. It runs with CastMutex held by
+// startCast, and registers a session without a cast client.
+func (a *App) startTestCast(notifID, deviceName string) error {
+	audioOnly := a.isSpeakerDevice(deviceName)
+	imageOnly := false
+	if notif, err := scanNotification(a.Stmts.GetNotification.QueryRow(notifID)); err == nil {
+		imageOnly = !audioOnly && castsImage(notif)
+	}
+
+	path, err := exportTestCast(notifID, audioOnly, imageOnly)
+	if err != nil {
+		return fmt.Errorf("test mode: %w", err)
+	}
+
+	castCtx, castCancel := context.WithCancel(context.Background())
+	a.ActiveCasts[notifID] = &CastSession{
+		NotificationID:  notifID,
+		Device:          deviceName,
+		MediaURL:        "file://" + path,
+		AudioOnly:       audioOnly,
+		ImageOnly:       imageOnly,
+		Context:         castCtx,
+		Cancel:          castCancel,
+		Active:          true,
+		StartedAt:       time.Now(),
+		DeviceStartedAt: time.Now(),
+	}
+
+	if _, err := a.Stmts.SetStatus.Exec("active", notifID); err != nil {
+		log.Printf("Failed to update notification status: %v", err)
+	}
+	log.Printf("[TEST MODE] Started notification %s for device %s without casting", notifID, deviceName)
+	recordAudit(auditActorSystem, auditCastStart, "notification", notifID, map[string]any{"device": deviceName, "test_mode": true})
+	return nil
+}
+
+// exportTestCast copies the media cast for a notification (or clip) to CAST_TEST_DIR and
+// returns its absolute path. HLS is remuxed into a single MP4 any player can open.
+func exportTestCast(notifID string, audioOnly, imageOnly bool) (string, error) {
+	source := castMediaPath(notifID, audioOnly, imageOnly)
+	if _, err := os.Stat(source); err != nil {
+		return "", fmt.Errorf("media not generated: %w", err)
+	}
+	if err := os.MkdirAll(castTestDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create CAST_TEST_DIR: %w", err)
+	}
+
+	var target string
+	if castMediaName(audioOnly, imageOnly) == "playlist.m3u8" {
+		target = filepath.Join(castTestDir, notifID+".mp4")
+		cmd := exec.Command("ffmpeg", "-y", "-i", source, "-c", "copy", "-bsf:a", "aac_adtstoasc", target)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("failed to export the HLS video: %w", err)
+		}
+	} else {
+		target = filepath.Join(castTestDir, notifID+filepath.Ext(source))
+		if err := copyFile(source, target); err != nil {
+			return "", fmt.Errorf("failed to export %s: %w", source, err)
+		}
+	}
+
+	if abs, err := filepath.Abs(target); err == nil {
+		target = abs
+	}
+	log.Printf("[TEST MODE] Notification %s media: file://%s", notifID, target)
+	if castTestOpen {
+		openTestCast(target)
+	}
+	return target, nil
+}
+
+// openTestCast opens an exported file with the desktop's default application
+func openTestCast(path string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("[TEST MODE] Could not open %s: %v", path, err)
+		return
+	}
+	go cmd.Wait()
+}
+
+// copyFile copies a file, replacing the target
+func copyFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"sort"
+	"strings"
+)
+
+// theme is a named preset of background gradient and text color, so a notification
+// can be styled without hex codes
+type theme struct {
+	Background *Background
+	TextColor  string // hex color of the title, message and times
+}
+
+// themes are the built-in themes selectable with a notification's theme field
+var themes = map[string]theme{
+	"ocean": {
+		Background: &Background{Angle: 45, Stops: []GradientStop{{Offset: 0, Color: "#2193b0"}, {Offset: 1, Color: "#6dd5ed"}}},
+		TextColor:  "#ffffff",
+	},
+	"sunset": {
+		Background: &Background{Angle: 45, Stops: []GradientStop{{Offset: 0, Color: "#ee0979"}, {Offset: 1, Color: "#ff6a00"}}},
+		TextColor:  "#ffffff",
+	},
+	"forest": {
+		Background: &Background{Angle: 90, Stops: []GradientStop{{Offset: 0, Color: "#134e5e"}, {Offset: 1, Color: "#71b280"}}},
+		TextColor:  "#ffffff",
+	},
+	"mono": {
+		Background: &Background{Angle: 90, Stops: []GradientStop{{Offset: 0, Color: "#f5f5f5"}, {Offset: 1, Color: "#bdbdbd"}}},
+		TextColor:  "#212121",
+	},
+}
+
+// defaultTheme styles notifications that set neither a theme nor a background
+// (DEFAULT_THEME, empty = the default purple gradient with white text)
+var defaultTheme = envString("DEFAULT_THEME", "")
+
+// validateTheme checks a theme name (empty = none)
+func validateTheme(name string) error {
+	if _, ok := themes[name]; ok || name == "" {
+		return nil
+	}
+	names := make([]string, 0, len(themes))
+	for themeName := range themes {
+		names = append(names, themeName)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("invalid theme '%s' (expected one of %s)", name, strings.Join(names, ", "))
+}
+
+// initThemes checks DEFAULT_THEME, falling back to the default look when it is unknown
+func initThemes() {
+	if err := validateTheme(defaultTheme); err != nil {
+		log.Printf("Warning: Ignoring DEFAULT_THEME: %v", err)
+		defaultTheme = ""
+	}
+}
+
+// themeLook returns the background and text color an image is drawn with. An explicit
+// background wins over the theme's gradient (the theme's text color still applies);
+// without either, DEFAULT_THEME is used.
+func themeLook(bg *Background, themeName string) (*Background, color.Color) {
+	if themeName == "" {
+		if bg != nil {
+			return bg, color.White
+		}
+		themeName = defaultTheme
+	}
+	t, ok := themes[themeName]
+	if !ok {
+		return bg, color.White
+	}
+	if bg == nil {
+		bg = t.Background
+	}
+	textColor, _ := parseHexColor(t.TextColor) // built-in
+	return bg, textColor
+}
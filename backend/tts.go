@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	texttospeech "cloud.google.com/go/texttospeech/apiv1"
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+)
+
+// ttsProvider synthesizes speech in the selected TTS audio format (ttsAudio), 16kHz mono
+type ttsProvider interface {
+	Synthesize(segment speechSegment) ([]byte, error)
+	// ValidateVoice checks a voice name the provider can read with (empty = its default)
+	ValidateVoice(voice string) error
+	// Metered reports whether the provider is billed per character, so
+	// TTS_MONTHLY_CHAR_LIMIT applies to it
+	Metered() bool
+}
+
+// ttsProviders are the providers selectable with TTS_PROVIDER
+var ttsProviders = map[string]ttsProvider{
+	"google": googleTTS{},
+	"espeak": espeakTTS{},
+}
+
+// ttsProviderName is the provider speech is synthesized with (see initTTSProvider)
+var ttsProviderName = strings.ToLower(envString("TTS_PROVIDER", "google"))
+
+var speechProvider ttsProvider = googleTTS{}
+
+// initTTSProvider selects the provider from TTS_PROVIDER, falling back to Google for
+// an unknown name, and checks what the local provider needs is installed
+func initTTSProvider() {
+	provider, ok := ttsProviders[ttsProviderName]
+	if !ok {
+		names := make([]string, 0, len(ttsProviders))
+		for name := range ttsProviders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		log.Printf("Warning: Unknown TTS_PROVIDER '%s' (expected one of %s), using google", ttsProviderName, strings.Join(names, ", "))
+		ttsProviderName = "google"
+		return
+	}
+	speechProvider = provider
+
+	if ttsProviderName == "espeak" {
+		if _, err := exec.LookPath(espeakCommand); err != nil {
+			log.Printf("Warning: TTS_PROVIDER is espeak but %s was not found (%v); notifications will be generated without audio", espeakCommand, err)
+		} else if !ffmpegAvailable {
+			log.Printf("Warning: TTS_PROVIDER espeak needs FFmpeg to encode its audio; notifications will be generated without audio")
+		}
+	}
+	log.Printf("Using the %s TTS provider", ttsProviderName)
+}
+
+// validateTTSVoice checks a voice name with the selected provider (empty means the default voice)
+func validateTTSVoice(voice string) error {
+	return speechProvider.ValidateVoice(voice)
+}
+
+// googleTTS is Google Cloud Text-to-Speech, authenticated with the service account in
+// GOOGLE_APPLICATION_CREDENTIALS
+type googleTTS struct{}
+
+// defaultTTSVoice is the high quality female Chirp HD voice used unless a notification picks another
+const defaultTTSVoice = "en-US-Chirp-HD-F"
+
+// ttsVoicePattern matches Google voice names like "en-US-Chirp-HD-F" or "fr-CA-Neural2-A"
+var ttsVoicePattern = regexp.MustCompile(`^([a-z]{2,3}-[A-Z]{2})-[A-Za-z0-9-]+$`)
+
+func (googleTTS) ValidateVoice(voice string) error {
+	if voice != "" && !ttsVoicePattern.MatchString(voice) {
+		return fmt.Errorf("invalid voice '%s' (expected a Google TTS voice name like %s)", voice, defaultTTSVoice)
+	}
+	return nil
+}
+
+func (googleTTS) Metered() bool {
+	return true
+}
+
+// ttsVoiceParams builds the TTS voice selection, taking the language from the voice name
+func ttsVoiceParams(voice string) *texttospeechpb.VoiceSelectionParams {
+	match := ttsVoicePattern.FindStringSubmatch(voice)
+	if match == nil || voice == defaultTTSVoice {
+		return &texttospeechpb.VoiceSelectionParams{
+			LanguageCode: "en-US",
+			Name:         defaultTTSVoice,
+			SsmlGender:   texttospeechpb.SsmlVoiceGender_FEMALE,
+		}
+	}
+	return &texttospeechpb.VoiceSelectionParams{
+		LanguageCode: match[1],
+		Name:         voice,
+	}
+}
+
+// Synthesize sends one synthesis request to Google TTS
+func (googleTTS) Synthesize(segment speechSegment) ([]byte, error) {
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Create Google Cloud TTS client
+	client, err := texttospeech.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TTS client: %w", err)
+	}
+	defer client.Close()
+
+	// Build the TTS request
+	req := &texttospeechpb.SynthesizeSpeechRequest{
+		Input: &texttospeechpb.SynthesisInput{
+			InputSource: &texttospeechpb.SynthesisInput_Text{Text: segment.Text},
+		},
+		Voice: ttsVoiceParams(segment.Voice),
+		AudioConfig: &texttospeechpb.AudioConfig{
+			AudioEncoding:   ttsAudio.Encoding, // MP3 by default, see TTS_AUDIO_ENCODING
+			SpeakingRate:    ttsSpeakingRate,   // 1.0 = normal speed, see TTS_SPEAKING_RATE
+			Pitch:           0.0,               // Normal pitch
+			SampleRateHertz: 16000,             // 16kHz - lower quality, faster generation
+		},
+	}
+
+	// Perform the TTS request
+	resp, err := client.SynthesizeSpeech(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to synthesize speech: %w", err)
+	}
+	return resp.AudioContent, nil
+}
+
+// espeakTTS runs eSpeak NG locally: no credentials, no network and no cost, at the
+// price of a robotic voice. Its WAV output is encoded to the TTS audio format with FFmpeg.
+type espeakTTS struct{}
+
+var (
+	// espeakCommand is the eSpeak NG binary
+	espeakCommand = envString("ESPEAK_COMMAND", "espeak-ng")
+	// espeakVoice is the voice used when a notification doesn't pick one
+	espeakVoice = envString("ESPEAK_VOICE", "en-us")
+)
+
+// espeakVoicePattern matches eSpeak voice names like "en-us", "fr" or "en-gb+f3" (a
+// language with an optional variant)
+var espeakVoicePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]+)*(\+[a-z0-9]+)?$`)
+
+// espeakWordsPerMinute is eSpeak's default speed, scaled by TTS_SPEAKING_RATE
+const espeakWordsPerMinute = 175
+
+// ValidateVoice accepts eSpeak voice names, and Google voice names (read in their
+// language), so notifications and presets written for Google keep working
+func (espeakTTS) ValidateVoice(voice string) error {
+	if voice != "" && !espeakVoicePattern.MatchString(voice) && !ttsVoicePattern.MatchString(voice) {
+		return fmt.Errorf("invalid voice '%s' (expected an eSpeak voice name like %s)", voice, espeakVoice)
+	}
+	return nil
+}
+
+func (espeakTTS) Metered() bool {
+	return false
+}
+
+// voice maps a notification's voice to an eSpeak voice
+func (espeakTTS) voice(voice string) string {
+	if espeakVoicePattern.MatchString(voice) {
+		return voice
+	}
+	if match := ttsVoicePattern.FindStringSubmatch(voice); match != nil {
+		return strings.ToLower(match[1])
+	}
+	return espeakVoice
+}
+
+// Synthesize runs eSpeak and encodes its output to the TTS audio format
+func (e espeakTTS) Synthesize(segment speechSegment) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// The text is passed on stdin, so a message starting with "-" isn't read as an option
+	speak := exec.CommandContext(ctx, espeakCommand,
+		"-v", e.voice(segment.Voice),
+		"-s", strconv.Itoa(int(espeakWordsPerMinute*ttsSpeakingRate)), // words per minute
+		"--stdin", "--stdout")
+	speak.Stdin = strings.NewReader(segment.Text)
+	wav, err := speak.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to synthesize speech with %s: %w", espeakCommand, err)
+	}
+
+	// Same encoding, sample rate and channels as Google's output, so the rest of the
+	// pipeline (cache, concat, stream copy) can't tell the providers apart
+	encode := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-loglevel", "error",
+		"-f", "wav", "-i", "pipe:0",
+		"-ar", "16000", "-ac", "1",
+		"-c:a", ttsAudio.FFmpegEncoder,
+		"-f", strings.TrimPrefix(ttsAudio.Extension, "."),
+		"pipe:1")
+	encode.Stdin = bytes.NewReader(wav)
+	var stderr bytes.Buffer
+	encode.Stderr = &stderr
+	audio, err := encode.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode speech: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return audio, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// The TTS circuit breaker stops calling Google TTS while it is failing: after
+// TTS_BREAKER_THRESHOLD consecutive failed requests (0 = off) it opens, and requests
+// fail right away for TTS_BREAKER_COOLDOWN, so generation takes the no-audio path
+// instead of waiting on timeouts. After the cooldown one request is let through: a
+// success closes the breaker, a failure opens it for another cooldown.
+var (
+	ttsBreakerThreshold = max(envInt("TTS_BREAKER_THRESHOLD", 3), 0)
+	ttsBreakerCooldown  = envDuration("TTS_BREAKER_COOLDOWN", 5*time.Minute)
+)
+
+var errTTSBreakerOpen = errors.New("TTS is unavailable (circuit breaker open after repeated failures)")
+
+// ttsBreaker is the breaker state, shared by every generation
+var ttsBreaker struct {
+	sync.Mutex
+	Failures  int       // consecutive failed requests
+	OpenUntil time.Time // requests fail fast until then
+	Probing   bool      // a request is testing the API after the cooldown
+	LastError string
+}
+
+// ttsBreakerAllow reports whether a TTS request may be sent. Once the cooldown has
+// passed, only one request at a time probes the API.
+func ttsBreakerAllow() error {
+	if ttsBreakerThreshold == 0 {
+		return nil
+	}
+	ttsBreaker.Lock()
+	defer ttsBreaker.Unlock()
+
+	if ttsBreaker.Failures < ttsBreakerThreshold {
+		return nil
+	}
+	if time.Now().Before(ttsBreaker.OpenUntil) || ttsBreaker.Probing {
+		return errTTSBreakerOpen
+	}
+	ttsBreaker.Probing = true
+	return nil
+}
+
+// ttsBreakerRecord updates the breaker with the outcome of a TTS request
+func ttsBreakerRecord(err error) {
+	if ttsBreakerThreshold == 0 {
+		return
+	}
+	ttsBreaker.Lock()
+	defer ttsBreaker.Unlock()
+
+	ttsBreaker.Probing = false
+	if err == nil {
+		if ttsBreaker.Failures >= ttsBreakerThreshold {
+			log.Printf("TTS circuit breaker closed: TTS requests are succeeding again")
+		}
+		ttsBreaker.Failures = 0
+		ttsBreaker.LastError = ""
+		return
+	}
+
+	ttsBreaker.Failures++
+	ttsBreaker.LastError = err.Error()
+	if ttsBreaker.Failures >= ttsBreakerThreshold {
+		ttsBreaker.OpenUntil = time.Now().Add(ttsBreakerCooldown)
+		log.Printf("Warning: TTS circuit breaker open after %d consecutive failures (last: %v); notifications are generated without audio until %s",
+			ttsBreaker.Failures, err, ttsBreaker.OpenUntil.Format(time.RFC3339))
+	}
+}
+
+// ttsBreakerState reports the breaker for /api/health: "closed", "open" (failing
+// fast) or "half_open" (the cooldown has passed and the next request probes the API)
+func ttsBreakerState() map[string]any {
+	if ttsBreakerThreshold == 0 {
+		return map[string]any{"state": "disabled"}
+	}
+	ttsBreaker.Lock()
+	defer ttsBreaker.Unlock()
+
+	state := map[string]any{"state": "closed", "consecutive_failures": ttsBreaker.Failures}
+	if ttsBreaker.Failures >= ttsBreakerThreshold {
+		state["state"] = "half_open"
+		if time.Now().Before(ttsBreaker.OpenUntil) {
+			state["state"] = "open"
+			state["open_until"] = ttsBreaker.OpenUntil
+		}
+	}
+	if ttsBreaker.LastError != "" {
+		state["last_error"] = ttsBreaker.LastError
+	}
+	return state
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Build information, injected at build time:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// ffmpegVersion is the installed FFmpeg's version, read once at startup ("" without FFmpeg)
+var ffmpegVersion string
+
+// readFFmpegVersion parses the version out of the first line of `ffmpeg -version`
+// ("ffmpeg version 6.1.1 Copyright ...")
+func readFFmpegVersion() string {
+	out, err := exec.Command("ffmpeg", "-version").Output()
+	if err != nil {
+		log.Printf("Warning: Could not read the FFmpeg version: %v", err)
+		return ""
+	}
+	firstLine, _, _ := strings.Cut(string(out), "\n")
+	fields := strings.Fields(firstLine)
+	if len(fields) >= 3 && fields[0] == "ffmpeg" && fields[1] == "version" {
+		return fields[2]
+	}
+	return strings.TrimSpace(firstLine)
+}
+
+// getVersion reports the build and environment details useful in bug reports
+func getVersion(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"version":    version,
+		"commit":     commit,
+		"build_date": buildDate,
+		"go_version": runtime.Version(),
+		"ffmpeg":     ffmpegVersion,
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// webhookToken authorizes inbound webhook calls; the endpoint is disabled when unset
+var webhookToken = envString("WEBHOOK_TOKEN", "")
+
+// webhookSecret, when set, also requires every webhook call to be signed with it: the
+// X-Webhook-Signature header carries "sha256=" and the hex HMAC-SHA256 of
+// "<X-Webhook-Timestamp>.<raw body>", and the timestamp (Unix seconds) must be within
+// webhookSignatureTolerance of now, so a captured request can't be replayed later
+var webhookSecret = envString("WEBHOOK_SECRET", "")
+
+// webhookSignatureTolerance is how far a signed request's timestamp may be from now
+const webhookSignatureTolerance = 5 * time.Minute
+
+// Limits for the webhook's narrow contract
+const (
+	maxWebhookMessageLength   = 500
+	maxWebhookDurationMinutes = 8 * 60
+	defaultWebhookDuration    = 30
+)
+
+// handleWebhook creates a notification starting now from a simplified payload.
+// It is meant for low-trust external automations (IFTTT, calendar services), so it only
+// accepts a message, a duration in minutes and a device, and uses its own token.
+func handleWebhook(c *fiber.Ctx) error {
+	if webhookToken == "" {
+		return c.Status(404).JSON(fiber.Map{"error": "Webhook is not enabled"})
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Params("token")), []byte(webhookToken)) != 1 {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid webhook token"})
+	}
+	if err := verifyWebhookSignature(c, time.Now()); err != nil {
+		log.Printf("Rejected webhook call from %s: %v", auditActor(c), err)
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var payload struct {
+		Message  string `json:"message"`
+		Duration int    `json:"duration"` // minutes
+		Device   string `json:"device"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	payload.Message = strings.TrimSpace(payload.Message)
+	if payload.Message == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "message is required"})
+	}
+	if utf8.RuneCountInString(payload.Message) > maxWebhookMessageLength {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("message cannot exceed %d characters", maxWebhookMessageLength)})
+	}
+	if err := validateMessage(payload.Message); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := validateDeviceName(payload.Device); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if payload.Duration == 0 {
+		payload.Duration = defaultWebhookDuration
+	}
+	if payload.Duration < 1 || payload.Duration > maxWebhookDurationMinutes {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("duration must be between 1 and %d minutes", maxWebhookDurationMinutes)})
+	}
+
+	now := time.Now().UTC()
+	notif := Notification{
+		ID:          uuid.New().String(),
+		Message:     payload.Message,
+		Device:      payload.Device,
+		StartTime:   now,
+		EndTime:     now.Add(time.Duration(payload.Duration) * time.Minute),
+		Status:      "pending",
+		RepeatCount: 1,
+		EndAction:   endActionStop,
+		Type:        notificationTypeMeeting,
+		CastMode:    castModeAuto,
+	}
+
+	if err := insertNotification(appInstance.Stmts.InsertNotification, notif); err != nil {
+		log.Printf("Failed to create notification from webhook: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create notification"})
+	}
+
+	log.Printf("Created notification %s from webhook", notif.ID)
+	recordAudit(auditActorWebhook, auditCreate, "notification", notif.ID, notificationAuditDetails(notif))
+
+	// Only echo back what the caller needs
+	return c.Status(201).JSON(fiber.Map{
+		"id":         notif.ID,
+		"start_time": notif.StartTime,
+		"end_time":   notif.EndTime,
+	})
+}
+
+// verifyWebhookSignature checks a webhook call's signature against WEBHOOK_SECRET (see
+// webhookSecret); without a secret, the token alone authorizes the call
+func verifyWebhookSignature(c *fiber.Ctx, now time.Time) error {
+	if webhookSecret == "" {
+		return nil
+	}
+
+	signature, ok := strings.CutPrefix(c.Get("X-Webhook-Signature"), "sha256=")
+	if !ok || signature == "" {
+		return errors.New("Missing webhook signature")
+	}
+	timestamp := c.Get("X-Webhook-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("Missing or invalid X-Webhook-Timestamp")
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > webhookSignatureTolerance || age < -webhookSignatureTolerance {
+		return errors.New("Webhook timestamp is too far from the current time")
+	}
+
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(c.Body())
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+		return errors.New("Invalid webhook signature")
+	}
+	return nil
+}
@@ -31,7 +31,9 @@ async function loadDevices() {
         devices.forEach(device => {
             const option = document.createElement('option');
             option.value = device.name;  // Use device name instead of UUID
-            option.textContent = `${device.name} (${device.address})`;
+            option.textContent = device.online
+                ? `${device.name} (${device.address})`
+                : `${device.name} (offline)`;
             deviceSelect.appendChild(option);
         });
     } catch (error) {
@@ -71,7 +73,9 @@ async function loadNotifications() {
 
 function createNotificationCard(notif) {
     const startTime = new Date(notif.start_time).toLocaleString();
-    const endTime = new Date(notif.end_time).toLocaleString();
+    const endTime = notif.pinned && notif.status !== 'completed'
+        ? 'Until cleared'
+        : new Date(notif.end_time).toLocaleString();
     const statusClass = notif.status;
     
     return `
@@ -99,6 +103,7 @@ async function handleSubmit(e) {
         start_time: new Date(document.getElementById('startTime').value).toISOString(),
         end_time: new Date(document.getElementById('endTime').value).toISOString(),
         repeat_count: parseInt(document.getElementById('repeatCount').value) || 1,
+        ending_soon_minutes: parseInt(document.getElementById('endingSoonMinutes').value) || 0,
     };
     
     if (!formData.device) {